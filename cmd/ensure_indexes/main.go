@@ -0,0 +1,62 @@
+// ensure_indexes creates the indexes declared by the models in tenant databases:
+//
+//	go run ./cmd/ensure_indexes -uri mongodb://localhost:27017 -db tenant1,tenant2 [-dry-run] [-drop] [-timeout 5m]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	dbcontext "github.com/unvs/libs/db/ctx"
+	"github.com/unvs/models"
+)
+
+func main() {
+	uri := flag.String("uri", "mongodb://localhost:27017", "mongodb connection string")
+	dbNames := flag.String("db", "", "comma separated names of the databases")
+	dryRun := flag.Bool("dry-run", false, "only report what would change")
+	drop := flag.Bool("drop", false, "drop the indexes which are not declared and recreate the changed ones")
+	timeout := flag.Duration("timeout", 5*time.Minute, "time limit of each database")
+	flag.Parse()
+	if *dbNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := models.Register(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var opts []dbcontext.IndexOption
+	if *dryRun {
+		opts = append(opts, dbcontext.WithDryRun())
+	}
+	if *drop {
+		opts = append(opts, dbcontext.WithDropExtra())
+	}
+	cnn, err := dbcontext.NewDBContext(*uri)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, name := range strings.Split(*dbNames, ",") {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		reports, err := dbcontext.EnsureIndexesWith(ctx, cnn.GetDB(strings.TrimSpace(name)), opts)
+		cancel()
+		for _, r := range reports {
+			fmt.Printf("%s.%s: existing %v, created %v, changed %v, extra %v, dropped %v\n",
+				name, r.Collection, r.Existing, r.Created, r.Changed, r.Extra, r.Dropped)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
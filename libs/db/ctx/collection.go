@@ -0,0 +1,165 @@
+package dbcontext
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/unvs/libs/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection is the storage the CRUD functions run against. The collections of a DB are the mongo collections
+// of its client, unless the DB was made by NewStoreDB: dbtest.NewFake keeps them in memory.
+type Collection interface {
+	Find(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]bson.Raw, error)
+	Cursor(ctx context.Context, filter bson.D, opts *options.FindOptions) (Cursor, error)
+	Count(ctx context.Context, filter bson.D) (int64, error)
+	// Insert returns the _id of the inserted documents, on a failure the ones inserted before it.
+	Insert(ctx context.Context, docs []interface{}) ([]interface{}, error)
+	Update(ctx context.Context, filter bson.D, update bson.D, many bool) (*mongo.UpdateResult, error)
+	Delete(ctx context.Context, filter bson.D, many bool) (*mongo.DeleteResult, error)
+	ListIndexes(ctx context.Context) ([]models.Index, error)
+	CreateIndexes(ctx context.Context, indexes []models.Index) error
+	DropIndex(ctx context.Context, name string) error
+}
+
+// Cursor iterates the documents of a find statement.
+type Cursor interface {
+	Next(ctx context.Context) (bson.Raw, bool)
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// Store provides the collections of a DB which has no mongo client.
+type Store interface {
+	Collection(name string) Collection
+}
+
+// NewStoreDB returns a DB whose collections are provided by store instead of a mongo client.
+func NewStoreDB(dbName string, store Store) *DB {
+	return &DB{DBName: dbName, store: store}
+}
+
+func (db *DB) collection(name string) Collection {
+	if db.store != nil {
+		return db.store.Collection(name)
+	}
+	return mongoCollection{db.Client.Database(db.DBName, options.Database().SetRegistry(models.Registry)).Collection(name)}
+}
+
+type mongoCollection struct {
+	coll *mongo.Collection
+}
+
+func (c mongoCollection) Find(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]bson.Raw, error) {
+	cursor, err := c.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.Raw
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (c mongoCollection) Cursor(ctx context.Context, filter bson.D, opts *options.FindOptions) (Cursor, error) {
+	cur, err := c.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return mongoCursor{cur}, nil
+}
+
+type mongoCursor struct {
+	cur *mongo.Cursor
+}
+
+func (c mongoCursor) Next(ctx context.Context) (bson.Raw, bool) {
+	if !c.cur.Next(ctx) {
+		return nil, false
+	}
+	return c.cur.Current, true
+}
+
+func (c mongoCursor) Err() error {
+	return c.cur.Err()
+}
+
+func (c mongoCursor) Close(ctx context.Context) error {
+	return c.cur.Close(ctx)
+}
+
+func (c mongoCollection) Count(ctx context.Context, filter bson.D) (int64, error) {
+	return c.coll.CountDocuments(ctx, filter)
+}
+
+func (c mongoCollection) Insert(ctx context.Context, docs []interface{}) ([]interface{}, error) {
+	result, err := c.coll.InsertMany(ctx, docs)
+	var bulk mongo.BulkWriteException
+	switch {
+	case err == nil:
+		return result.InsertedIDs, nil
+	case result != nil && errors.As(err, &bulk) && len(bulk.WriteErrors) > 0:
+		// the inserts are ordered, the documents from the first failure on are not inserted
+		return result.InsertedIDs[:bulk.WriteErrors[0].Index], err
+	}
+	return nil, err
+}
+
+func (c mongoCollection) Update(ctx context.Context, filter bson.D, update bson.D, many bool) (*mongo.UpdateResult, error) {
+	if many {
+		return c.coll.UpdateMany(ctx, filter, update)
+	}
+	return c.coll.UpdateOne(ctx, filter, update)
+}
+
+func (c mongoCollection) Delete(ctx context.Context, filter bson.D, many bool) (*mongo.DeleteResult, error) {
+	if many {
+		return c.coll.DeleteMany(ctx, filter)
+	}
+	return c.coll.DeleteOne(ctx, filter)
+}
+
+func (c mongoCollection) ListIndexes(ctx context.Context) ([]models.Index, error) {
+	cursor, err := c.coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var specs []bson.D
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+	indexes := make([]models.Index, len(specs))
+	for i, spec := range specs {
+		indexes[i] = indexFromSpec(spec)
+	}
+	return indexes, nil
+}
+
+func (c mongoCollection) CreateIndexes(ctx context.Context, indexes []models.Index) error {
+	list := make([]mongo.IndexModel, len(indexes))
+	for i, idx := range indexes {
+		opts := options.Index().SetName(idx.Name)
+		if idx.Unique {
+			opts.SetUnique(true)
+		}
+		if idx.Sparse {
+			opts.SetSparse(true)
+		}
+		if idx.TTL {
+			opts.SetExpireAfterSeconds(int32(idx.ExpireAfter / time.Second))
+		}
+		list[i] = mongo.IndexModel{Keys: idx.Keys, Options: opts}
+	}
+	_, err := c.coll.Indexes().CreateMany(ctx, list)
+	return err
+}
+
+func (c mongoCollection) DropIndex(ctx context.Context, name string) error {
+	_, err := c.coll.Indexes().DropOne(ctx, name)
+	return err
+}
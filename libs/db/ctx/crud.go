@@ -0,0 +1,273 @@
+package dbcontext
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/unvs/libs/db/expr"
+	"github.com/unvs/libs/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// The CRUD functions work on the collection of a model, named by the table tag of one of its fields:
+//
+//	type Accounts struct {
+//		tableName struct{} `table:"accounts"`
+//		Username  string   `field:"username"`
+//	}
+//
+// Models are encoded and decoded with models.Registry, the document keys are given by the field tags.
+// Filters and find statements are written in the expr language with ? placeholders and the field names
+// of the model, e.g. Find[Accounts](ctx, db, "Username == ? order by Username limit 10", "admin").
+// An empty filter matches every document, ctx bounds the operation.
+
+// CollectionName returns the collection of the model T, from the table tag of one of its fields.
+func CollectionName[T any]() (string, error) {
+	m, err := models.For[T]()
+	if err != nil {
+		return "", err
+	}
+	if m.Collection == "" {
+		return "", fmt.Errorf("model %s has no table tag", m.Type)
+	}
+	return m.Collection, nil
+}
+
+func collectionOf[T any](db *DB) (Collection, error) {
+	name, err := CollectionName[T]()
+	if err != nil {
+		return nil, err
+	}
+	return db.collection(name), nil
+}
+
+// compileFilter compiles a filter, nil is returned for an empty filter.
+func compileFilter(filter string, compile func(string, ...expr.CompileOption) (*expr.Query, error)) (*expr.Query, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+	return compile(filter)
+}
+
+// bindFilter binds the placeholders of a compiled filter, the nil filter matches every document.
+func bindFilter(q *expr.Query, args []interface{}) (bson.D, error) {
+	if q == nil {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("an empty filter takes no arguments, got %d", len(args))
+		}
+		return bson.D{}, nil
+	}
+	return q.Bind(args...)
+}
+
+func filterOf(filter string, compile func(string, ...expr.CompileOption) (*expr.Query, error), args []interface{}) (bson.D, error) {
+	q, err := compileFilter(filter, compile)
+	if err != nil {
+		return nil, err
+	}
+	return bindFilter(q, args)
+}
+
+// findQuery compiles a find statement for T, see expr.ParseFindFor.
+func findQuery[T any](query string, args []interface{}) (*expr.FindQuery, error) {
+	if strings.TrimSpace(query) == "" {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("an empty query takes no arguments, got %d", len(args))
+		}
+		return &expr.FindQuery{Filter: bson.D{}}, nil
+	}
+	return expr.ParseFindFor[T](query, args...)
+}
+
+func decodeAll[T any](docs []bson.Raw) ([]T, error) {
+	result := make([]T, len(docs))
+	for i, doc := range docs {
+		if err := models.Unmarshal(doc, &result[i]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// FindOne returns the first document of T matching the find statement, mongo.ErrNoDocuments when none does.
+func FindOne[T any](ctx context.Context, db *DB, query string, args ...interface{}) (T, error) {
+	var result T
+	q, err := findQuery[T](query, args)
+	if err != nil {
+		return result, err
+	}
+	coll, err := collectionOf[T](db)
+	if err != nil {
+		return result, err
+	}
+	docs, err := coll.Find(ctx, q.Filter, q.FindOptions().SetLimit(1))
+	if err != nil {
+		return result, err
+	}
+	if len(docs) == 0 {
+		return result, driver.ErrNoDocuments
+	}
+	err = models.Unmarshal(docs[0], &result)
+	return result, err
+}
+
+// Find returns the documents of T matching the find statement, which may sort, limit and project them.
+func Find[T any](ctx context.Context, db *DB, query string, args ...interface{}) ([]T, error) {
+	q, err := findQuery[T](query, args)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := collectionOf[T](db)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := coll.Find(ctx, q.Filter, q.FindOptions())
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[T](docs)
+}
+
+// Count returns the number of documents of T matching the filter.
+func Count[T any](ctx context.Context, db *DB, filter string, args ...interface{}) (int64, error) {
+	f, err := filterOf(filter, expr.CompileFor[T], args)
+	if err != nil {
+		return 0, err
+	}
+	coll, err := collectionOf[T](db)
+	if err != nil {
+		return 0, err
+	}
+	return coll.Count(ctx, f)
+}
+
+// checkIDs rejects the documents with a zero _id which cannot hold a generated one. The codec does not store
+// a zero _id and the driver generates an ObjectID instead, which only an ObjectID or interface{} field can decode.
+func checkIDs[T any](docs []T) error {
+	m, err := models.For[T]()
+	if err != nil || m.ID == nil {
+		return err
+	}
+	t := m.ID.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(primitive.ObjectID{}) || t.Kind() == reflect.Interface {
+		return nil
+	}
+	for i, doc := range docs {
+		v := reflect.Indirect(reflect.ValueOf(doc))
+		if !v.IsValid() {
+			continue
+		}
+		if id, err := v.FieldByIndexErr(m.ID.Index); err == nil && id.IsZero() {
+			return fmt.Errorf("document %d of %s has a zero %s, no ObjectID can be generated for its type %s", i, m.Type, m.ID.Name, m.ID.Type)
+		}
+	}
+	return nil
+}
+
+// InsertOne inserts a document of T, a document with a zero _id gets a new ObjectID when its ID field can hold
+// one (primitive.ObjectID or interface{}), it is rejected otherwise.
+func InsertOne[T any](ctx context.Context, db *DB, doc T) (*driver.InsertOneResult, error) {
+	coll, err := collectionOf[T](db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIDs([]T{doc}); err != nil {
+		return nil, err
+	}
+	ids, err := coll.Insert(ctx, []interface{}{doc})
+	if err != nil {
+		return nil, err
+	}
+	return &driver.InsertOneResult{InsertedID: ids[0]}, nil
+}
+
+// InsertMany inserts documents of T in order, it stops at the first document which cannot be inserted and
+// returns the _id of the documents inserted before it with the error. Zero IDs are handled like by InsertOne.
+func InsertMany[T any](ctx context.Context, db *DB, docs []T) (*driver.InsertManyResult, error) {
+	if len(docs) == 0 {
+		return &driver.InsertManyResult{}, nil
+	}
+	coll, err := collectionOf[T](db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIDs(docs); err != nil {
+		return nil, err
+	}
+	items := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		items[i] = doc
+	}
+	ids, err := coll.Insert(ctx, items)
+	return &driver.InsertManyResult{InsertedIDs: ids}, err
+}
+
+// UpdateOne applies an update expression (see expr.ParseUpdate) to the first document of T matching the filter:
+//
+//	UpdateOne[File](ctx, db, "Name == ?", "Set(Size, ?), Inc(Version, 1)", "report.pdf", 2048)
+//
+// args are bound to the placeholders of the filter first, then to the ones of the update.
+func UpdateOne[T any](ctx context.Context, db *DB, filter string, update string, args ...interface{}) (*driver.UpdateResult, error) {
+	return updateModel[T](ctx, db, filter, update, args, false)
+}
+
+// UpdateMany applies an update expression to every document of T matching the filter, see UpdateOne.
+func UpdateMany[T any](ctx context.Context, db *DB, filter string, update string, args ...interface{}) (*driver.UpdateResult, error) {
+	return updateModel[T](ctx, db, filter, update, args, true)
+}
+
+func updateModel[T any](ctx context.Context, db *DB, filter string, update string, args []interface{}, many bool) (*driver.UpdateResult, error) {
+	q, err := compileFilter(filter, expr.CompileFor[T])
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	if q != nil {
+		count = q.NumPlaceholders()
+	}
+	if count > len(args) {
+		return nil, fmt.Errorf("the filter has %d placeholders, got %d arguments", count, len(args))
+	}
+	f, err := bindFilter(q, args[:count])
+	if err != nil {
+		return nil, err
+	}
+	u, err := expr.ParseUpdateFor[T](update, args[count:]...)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := collectionOf[T](db)
+	if err != nil {
+		return nil, err
+	}
+	return coll.Update(ctx, f, u, many)
+}
+
+// DeleteOne deletes the first document of T matching the filter.
+func DeleteOne[T any](ctx context.Context, db *DB, filter string, args ...interface{}) (*driver.DeleteResult, error) {
+	return deleteModel[T](ctx, db, filter, args, false)
+}
+
+// DeleteMany deletes every document of T matching the filter, an empty filter empties the collection.
+func DeleteMany[T any](ctx context.Context, db *DB, filter string, args ...interface{}) (*driver.DeleteResult, error) {
+	return deleteModel[T](ctx, db, filter, args, true)
+}
+
+func deleteModel[T any](ctx context.Context, db *DB, filter string, args []interface{}, many bool) (*driver.DeleteResult, error) {
+	f, err := filterOf(filter, expr.CompileFor[T], args)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := collectionOf[T](db)
+	if err != nil {
+		return nil, err
+	}
+	return coll.Delete(ctx, f, many)
+}
@@ -2,11 +2,15 @@
 package dbcontext
 
 import (
+	"errors"
+	"sort"
 	"sync"
 
 	"context"
 	"time"
 
+	"github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -19,6 +23,7 @@ var db_cache = make(map[string]*DB)
 type DB struct {
 	Client *mongo.Client
 	DBName string
+	store  Store // set by NewStoreDB, the collections are then not the ones of Client
 }
 
 type DBContext struct {
@@ -79,18 +84,72 @@ func (db *DBContext) GetDB(dbName string) *DB {
 	db_cache[dbName] = &DB{Client: db.Client, DBName: dbName}
 	return db_cache[dbName]
 }
-func FindOneToDict(db *DB, filter string) (map[string]interface{}, error) {
 
-	panic("not implemented")
+// errDictCollection is returned by the dict functions which do not name a collection.
+var errDictCollection = errors.New("no collection to run on, use the In variant of the function")
+
+// FindOneToDict cannot tell the collection to search.
+//
+// Deprecated: use FindOneToDictIn.
+func FindOneToDict(db *DB, filter string) (map[string]interface{}, error) {
+	return nil, errDictCollection
 }
+
+// InsertOneByDict cannot tell the collection to insert into.
+//
+// Deprecated: use InsertOneByDictIn.
 func InsertOneByDict(db *DB, data map[string]interface{}) error {
-	panic("not implemented")
+	return errDictCollection
 }
+
+// UpdateOneByDict cannot tell the collection to update.
+//
+// Deprecated: use UpdateOneByDictIn.
 func UpdateOneByDict(db *DB, filter string, data map[string]interface{}) error {
-	return nil
+	return errDictCollection
+}
+
+// FindOneToDictIn returns the first document of the collection matching the filter, mongo.ErrNoDocuments when none does.
+func FindOneToDictIn(ctx context.Context, db *DB, collectionName string, filter string, args ...interface{}) (map[string]interface{}, error) {
+	f, err := filterOf(filter, expr.Compile, args)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := db.collection(collectionName).Find(ctx, f, options.Find().SetLimit(1))
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	var result map[string]interface{}
+	if err := bson.Unmarshal(docs[0], &result); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-// implementation
-func FindOne[T any](db DB, filter string) (T, error) {
-	panic("not implemented")
+// InsertOneByDictIn inserts a document into the collection.
+func InsertOneByDictIn(ctx context.Context, db *DB, collectionName string, data map[string]interface{}) error {
+	_, err := db.collection(collectionName).Insert(ctx, []interface{}{data})
+	return err
+}
+
+// UpdateOneByDictIn sets the keys of data on the first document of the collection matching the filter.
+func UpdateOneByDictIn(ctx context.Context, db *DB, collectionName string, filter string, data map[string]interface{}, args ...interface{}) error {
+	f, err := filterOf(filter, expr.Compile, args)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	set := make(bson.D, len(keys))
+	for i, key := range keys {
+		set[i] = bson.E{Key: key, Value: data[key]}
+	}
+	_, err = db.collection(collectionName).Update(ctx, f, bson.D{{Key: "$set", Value: set}}, false)
+	return err
 }
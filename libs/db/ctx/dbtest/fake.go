@@ -0,0 +1,637 @@
+// Package dbtest provides an in-memory DB for the tests of code using the dbcontext CRUD functions.
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dbcontext "github.com/unvs/libs/db/ctx"
+	"github.com/unvs/libs/db/expr"
+	"github.com/unvs/libs/db/internal/bsondoc"
+	"github.com/unvs/libs/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewFake returns a DB which keeps its collections in memory, so that code using the CRUD functions can be
+// tested without a server. Filters are evaluated with expr.MatchBSON ($expr filters, i.e. comparisons between
+// fields and arithmetic, are rejected), find statements may sort, skip, limit and project top level fields,
+// and updates support the operators of the expr update language. A duplicate _id fails like on a server,
+// mongo.IsDuplicateKeyError reports it.
+func NewFake(dbName string) *dbcontext.DB {
+	return dbcontext.NewStoreDB(dbName, &fakeDB{name: dbName, collections: make(map[string]*fakeCollection)})
+}
+
+// idIndex is the index of _id every collection has, it is unique but not reported as such.
+var idIndex = models.Index{Name: "_id_", Keys: bson.D{{Key: "_id", Value: int32(1)}}}
+
+type fakeDB struct {
+	name        string
+	mu          sync.Mutex
+	collections map[string]*fakeCollection
+}
+
+// fakeCollection is a collection of a fake DB, its documents are kept decoded in insertion order.
+type fakeCollection struct {
+	db      *fakeDB
+	name    string
+	docs    []bson.D
+	indexes []models.Index // the _id index first, unique indexes are enforced
+}
+
+func (f *fakeDB) Collection(name string) dbcontext.Collection {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.collections[name]
+	if !ok {
+		c = &fakeCollection{db: f, name: name, indexes: []models.Index{idIndex}}
+		f.collections[name] = c
+	}
+	return c
+}
+
+// toDoc encodes a value like the driver does and decodes it as a document, so that the values of the fake
+// have the types a server would return (int32 or int64, primitive.DateTime, ...).
+func toDoc(value interface{}) (bson.D, error) {
+	data, err := models.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// match returns the positions of the documents matching the filter, only the first one unless many is set.
+func (c *fakeCollection) match(filter bson.D, many bool) ([]int, error) {
+	var matched []int
+	for i, doc := range c.docs {
+		ok, err := expr.MatchBSON(filter, doc)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, i)
+			if !many {
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+func (c *fakeCollection) Find(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]bson.Raw, error) {
+	// the operations fail like on a server when ctx is done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	matched, err := c.match(filter, true)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]bson.D, len(matched))
+	for i, index := range matched {
+		docs[i] = c.docs[index]
+	}
+	if opts == nil {
+		opts = options.Find()
+	}
+	if opts.Sort != nil {
+		spec, ok := opts.Sort.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("fake DB: sort must be a bson.D, got %T", opts.Sort)
+		}
+		sortDocs(docs, spec)
+	}
+	if opts.Skip != nil {
+		skip := min(max(*opts.Skip, 0), int64(len(docs)))
+		docs = docs[skip:]
+	}
+	if opts.Limit != nil && *opts.Limit != 0 {
+		// a negative limit is a limit in a single batch
+		if limit := *opts.Limit; limit < 0 && -limit < int64(len(docs)) {
+			docs = docs[:-limit]
+		} else if limit > 0 && limit < int64(len(docs)) {
+			docs = docs[:limit]
+		}
+	}
+	var projection bson.D
+	if opts.Projection != nil {
+		spec, ok := opts.Projection.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("fake DB: projection must be a bson.D, got %T", opts.Projection)
+		}
+		projection = spec
+	}
+	result := make([]bson.Raw, len(docs))
+	for i, doc := range docs {
+		data, err := bson.Marshal(project(doc, projection))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = data
+	}
+	return result, nil
+}
+
+func (c *fakeCollection) Cursor(ctx context.Context, filter bson.D, opts *options.FindOptions) (dbcontext.Cursor, error) {
+	docs, err := c.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeCursor{docs: docs}, nil
+}
+
+// fakeCursor iterates the result of a find of the fake, it fails like a driver cursor when ctx is done.
+type fakeCursor struct {
+	docs    []bson.Raw
+	lastErr error
+}
+
+func (c *fakeCursor) Next(ctx context.Context) (bson.Raw, bool) {
+	if c.lastErr = ctx.Err(); c.lastErr != nil || len(c.docs) == 0 {
+		return nil, false
+	}
+	doc := c.docs[0]
+	c.docs = c.docs[1:]
+	return doc, true
+}
+
+func (c *fakeCursor) Err() error {
+	return c.lastErr
+}
+
+func (c *fakeCursor) Close(ctx context.Context) error {
+	c.docs = nil
+	return nil
+}
+
+// sortDocs sorts documents by a sort specification like {Name: 1, Size: -1}, $meta keys are ignored.
+func sortDocs(docs []bson.D, spec bson.D) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range spec {
+			direction, ok := bsondoc.Number(key.Value)
+			if !ok {
+				continue
+			}
+			a, _ := bsondoc.GetPath(docs[i], key.Key)
+			b, _ := bsondoc.GetPath(docs[j], key.Key)
+			if cmp := expr.CompareValues(a, b); cmp != 0 {
+				return (cmp < 0) == (direction > 0)
+			}
+		}
+		return false
+	})
+}
+
+// project applies a projection to the top level keys of a document, a dotted path projects its first key.
+func project(doc bson.D, spec bson.D) bson.D {
+	include := map[string]bool{}
+	exclude := map[string]bool{}
+	for _, key := range spec {
+		flag, ok := bsondoc.Number(key.Value)
+		if b, isBool := key.Value.(bool); isBool {
+			flag, ok = map[bool]float64{true: 1, false: 0}[b], true
+		}
+		if !ok {
+			// {$meta: ...}
+			continue
+		}
+		first, _, _ := strings.Cut(key.Key, ".")
+		if flag != 0 {
+			include[first] = true
+		} else {
+			exclude[first] = true
+		}
+	}
+	if len(include) == 0 && len(exclude) == 0 {
+		return doc
+	}
+	projected := bson.D{}
+	for _, e := range doc {
+		switch {
+		case exclude[e.Key]:
+		case len(include) > 0 && !include[e.Key] && e.Key != "_id":
+		default:
+			projected = append(projected, e)
+		}
+	}
+	return projected
+}
+
+func (c *fakeCollection) Count(ctx context.Context, filter bson.D) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	matched, err := c.match(filter, true)
+	return int64(len(matched)), err
+}
+
+func (c *fakeCollection) Insert(ctx context.Context, docs []interface{}) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	ids := make([]interface{}, 0, len(docs))
+	for i, value := range docs {
+		doc, err := toDoc(value)
+		if err != nil {
+			return ids, err
+		}
+		id, found := bsondoc.GetPath(doc, "_id")
+		if !found {
+			id = primitive.NewObjectID()
+			doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+		}
+		if message := c.duplicateKey(doc, -1); message != "" {
+			return ids, mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{
+				Index: i, Code: 11000, Message: message,
+			}}}}
+		}
+		c.docs = append(c.docs, doc)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (c *fakeCollection) Update(ctx context.Context, filter bson.D, update bson.D, many bool) (*mongo.UpdateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	matched, err := c.match(filter, many)
+	if err != nil {
+		return nil, err
+	}
+	// the update values get the types they would have on a server
+	update, err = toDoc(update)
+	if err != nil {
+		return nil, err
+	}
+	result := &mongo.UpdateResult{MatchedCount: int64(len(matched))}
+	for _, index := range matched {
+		doc, err := applyUpdate(c.docs[index], update)
+		if err != nil {
+			return result, err
+		}
+		if message := c.duplicateKey(doc, index); message != "" {
+			return result, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: message}}}
+		}
+		before, _ := bson.Marshal(c.docs[index])
+		after, _ := bson.Marshal(doc)
+		if !bytes.Equal(before, after) {
+			result.ModifiedCount++
+		}
+		c.docs[index] = doc
+	}
+	return result, nil
+}
+
+func (c *fakeCollection) Delete(ctx context.Context, filter bson.D, many bool) (*mongo.DeleteResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	matched, err := c.match(filter, many)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(matched) - 1; i >= 0; i-- {
+		c.docs = append(c.docs[:matched[i]], c.docs[matched[i]+1:]...)
+	}
+	return &mongo.DeleteResult{DeletedCount: int64(len(matched))}, nil
+}
+
+// duplicateKey returns the error message when a document has the keys of another one in a unique index,
+// "" when it has not. skip is the position of the document when it is updated, -1 when it is inserted.
+func (c *fakeCollection) duplicateKey(doc bson.D, skip int) string {
+	for _, idx := range c.indexes {
+		if !idx.Unique && idx.Name != idIndex.Name {
+			continue
+		}
+		values, found := indexValues(doc, idx)
+		if !found && idx.Sparse {
+			continue
+		}
+		for i, other := range c.docs {
+			if i == skip {
+				continue
+			}
+			otherValues, otherFound := indexValues(other, idx)
+			if !otherFound && idx.Sparse {
+				continue
+			}
+			if sameValues(values, otherValues) {
+				return fmt.Sprintf("E11000 duplicate key error collection: %s.%s index: %s dup key: %v", c.db.name, c.name, idx.Name, values)
+			}
+		}
+	}
+	return ""
+}
+
+// indexValues returns the values of the keys of an index in a document, found is false when it has none of them.
+func indexValues(doc bson.D, idx models.Index) (values bson.A, found bool) {
+	values = make(bson.A, len(idx.Keys))
+	for i, key := range idx.Keys {
+		v, ok := bsondoc.GetPath(doc, key.Key)
+		values[i], found = v, found || ok
+	}
+	return values, found
+}
+
+func sameValues(a, b bson.A) bool {
+	for i := range a {
+		if expr.CompareValues(a[i], b[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *fakeCollection) ListIndexes(ctx context.Context) ([]models.Index, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return append([]models.Index(nil), c.indexes...), nil
+}
+
+func (c *fakeCollection) CreateIndexes(ctx context.Context, indexes []models.Index) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	for _, idx := range indexes {
+		exists := false
+		for _, e := range c.indexes {
+			sameName, sameSpec := e.Name == idx.Name, bsondoc.SameKeys(e.Keys, idx.Keys)
+			if sameName && sameSpec && bsondoc.SameOptions(e, idx) {
+				exists = true
+				break
+			}
+			if sameName || sameSpec {
+				return mongo.CommandError{Code: 85, Name: "IndexOptionsConflict",
+					Message: fmt.Sprintf("an index %s exists with other keys or options than %s", e.Name, idx.Name)}
+			}
+		}
+		if exists {
+			continue
+		}
+		c.indexes = append(c.indexes, idx)
+		for i, doc := range c.docs {
+			if message := c.duplicateKey(doc, i); message != "" {
+				c.indexes = c.indexes[:len(c.indexes)-1]
+				return mongo.CommandError{Code: 11000, Name: "DuplicateKey", Message: message}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *fakeCollection) DropIndex(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if name == idIndex.Name {
+		return mongo.CommandError{Code: 72, Name: "InvalidOptions", Message: "cannot drop _id index"}
+	}
+	for i, idx := range c.indexes {
+		if idx.Name == name {
+			c.indexes = append(c.indexes[:i:i], c.indexes[i+1:]...)
+			return nil
+		}
+	}
+	return mongo.CommandError{Code: 27, Name: "IndexNotFound", Message: fmt.Sprintf("index not found with name [%s]", name)}
+}
+
+// applyUpdate returns a copy of doc with the update operators applied.
+func applyUpdate(doc bson.D, update bson.D) (bson.D, error) {
+	doc, err := toDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("fake DB: %s expects a document, got %T", op.Key, op.Value)
+		}
+		for _, f := range fields {
+			current, found := bsondoc.GetPath(doc, f.Key)
+			switch op.Key {
+			case "$set":
+				doc, err = setPath(doc, f.Key, f.Value)
+			case "$setOnInsert":
+				// the fake has no upserts
+			case "$unset":
+				doc = unsetPath(doc, f.Key)
+			case "$inc", "$mul":
+				var value interface{}
+				if value, err = arithmetic(op.Key, current, f.Value); err == nil {
+					doc, err = setPath(doc, f.Key, value)
+				}
+			case "$min", "$max":
+				cmp := expr.CompareValues(f.Value, current)
+				if !found || (op.Key == "$min" && cmp < 0) || (op.Key == "$max" && cmp > 0) {
+					doc, err = setPath(doc, f.Key, f.Value)
+				}
+			case "$rename":
+				name, ok := f.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("fake DB: $rename expects a field name, got %T", f.Value)
+				}
+				if found {
+					doc, err = setPath(unsetPath(doc, f.Key), name, current)
+				}
+			case "$currentDate":
+				doc, err = setPath(doc, f.Key, primitive.NewDateTimeFromTime(time.Now()))
+			case "$push", "$addToSet", "$pull", "$pullAll", "$pop":
+				var items bson.A
+				if items, err = updateArray(op.Key, current, found, f.Value); err == nil {
+					doc, err = setPath(doc, f.Key, items)
+				}
+			default:
+				return nil, fmt.Errorf("fake DB: unsupported update operator %s", op.Key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("fake DB: %s on %s: %w", op.Key, f.Key, err)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// updateArray applies an array update operator to the current value of a field.
+func updateArray(op string, current interface{}, found bool, value interface{}) (bson.A, error) {
+	items, ok := current.(bson.A)
+	if !ok && found && current != nil {
+		return nil, fmt.Errorf("%T is not an array", current)
+	}
+	items = append(bson.A{}, items...)
+	switch op {
+	case "$push", "$addToSet":
+		values := bson.A{value}
+		if each, ok := value.(bson.D); ok && len(each) == 1 && each[0].Key == "$each" {
+			values, _ = each[0].Value.(bson.A)
+		}
+		for _, v := range values {
+			if op == "$addToSet" && containsValue(items, v) {
+				continue
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case "$pull", "$pullAll":
+		kept := bson.A{}
+		for _, item := range items {
+			remove, err := pulled(op, item, value)
+			if err != nil {
+				return nil, err
+			}
+			if !remove {
+				kept = append(kept, item)
+			}
+		}
+		return kept, nil
+	}
+	// $pop
+	if len(items) == 0 {
+		return items, nil
+	}
+	if n, _ := bsondoc.Number(value); n < 0 {
+		return items[1:], nil
+	}
+	return items[:len(items)-1], nil
+}
+
+// pulled reports whether $pull or $pullAll removes an element of an array.
+func pulled(op string, item interface{}, value interface{}) (bool, error) {
+	if op == "$pullAll" {
+		values, ok := value.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("$pullAll expects an array, got %T", value)
+		}
+		return containsValue(values, item), nil
+	}
+	if condition, ok := value.(bson.D); ok && len(condition) > 0 {
+		if strings.HasPrefix(condition[0].Key, "$") {
+			// a condition on the element itself
+			return expr.MatchBSON(bson.D{{Key: "v", Value: condition}}, bson.D{{Key: "v", Value: item}})
+		}
+		if _, isDoc := item.(bson.D); isDoc {
+			return expr.MatchBSON(condition, item)
+		}
+	}
+	return expr.CompareValues(item, value) == 0, nil
+}
+
+func containsValue(items bson.A, value interface{}) bool {
+	for _, item := range items {
+		if expr.CompareValues(item, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// arithmetic applies $inc or $mul, a missing field counts as 0. Integers stay integers.
+func arithmetic(op string, current interface{}, value interface{}) (interface{}, error) {
+	if current == nil {
+		current = int32(0)
+	}
+	x, xInt, ok := bsondoc.NumberKind(current)
+	if !ok {
+		return nil, fmt.Errorf("cannot apply %s to a value of type %T", op, current)
+	}
+	y, yInt, ok := bsondoc.NumberKind(value)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a number, got %T", op, value)
+	}
+	if xInt && yInt {
+		a, b := int64(x), int64(y)
+		result := a + b
+		if op == "$mul" {
+			result = a * b
+		}
+		_, currentIs32 := current.(int32)
+		_, valueIs32 := value.(int32)
+		if currentIs32 && valueIs32 && result >= math.MinInt32 && result <= math.MaxInt32 {
+			return int32(result), nil
+		}
+		return result, nil
+	}
+	if op == "$mul" {
+		return x * y, nil
+	}
+	return x + y, nil
+}
+
+// setPath sets the value at a dotted path, creating the missing documents on the way.
+func setPath(doc bson.D, path string, value interface{}) (bson.D, error) {
+	key, rest, nested := strings.Cut(path, ".")
+	for i, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if !nested {
+			doc[i].Value = value
+			return doc, nil
+		}
+		child, ok := e.Value.(bson.D)
+		if !ok && e.Value != nil {
+			return nil, fmt.Errorf("cannot create field %s in a %T", rest, e.Value)
+		}
+		child, err := setPath(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		doc[i].Value = child
+		return doc, nil
+	}
+	if !nested {
+		return append(doc, bson.E{Key: key, Value: value}), nil
+	}
+	child, err := setPath(bson.D{}, rest, value)
+	if err != nil {
+		return nil, err
+	}
+	return append(doc, bson.E{Key: key, Value: child}), nil
+}
+
+// unsetPath removes the value at a dotted path.
+func unsetPath(doc bson.D, path string) bson.D {
+	key, rest, nested := strings.Cut(path, ".")
+	for i, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if !nested {
+			return append(doc[:i:i], doc[i+1:]...)
+		}
+		if child, ok := e.Value.(bson.D); ok {
+			doc[i].Value = unsetPath(child, rest)
+		}
+		return doc
+	}
+	return doc
+}
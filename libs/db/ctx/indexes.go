@@ -0,0 +1,243 @@
+package dbcontext
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/unvs/libs/db/internal/bsondoc"
+	"github.com/unvs/libs/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// IndexReport is what EnsureIndexes did on a collection, or would do with WithDryRun.
+type IndexReport struct {
+	Collection string
+	Existing   []string // declared indexes which exist as declared
+	Created    []string // declared indexes which were missing
+	Changed    []string // declared indexes which exist with other keys or options, recreated with WithDropExtra
+	Extra      []string // indexes which are not declared, dropped with WithDropExtra
+	Dropped    []string // the extra indexes and the old versions of the changed ones
+}
+
+// IndexOptions are the options of EnsureIndexesWith.
+type IndexOptions struct {
+	DropExtra bool
+	DryRun    bool
+}
+
+// IndexOption sets an option of EnsureIndexesWith.
+type IndexOption func(*IndexOptions)
+
+// WithDropExtra drops the indexes which are not declared and recreates the changed ones.
+func WithDropExtra() IndexOption {
+	return func(o *IndexOptions) {
+		o.DropExtra = true
+	}
+}
+
+// WithDryRun only reports what EnsureIndexes would do.
+func WithDryRun() IndexOption {
+	return func(o *IndexOptions) {
+		o.DryRun = true
+	}
+}
+
+// EnsureIndexes creates the missing indexes declared by the models (see models.Index), given as values or
+// pointers, and reports the changed and the extra ones. Without models, the registered ones are used
+// (see models.Register). It is meant to run at startup, or per tenant database from cmd/ensure_indexes,
+// ctx bounds the whole run.
+func EnsureIndexes(ctx context.Context, db *DB, list ...interface{}) ([]IndexReport, error) {
+	return EnsureIndexesWith(ctx, db, nil, list...)
+}
+
+// EnsureIndexesWith is EnsureIndexes with options:
+//
+//	reports, err := dbcontext.EnsureIndexesWith(ctx, db, []dbcontext.IndexOption{dbcontext.WithDropExtra()})
+func EnsureIndexesWith(ctx context.Context, db *DB, opts []IndexOption, list ...interface{}) ([]IndexReport, error) {
+	o := IndexOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	collections, declared, err := declaredIndexes(list)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]IndexReport, 0, len(collections))
+	for _, name := range collections {
+		report, err := ensureCollectionIndexes(ctx, db.collection(name), name, declared[name], o)
+		reports = append(reports, report)
+		if err != nil {
+			return reports, fmt.Errorf("indexes of %s: %w", name, err)
+		}
+	}
+	return reports, nil
+}
+
+// ListIndexes returns the indexes of a collection, the _id index included.
+func ListIndexes(ctx context.Context, db *DB, collectionName string) ([]models.Index, error) {
+	return db.collection(collectionName).ListIndexes(ctx)
+}
+
+// declaredIndexes returns the collections of the models in order and their declared indexes.
+func declaredIndexes(list []interface{}) ([]string, map[string][]models.Index, error) {
+	var metadata []*models.Metadata
+	if len(list) == 0 {
+		metadata = models.Registered()
+		if len(metadata) == 0 {
+			return nil, nil, fmt.Errorf("no models to index, pass models or register them with models.Register")
+		}
+	}
+	for _, model := range list {
+		m, err := models.Of(reflect.TypeOf(model))
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Collection == "" {
+			return nil, nil, fmt.Errorf("model %s has no table tag", m.Type)
+		}
+		metadata = append(metadata, m)
+	}
+	var collections []string
+	declared := map[string][]models.Index{}
+	for _, m := range metadata {
+		indexes, err := m.Indexes()
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := declared[m.Collection]; !ok {
+			collections = append(collections, m.Collection)
+			declared[m.Collection] = []models.Index{}
+		}
+	next:
+		for _, idx := range indexes {
+			// models sharing a collection may declare the same index
+			for _, other := range declared[m.Collection] {
+				if other.Name != idx.Name {
+					continue
+				}
+				if !bsondoc.SameKeys(other.Keys, idx.Keys) || !bsondoc.SameOptions(other, idx) {
+					return nil, nil, fmt.Errorf("index %s of %s is declared twice with different keys or options", idx.Name, m.Collection)
+				}
+				continue next
+			}
+			declared[m.Collection] = append(declared[m.Collection], idx)
+		}
+	}
+	return collections, declared, nil
+}
+
+func ensureCollectionIndexes(ctx context.Context, coll Collection, name string, declared []models.Index, o IndexOptions) (IndexReport, error) {
+	report := IndexReport{Collection: name}
+	existing, err := coll.ListIndexes(ctx)
+	if err != nil {
+		return report, err
+	}
+	used := make([]bool, len(existing))
+	var create []models.Index
+	var drop []string
+	for _, idx := range declared {
+		match := -1
+		for i, e := range existing {
+			if !used[i] && bsondoc.SameKeys(e.Keys, idx.Keys) {
+				match = i
+				break
+			}
+		}
+		if match < 0 {
+			// an index of the same name cannot be created next to it
+			for i, e := range existing {
+				if !used[i] && e.Name == idx.Name {
+					match = i
+					break
+				}
+			}
+			if match < 0 {
+				report.Created = append(report.Created, idx.Name)
+				create = append(create, idx)
+				continue
+			}
+		}
+		used[match] = true
+		e := existing[match]
+		if e.Name == idx.Name && bsondoc.SameKeys(e.Keys, idx.Keys) && bsondoc.SameOptions(e, idx) {
+			report.Existing = append(report.Existing, idx.Name)
+			continue
+		}
+		report.Changed = append(report.Changed, idx.Name)
+		if o.DropExtra {
+			drop = append(drop, e.Name)
+			create = append(create, idx)
+		}
+	}
+	for i, e := range existing {
+		if used[i] || e.Name == "_id_" {
+			continue
+		}
+		report.Extra = append(report.Extra, e.Name)
+		if o.DropExtra {
+			drop = append(drop, e.Name)
+		}
+	}
+	report.Dropped = drop
+	if o.DryRun {
+		return report, nil
+	}
+	for _, name := range drop {
+		if err := coll.DropIndex(ctx, name); err != nil {
+			return report, err
+		}
+	}
+	if len(create) > 0 {
+		if err := coll.CreateIndexes(ctx, create); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// indexFromSpec reads an index returned by listIndexes, the key orders are normalized to int32 1 and -1
+// and the keys of a text index are its weighted fields.
+func indexFromSpec(spec bson.D) models.Index {
+	idx := models.Index{}
+	var weights bson.D
+	for _, e := range spec {
+		switch e.Key {
+		case "name":
+			idx.Name, _ = e.Value.(string)
+		case "key":
+			idx.Keys, _ = e.Value.(bson.D)
+		case "unique":
+			idx.Unique, _ = e.Value.(bool)
+		case "sparse":
+			idx.Sparse, _ = e.Value.(bool)
+		case "expireAfterSeconds":
+			if seconds, ok := bsondoc.Number(e.Value); ok {
+				idx.TTL, idx.ExpireAfter = true, time.Duration(seconds)*time.Second
+			}
+		case "weights":
+			weights, _ = e.Value.(bson.D)
+		}
+	}
+	keys := bson.D{}
+	for _, e := range idx.Keys {
+		switch e.Key {
+		case "_fts":
+			for _, w := range weights {
+				keys = append(keys, bson.E{Key: w.Key, Value: "text"})
+			}
+		case "_ftsx":
+		default:
+			if order, ok := bsondoc.Number(e.Value); ok {
+				e.Value = int32(1)
+				if order < 0 {
+					e.Value = int32(-1)
+				}
+			}
+			keys = append(keys, e)
+		}
+	}
+	idx.Keys = keys
+	return idx
+}
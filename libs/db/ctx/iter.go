@@ -0,0 +1,75 @@
+package dbcontext
+
+import (
+	"context"
+	"iter"
+
+	"github.com/unvs/libs/db/models"
+)
+
+// DefaultBatchSize is the number of documents Iter fetches from the server at a time.
+const DefaultBatchSize = 1000
+
+// Iter streams the documents of T matching the find statement from a cursor, so that the documents of big
+// collections are not loaded at once:
+//
+//	for file, err := range dbcontext.Iter[File](ctx, db, "Size > ? order by _id", 1024) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The query runs when the iteration starts and the cursor is closed when it ends, also on break.
+// Errors are yielded with the zero T: an invalid query or a failed find ends the iteration, a document
+// which cannot be decoded does not. There is no operation timeout, ctx bounds the whole iteration.
+func Iter[T any](ctx context.Context, db *DB, query string, args ...interface{}) iter.Seq2[T, error] {
+	return IterBatch[T](ctx, db, DefaultBatchSize, query, args...)
+}
+
+// IterBatch is Iter fetching batchSize documents at a time, the server default when batchSize is 0.
+func IterBatch[T any](ctx context.Context, db *DB, batchSize int32, query string, args ...interface{}) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		q, err := findQuery[T](query, args)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		coll, err := collectionOf[T](db)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		opts := q.FindOptions()
+		if batchSize > 0 {
+			opts.SetBatchSize(batchSize)
+		}
+		cur, err := coll.Cursor(ctx, q.Filter, opts)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		// the cursor is killed on the server even when ctx is done
+		defer cur.Close(context.WithoutCancel(ctx))
+		for {
+			doc, ok := cur.Next(ctx)
+			if !ok {
+				break
+			}
+			var value T
+			if err := models.Unmarshal(doc, &value); err != nil {
+				if !yield(zero, err) {
+					return
+				}
+				continue
+			}
+			if !yield(value, nil) {
+				return
+			}
+		}
+		if err := cur.Err(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
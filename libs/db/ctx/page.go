@@ -0,0 +1,252 @@
+package dbcontext
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/unvs/libs/db/expr"
+	"github.com/unvs/libs/db/internal/bsondoc"
+	"github.com/unvs/libs/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultPageSize is the number of items of a page when PageRequest.Size is 0.
+const DefaultPageSize = 50
+
+// ErrInvalidPageToken is returned by Page for a token which was not issued for the same query by a process
+// sharing the page token key, or which was altered.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// PageRequest asks for a page of the documents matching Filter in the order of Sort.
+type PageRequest struct {
+	Filter string        // an expr filter with ? placeholders, "" for every document
+	Args   []interface{} // the arguments of Filter
+	Sort   string        // an order by clause without the keywords, e.g. "CreatedOn desc, Name"
+	Token  string        // the NextToken of the previous page, "" for the first page
+	Size   int           // the number of items, DefaultPageSize when 0
+}
+
+// PageResult is a page of items, NextToken is "" on the last page.
+type PageResult[T any] struct {
+	Items     []T
+	NextToken string
+}
+
+var (
+	pageKeyMu sync.RWMutex
+	pageKey   = newPageKey()
+)
+
+func newPageKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// SetPageTokenKey sets the key page tokens are signed with. The default key is random, so tokens are only
+// valid in the process which issued them: instances serving the same API must share a key.
+func SetPageTokenKey(key []byte) {
+	pageKeyMu.Lock()
+	defer pageKeyMu.Unlock()
+	pageKey = append([]byte(nil), key...)
+}
+
+// Page returns a page of the documents of T with keyset pagination: instead of skipping the documents of the
+// previous pages, the filter selects the documents after the last one of the previous page in the sort order,
+// which an index on the sort keys serves in constant time. _id is added to the sort as a tiebreaker, so the
+// order is total and a document is on one page only, even when documents are inserted between requests:
+//
+//	page, err := dbcontext.Page[File](ctx, db, dbcontext.PageRequest{Filter: "Owner == ?", Args: args, Sort: "CreatedOn desc", Token: token})
+//
+// The token holds the sort values of the last item and a hash of the query, it is signed so that it cannot be
+// altered or used with another query. Sort keys should have the same type in every document, null and missing
+// values are first in ascending order.
+func Page[T any](ctx context.Context, db *DB, req PageRequest) (*PageResult[T], error) {
+	size := req.Size
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", size)
+	}
+	filter, err := filterOf(req.Filter, expr.CompileFor[T], req.Args)
+	if err != nil {
+		return nil, err
+	}
+	sort, err := pageSort[T](req.Sort)
+	if err != nil {
+		return nil, err
+	}
+	name, err := CollectionName[T]()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := pageQueryHash(name, filter, sort)
+	if err != nil {
+		return nil, err
+	}
+	if req.Token != "" {
+		values, err := decodePageToken(req.Token, hash, len(sort))
+		if err != nil {
+			return nil, err
+		}
+		after := afterFilter(sort, values)
+		if len(filter) == 0 {
+			filter = after
+		} else {
+			filter = bson.D{{Key: "$and", Value: bson.A{filter, after}}}
+		}
+	}
+	q := &expr.FindQuery{Filter: filter, Sort: sort}
+	// one more document tells whether there is a next page
+	docs, err := db.collection(name).Find(ctx, q.Filter, q.FindOptions().SetLimit(int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	result := &PageResult[T]{}
+	if len(docs) > size {
+		docs = docs[:size]
+		last, err := pageValues(docs[size-1], sort)
+		if err != nil {
+			return nil, err
+		}
+		if result.NextToken, err = encodePageToken(hash, last); err != nil {
+			return nil, err
+		}
+	}
+	if result.Items, err = decodeAll[T](docs); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// pageSort maps an order by clause to the document keys of T and adds _id to it.
+func pageSort[T any](spec string) (bson.D, error) {
+	sort := bson.D{}
+	if strings.TrimSpace(spec) != "" {
+		q, err := expr.ParseFindFor[T]("order by " + spec)
+		if err != nil {
+			return nil, err
+		}
+		sort = q.Sort
+	}
+	for _, e := range sort {
+		if e.Key == "_id" {
+			return sort, nil
+		}
+	}
+	return append(sort, bson.E{Key: "_id", Value: 1}), nil
+}
+
+// afterFilter selects the documents after the sort values of a document, for the sort {a: 1, b: -1, _id: 1}:
+//
+//	{$or: [{a: {$gt: va}}, {a: va, $or: [{b: {$lt: vb}}, {b: null}]}, {a: va, b: vb, _id: {$gt: vid}}]}
+//
+// Null and missing values sort before the other ones.
+func afterFilter(sort bson.D, values bson.A) bson.D {
+	branches := bson.A{}
+	for i, e := range sort {
+		branch := bson.D{}
+		for j := 0; j < i; j++ {
+			branch = append(branch, bson.E{Key: sort[j].Key, Value: values[j]})
+		}
+		desc := false
+		if order, ok := bsondoc.Number(e.Value); ok && order < 0 {
+			desc = true
+		}
+		switch {
+		case !desc && values[i] == nil:
+			branch = append(branch, bson.E{Key: e.Key, Value: bson.D{{Key: "$ne", Value: nil}}})
+		case !desc:
+			branch = append(branch, bson.E{Key: e.Key, Value: bson.D{{Key: "$gt", Value: values[i]}}})
+		case values[i] == nil:
+			// nothing sorts after null in descending order
+			continue
+		default:
+			branch = append(branch, bson.E{Key: "$or", Value: bson.A{
+				bson.D{{Key: e.Key, Value: bson.D{{Key: "$lt", Value: values[i]}}}},
+				bson.D{{Key: e.Key, Value: nil}},
+			}})
+		}
+		branches = append(branches, branch)
+	}
+	return bson.D{{Key: "$or", Value: branches}}
+}
+
+// pageValues returns the values of the sort keys of a document, nil for missing ones.
+func pageValues(raw bson.Raw, sort bson.D) (bson.A, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	values := make(bson.A, len(sort))
+	for i, e := range sort {
+		values[i], _ = bsondoc.GetPath(doc, e.Key)
+	}
+	return values, nil
+}
+
+// pageQueryHash identifies the query of a page, so that a token cannot be used with another one.
+func pageQueryHash(collection string, filter bson.D, sort bson.D) ([]byte, error) {
+	data, err := models.Marshal(bson.D{{Key: "c", Value: collection}, {Key: "f", Value: filter}, {Key: "s", Value: sort}})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:16], nil
+}
+
+// a token is the base64 of a bson document {q: query hash, v: sort values} followed by its HMAC-SHA256
+func encodePageToken(hash []byte, values bson.A) (string, error) {
+	payload, err := models.Marshal(bson.D{{Key: "q", Value: hash}, {Key: "v", Value: values}})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(append(payload, pageMAC(payload)...)), nil
+}
+
+func decodePageToken(token string, hash []byte, count int) (bson.A, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) <= sha256.Size {
+		return nil, ErrInvalidPageToken
+	}
+	payload, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(mac, pageMAC(payload)) {
+		return nil, ErrInvalidPageToken
+	}
+	var content struct {
+		Q []byte `field:"q"`
+		V bson.A `field:"v"`
+	}
+	if err := models.Unmarshal(payload, &content); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	if !bytes.Equal(content.Q, hash) || len(content.V) != count {
+		return nil, fmt.Errorf("%w: the token was issued for another query", ErrInvalidPageToken)
+	}
+	for i, v := range content.V {
+		if dt, ok := v.(primitive.DateTime); ok {
+			content.V[i] = dt.Time().UTC()
+		}
+	}
+	return content.V, nil
+}
+
+func pageMAC(payload []byte) []byte {
+	pageKeyMu.RLock()
+	defer pageKeyMu.RUnlock()
+	mac := hmac.New(sha256.New, pageKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,265 @@
+package expr
+
+import (
+	"go/ast"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// LambdaAnalyzer is a func literal used as a sub-filter on the elements of an array,
+// e.g. Any(Privileges, func(p) bool { return p.User == ? && p.Write }).
+// The fields of Body are relative to the element, an empty field name is the element itself.
+type LambdaAnalyzer struct {
+	Param string
+	Body  Node
+	Span  Span
+}
+
+// analyzeLambda analyzes func(p) bool { return <condition> }, the parameter may be given a type (func(p Privilege) bool)
+// which is ignored.
+func analyzeLambda(src *source, n *ast.FuncLit) (Node, error) {
+	params := n.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) > 1 {
+		return nil, src.errorf(ErrUnsupportedNode, n.Type, "func literal must take exactly one parameter")
+	}
+	var param string
+	if len(params[0].Names) == 1 {
+		param = params[0].Names[0].Name
+	} else if ident, ok := params[0].Type.(*ast.Ident); ok {
+		// func(p) bool: the parser reads p as the parameter type
+		param = ident.Name
+	} else {
+		return nil, src.errorf(ErrUnsupportedNode, params[0].Type, "func literal parameter must be an identifier")
+	}
+	if results := n.Type.Results; results != nil {
+		ident, ok := results.List[0].Type.(*ast.Ident)
+		if len(results.List) != 1 || len(results.List[0].Names) > 0 || !ok || ident.Name != "bool" {
+			return nil, src.errorf(ErrUnsupportedNode, results, "func literal must return bool")
+		}
+	}
+	if len(n.Body.List) != 1 {
+		return nil, src.errorf(ErrUnsupportedNode, n.Body, "func literal body must be a single return statement")
+	}
+	ret, ok := n.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return nil, src.errorf(ErrUnsupportedNode, n.Body.List[0], "func literal body must be a single return statement")
+	}
+	body, err := analyzeNode(src, ret.Results[0])
+	if err != nil {
+		return nil, err
+	}
+	body, err = relativeFields(body, param, src.src.template)
+	if err != nil {
+		return nil, err
+	}
+	return LambdaAnalyzer{Param: param, Body: body, Span: src.span(n)}, nil
+}
+
+// relativeFields rewrites the fields of a lambda body relative to the element: p.User becomes User and p becomes "".
+// Fields which do not go through the parameter are rejected, an element filter cannot reach the enclosing document.
+func relativeFields(node Node, param string, originalExpr string) (Node, error) {
+	switch n := node.(type) {
+	case FieldAnalyzer:
+		if n.Name == param {
+			return FieldAnalyzer{Name: "", Span: n.Span}, nil
+		}
+		if !strings.HasPrefix(n.Name, param+".") {
+			return nil, newError(ErrInvalidArgument, n.Span, originalExpr, "field %s must be accessed through the func literal parameter %s", n.Name, param)
+		}
+		name := n.Name[len(param)+1:]
+		if first, rest, _ := strings.Cut(name, "."); strings.ToLower(first) == "id" {
+			name = strings.TrimSuffix("_id."+rest, ".")
+		}
+		return FieldAnalyzer{Name: name, Span: n.Span}, nil
+	case Analyzer:
+		left, err := relativeFields(n.Left, param, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		right, err := relativeFields(n.Right, param, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		return Analyzer{Op: n.Op, Left: left, Right: right, Span: n.Span}, nil
+	case FuncAnalyzer:
+		if n.Args == nil {
+			return n, nil
+		}
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			// the fields of nested lambdas are already relative to their own parameter
+			value, err := relativeFields(arg, param, originalExpr)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		return FuncAnalyzer{Name: n.Name, Args: args, Span: n.Span}, nil
+	default:
+		return node, nil
+	}
+}
+
+// arrayAndLambda returns the array field and the sub-filter Any(field, func(p) bool {...}) is called with.
+func arrayAndLambda(f FuncAnalyzer, originalExpr string) (FieldAnalyzer, LambdaAnalyzer, error) {
+	if len(f.Args) != 2 {
+		return FieldAnalyzer{}, LambdaAnalyzer{}, newError(ErrInvalidArgument, f.Span, originalExpr, "function '%s' expects exactly two arguments", f.Name)
+	}
+	field, ok := f.Args[0].(FieldAnalyzer)
+	if !ok {
+		return FieldAnalyzer{}, LambdaAnalyzer{}, newError(ErrInvalidArgument, spanOf(f.Args[0]), originalExpr, "function '%s' expects a field as first argument", f.Name)
+	}
+	lambda, ok := f.Args[1].(LambdaAnalyzer)
+	if !ok {
+		return FieldAnalyzer{}, LambdaAnalyzer{}, newError(ErrInvalidArgument, spanOf(f.Args[1]), originalExpr, "function '%s' expects a func literal as second argument", f.Name)
+	}
+	return field, lambda, nil
+}
+
+// buildAny builds {field: {$elemMatch: {...}}} from Any(field, func(p) bool {...}).
+func buildAny(f FuncAnalyzer, originalExpr string) (bson.D, error) {
+	field, lambda, err := arrayAndLambda(f, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	if search, ok := findSearch(lambda.Body); ok {
+		// $text cannot be in $elemMatch and a handler searches documents, not elements
+		return nil, newError(ErrInvalidOperand, spanOf(search), originalExpr, "function '%s' cannot search in its func", f.Name)
+	}
+	filter, err := buildFilter(lambda.Body, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	if hasElementKey(filter) {
+		// conditions on the element itself: {$elemMatch: {$gte: 80, $lt: 85}}
+		ops, ok := elementOperators(filter)
+		if !ok {
+			return nil, newError(ErrInvalidArgument, lambda.Span, originalExpr, "conditions on %s itself can only be comparisons joined by &&", lambda.Param)
+		}
+		filter = ops
+	}
+	return bson.D{{field.Name, bson.D{{"$elemMatch", filter}}}}, nil
+}
+
+// hasElementKey reports whether a filter has a condition on the element itself (an empty key).
+func hasElementKey(filter bson.D) bool {
+	for _, e := range filter {
+		if e.Key == "" {
+			return true
+		}
+		if items, ok := e.Value.(bson.A); ok && strings.HasPrefix(e.Key, "$") {
+			for _, item := range items {
+				if sub, ok := item.(bson.D); ok && hasElementKey(sub) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// elementOperators merges the conditions on the element itself into a single operator document,
+// ok is false when the filter cannot be expressed that way.
+func elementOperators(filter bson.D) (bson.D, bool) {
+	var ops bson.D
+	for _, e := range filter {
+		switch {
+		case e.Key == "$and":
+			for _, item := range e.Value.(bson.A) {
+				sub, ok := elementOperators(item.(bson.D))
+				if !ok {
+					return nil, false
+				}
+				ops = append(ops, sub...)
+			}
+		case e.Key != "":
+			return nil, false
+		default:
+			value, ok := e.Value.(bson.D)
+			if ok && len(value) > 0 && strings.HasPrefix(value[0].Key, "$") {
+				ops = append(ops, value...)
+			} else {
+				ops = append(ops, bson.E{"$eq", e.Value})
+			}
+		}
+	}
+	return ops, true
+}
+
+// buildAll builds {field: {$all: [...]}} from All(field, ?).
+func buildAll(f FuncAnalyzer, originalExpr string) (bson.D, error) {
+	field, values, err := inValues(f, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{{field.Name, bson.D{{"$all", values}}}}, nil
+}
+
+// sizeFilter builds {field: {$size: n}} from Len(field) == n, so that the comparison does not need $expr.
+// ok is false for anything else. n must be a non negative integer, the server rejects any other $size.
+func sizeFilter(op string, left interface{}, right interface{}, originalExpr string) (bson.D, bool, error) {
+	if _, isConst := left.(ConstAnalyzer); isConst {
+		left, right = right, left
+	}
+	f, ok := left.(FuncAnalyzer)
+	if !ok || strings.ToLower(f.Name) != "len" || len(f.Args) != 1 {
+		return nil, false, nil
+	}
+	field, ok := f.Args[0].(FieldAnalyzer)
+	if !ok {
+		return nil, false, nil
+	}
+	c, ok := right.(ConstAnalyzer)
+	if !ok || (op != "$eq" && op != "$ne") {
+		return nil, false, nil
+	}
+	size, ok := normalizeValue(c.Value).(int64)
+	if !ok || size < 0 {
+		return nil, false, newError(ErrInvalidArgument, c.Span, originalExpr, "the length of an array is compared with a non negative integer, got %v", c.Value)
+	}
+	filter := bson.D{{field.Name, bson.D{{"$size", c.Value}}}}
+	if op == "$ne" {
+		return negateFilter(filter), true, nil
+	}
+	return filter, true, nil
+}
+
+// evalAny mirrors buildAny: one element of the array must match the sub-filter.
+func evalAny(f FuncAnalyzer, doc interface{}, originalExpr string) (bool, error) {
+	field, lambda, err := arrayAndLambda(f, originalExpr)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range lookupPath(doc, splitPath(field.Name)) {
+		items, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range items {
+			ok, err := evalCondition(lambda.Body, item, originalExpr)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// evalAll mirrors buildAll, an empty list matches nothing.
+func evalAll(f FuncAnalyzer, doc interface{}, originalExpr string) (bool, error) {
+	field, list, err := inValues(f, originalExpr)
+	if err != nil {
+		return false, err
+	}
+	if len(list) == 0 {
+		return false, nil
+	}
+	values, _ := lookupField(doc, field.Name)
+	for _, item := range list {
+		if !anyValue(values, func(v interface{}) bool { return equalValues(v, item) }) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
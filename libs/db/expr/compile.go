@@ -0,0 +1,214 @@
+package expr
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultCacheSize is the number of compiled templates kept by Compile.
+const DefaultCacheSize = 512
+
+// Query is a compiled expression template. It is immutable, so one Query can be
+// bound concurrently from many goroutines.
+type Query struct {
+	template string
+	tree     Node
+	count    int
+	names    []string
+	search   SearchHandler
+}
+
+// CompileOptions are the options of Compile and CompileFor.
+type CompileOptions struct {
+	SearchHandler SearchHandler
+}
+
+// CompileOption sets an option of Compile and CompileFor.
+type CompileOption func(*CompileOptions)
+
+// Compile parses and analyzes a template once, the result is bound to arguments with Bind.
+// Compiled templates are kept in a bounded LRU cache, compiling the same template again is a cache lookup.
+// The options only apply to the returned query:
+//
+//	q, err := expr.Compile("Search(?) && Owner == ?", expr.WithSearchHandler(searchIds))
+func Compile(template string, opts ...CompileOption) (*Query, error) {
+	key := cacheKey{template: template}
+	if q, ok := queryCache.get(key); ok {
+		return q.with(opts), nil
+	}
+	tree, r, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{template: template, tree: tree, count: r.count, names: r.names}
+	queryCache.add(key, q)
+	return q.with(opts), nil
+}
+
+// with returns the query with the options applied, the cached query is shared and never changed.
+func (q *Query) with(opts []CompileOption) *Query {
+	if len(opts) == 0 {
+		return q
+	}
+	o := CompileOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	copied := *q
+	copied.search = o.SearchHandler
+	return &copied
+}
+
+// MustCompile is like Compile but panics if the template cannot be compiled,
+// it is meant for package level query variables.
+func MustCompile(template string) *Query {
+	q, err := Compile(template)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String returns the template the query was compiled from.
+func (q *Query) String() string {
+	return q.template
+}
+
+// NumPlaceholders returns the number of ? placeholders of the query, the number of arguments Bind expects.
+func (q *Query) NumPlaceholders() int {
+	return q.count
+}
+
+// Bind binds the arguments to the ? placeholders of the query and returns the mongo filter.
+func (q *Query) Bind(args ...interface{}) (bson.D, error) {
+	analyExpr, err := q.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return q.filter(analyExpr)
+}
+
+// BindNamed binds the @name parameters of the query and returns the mongo filter.
+func (q *Query) BindNamed(params map[string]interface{}) (bson.D, error) {
+	analyExpr, err := q.bindNamed(params)
+	if err != nil {
+		return nil, err
+	}
+	return q.filter(analyExpr)
+}
+
+func (q *Query) filter(analyExpr Node) (bson.D, error) {
+	if q.search != nil {
+		var err error
+		if analyExpr, err = resolveSearch(analyExpr, q.search, q.template); err != nil {
+			return nil, err
+		}
+	}
+	return buildQuery(analyExpr, q.template)
+}
+
+func (q *Query) bind(args []interface{}) (Node, error) {
+	if len(q.names) > 0 {
+		return nil, newError(ErrParameter, Span{}, q.template, "named parameters (@%s) require BindNamed", q.names[0])
+	}
+	if q.count != len(args) {
+		return nil, newError(ErrParameter, Span{}, q.template, "number of placeholders (%d) does not match number of arguments (%d)", q.count, len(args))
+	}
+	return bindChecked(q.tree, args, nil, q.template)
+}
+
+func (q *Query) bindNamed(params map[string]interface{}) (Node, error) {
+	if q.count > 0 {
+		return nil, newError(ErrParameter, Span{}, q.template, "positional placeholders (?) cannot be mixed with named parameters")
+	}
+	if err := checkParams(q.names, params, true, q.template); err != nil {
+		return nil, err
+	}
+	return bindChecked(q.tree, nil, params, q.template)
+}
+
+// bindChecked binds the parameters and checks the constant arithmetic they take part in.
+func bindChecked(tree Node, args []interface{}, params map[string]interface{}, template string) (Node, error) {
+	bound, err := bindParams(tree, args, params, template)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkArithmetic(bound, template); err != nil {
+		return nil, err
+	}
+	return bound, nil
+}
+
+// SetCacheSize changes the number of compiled templates kept by Compile, 0 disables the cache.
+func SetCacheSize(size int) {
+	queryCache.resize(size)
+}
+
+var queryCache = newLRU(DefaultCacheSize)
+
+// cacheKey identifies a compiled template, model is set for templates compiled with CompileFor.
+type cacheKey struct {
+	template string
+	model    reflect.Type
+}
+
+// lru is a goroutine-safe least recently used cache of compiled queries.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is the most recently used
+	items map[cacheKey]*list.Element
+}
+
+type lruEntry struct {
+	key   cacheKey
+	query *Query
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), items: make(map[cacheKey]*list.Element)}
+}
+
+func (c *lru) get(key cacheKey) (*Query, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*lruEntry).query, true
+	}
+	return nil, false
+}
+
+func (c *lru) add(key cacheKey, q *Query) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		e.Value.(*lruEntry).query = q
+		return
+	}
+	if c.size <= 0 {
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, query: q})
+	c.evict()
+}
+
+func (c *lru) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.evict()
+}
+
+// evict drops the least recently used entries above the size limit, c.mu must be held.
+func (c *lru) evict() {
+	for c.order.Len() > c.size && c.order.Len() > 0 {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,397 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ElasticTranslator translates expressions to the Elasticsearch Query DSL, the result is a
+// map[string]interface{} ready to be marshaled as the "query" of a search request:
+//
+//	Size > ? && (Ext == "pdf" || Contains(Name, ?))
+//
+// becomes
+//
+//	{"bool": {"must": [{"range": {"Size": {"gt": 10}}},
+//	                   {"bool": {"should": [{"term": {"Ext": "pdf"}}, {"wildcard": {"Name": {"value": "*report*"}}}], "minimum_should_match": 1}}]}}
+//
+// Only conditions on fields can be translated: comparisons between fields, arithmetic and Len have no
+// Query DSL equivalent and are rejected. Any(field, func) becomes a nested query, the field must be mapped as nested.
+type ElasticTranslator struct {
+	// ContentField is the field searched by Search(?), e.g. the elastic_search.field_content of the config.
+	ContentField string
+}
+
+func (t ElasticTranslator) Translate(e *Expression) (interface{}, error) {
+	return t.Query(e)
+}
+
+// Query is Translate with the result typed as a map.
+func (t ElasticTranslator) Query(e *Expression) (map[string]interface{}, error) {
+	b := elasticBuilder{contentField: t.ContentField, template: e.Template}
+	return b.query(e.Root)
+}
+
+// elasticRangeOps maps the ordering operators to the options of a range query.
+var elasticRangeOps = map[string]string{">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}
+
+// elasticBuilder builds a Query DSL query, path is the array field the fields of a lambda body are relative to.
+type elasticBuilder struct {
+	contentField string
+	template     string
+	path         string
+}
+
+func (b elasticBuilder) field(f FieldAnalyzer) string {
+	switch {
+	case b.path == "":
+		return f.Name
+	case f.Name == "":
+		return b.path
+	default:
+		return b.path + "." + f.Name
+	}
+}
+
+func (b elasticBuilder) unsupported(node interface{}, format string, args ...interface{}) *Error {
+	return newError(ErrUnsupportedNode, spanOf(node), b.template, format, args...)
+}
+
+func (b elasticBuilder) query(node interface{}) (map[string]interface{}, error) {
+	switch n := node.(type) {
+	case Analyzer:
+		switch n.Op {
+		case "!":
+			q, err := b.query(n.Left)
+			if err != nil {
+				return nil, err
+			}
+			return boolQuery("must_not", q), nil
+		case "&&", "||":
+			operands := collectOperands(n.Op, n, nil)
+			queries := make([]interface{}, len(operands))
+			for i, operand := range operands {
+				q, err := b.query(operand)
+				if err != nil {
+					return nil, err
+				}
+				queries[i] = q
+			}
+			if n.Op == "&&" {
+				return map[string]interface{}{"bool": map[string]interface{}{"must": queries}}, nil
+			}
+			return map[string]interface{}{"bool": map[string]interface{}{"should": queries, "minimum_should_match": 1}}, nil
+		}
+		return b.compare(n)
+	case FuncAnalyzer:
+		return b.function(n)
+	case FieldAnalyzer:
+		// a bare field is a boolean flag
+		return term(b.field(n), true), nil
+	default:
+		return nil, newError(ErrInvalidOperand, spanOf(node), b.template, "expression is not a condition")
+	}
+}
+
+func (b elasticBuilder) compare(expr Analyzer) (map[string]interface{}, error) {
+	op := expr.Op
+	if _, ok := opMapping[op]; !ok {
+		return nil, newError(ErrUnsupportedOperator, expr.Span, b.template, "unsupported operator: %s", op)
+	}
+	left, right := foldOperand(expr.Left), foldOperand(expr.Right)
+	if _, isField := right.(FieldAnalyzer); isField {
+		if _, isConst := left.(ConstAnalyzer); isConst || isNilAnalyzer(left) {
+			left, right = right, left
+			op = reversedOp[op]
+		}
+	}
+	field, ok := left.(FieldAnalyzer)
+	if !ok {
+		return nil, b.unsupported(expr, "only comparisons of a field with a value can be translated to elasticsearch")
+	}
+	name := b.field(field)
+	if isNilAnalyzer(right) {
+		switch op {
+		case "==":
+			return boolQuery("must_not", exists(name)), nil
+		case "!=":
+			return exists(name), nil
+		default:
+			return nil, newError(ErrInvalidOperand, expr.Span, b.template, "nil can only be compared with == or !=")
+		}
+	}
+	c, ok := right.(ConstAnalyzer)
+	if !ok {
+		return nil, b.unsupported(expr, "only comparisons of a field with a value can be translated to elasticsearch")
+	}
+	switch op {
+	case "==":
+		return term(name, c.Value), nil
+	case "!=":
+		return boolQuery("must_not", term(name, c.Value)), nil
+	}
+	return map[string]interface{}{"range": map[string]interface{}{name: map[string]interface{}{elasticRangeOps[op]: c.Value}}}, nil
+}
+
+func (b elasticBuilder) function(f FuncAnalyzer) (map[string]interface{}, error) {
+	name := strings.ToLower(f.Name)
+	switch name {
+	case "isnull", "exists", "notexists":
+		field, err := singleField(f, b.template)
+		if err != nil {
+			return nil, err
+		}
+		if name == "exists" {
+			return exists(b.field(field)), nil
+		}
+		return boolQuery("must_not", exists(b.field(field))), nil
+	case "contains", "startswith", "endswith", "icontains", "istartswith", "iendswith":
+		field, text, err := fieldAndString(f, b.template)
+		if err != nil {
+			return nil, err
+		}
+		if limit := maxPatternLength.Load(); limit > 0 && int64(len(text)) > limit {
+			return nil, newError(ErrInvalidArgument, spanOf(f.Args[1]), b.template, "function '%s': pattern is longer than %d bytes", f.Name, limit)
+		}
+		value := wildcardEscaper.Replace(text)
+		if !strings.HasSuffix(name, "startswith") {
+			value = "*" + value
+		}
+		if !strings.HasSuffix(name, "endswith") {
+			value += "*"
+		}
+		return wildcardQuery("wildcard", b.field(field), value, strings.HasPrefix(name, "i")), nil
+	case "regex":
+		field, pattern, options, err := regexPattern(f, b.template)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Trim(options, "i") != "" {
+			return nil, newError(ErrInvalidArgument, spanOf(f.Args[2]), b.template, "elasticsearch only supports the i flag")
+		}
+		pattern, err = luceneRegexp(pattern)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, spanOf(f.Args[1]), b.template, "function '%s': %v", f.Name, err)
+		}
+		return wildcardQuery("regexp", b.field(field), pattern, options == "i"), nil
+	case "in", "notin", "all":
+		field, values, err := inValues(f, b.template)
+		if err != nil {
+			return nil, err
+		}
+		if name == "all" {
+			queries := make([]interface{}, len(values))
+			for i, v := range values {
+				queries[i] = term(b.field(field), v)
+			}
+			return map[string]interface{}{"bool": map[string]interface{}{"must": queries}}, nil
+		}
+		q := b.terms(b.field(field), values)
+		if name == "notin" {
+			return boolQuery("must_not", q), nil
+		}
+		return q, nil
+	case "search":
+		field, text, err := searchArgs(f, b.template)
+		if err != nil {
+			return nil, err
+		}
+		if field == "" {
+			field = b.contentField
+		} else {
+			field = b.field(FieldAnalyzer{Name: field})
+		}
+		if field == "" {
+			return nil, newError(ErrInvalidArgument, f.Span, b.template, "Search(?) needs the ContentField of the elasticsearch translator")
+		}
+		return map[string]interface{}{"match": map[string]interface{}{field: text}}, nil
+	case "any":
+		field, lambda, err := arrayAndLambda(f, b.template)
+		if err != nil {
+			return nil, err
+		}
+		inner := b
+		inner.path = b.field(field)
+		q, err := inner.query(lambda.Body)
+		if err != nil {
+			return nil, err
+		}
+		if onlyElement(lambda.Body) {
+			// conditions on the values of a plain array
+			return q, nil
+		}
+		return map[string]interface{}{"nested": map[string]interface{}{"path": inner.path, "query": q}}, nil
+	}
+	return nil, newError(ErrUnsupportedFunction, f.Span, b.template, "function %s cannot be translated to elasticsearch", f.Name)
+}
+
+// terms builds a terms query, a nil value also matches documents without the field.
+func (b elasticBuilder) terms(field string, values []interface{}) map[string]interface{} {
+	items := make([]interface{}, 0, len(values))
+	withNil := false
+	for _, v := range values {
+		if v == nil {
+			withNil = true
+			continue
+		}
+		items = append(items, v)
+	}
+	q := map[string]interface{}{"terms": map[string]interface{}{field: items}}
+	if !withNil {
+		return q
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{
+		"should":               []interface{}{q, boolQuery("must_not", exists(field))},
+		"minimum_should_match": 1,
+	}}
+}
+
+// onlyElement reports whether the fields of a lambda body all are the element itself.
+func onlyElement(node interface{}) bool {
+	switch n := node.(type) {
+	case FieldAnalyzer:
+		return n.Name == ""
+	case Analyzer:
+		return onlyElement(n.Left) && (n.Right == nil || onlyElement(n.Right))
+	case FuncAnalyzer:
+		for _, arg := range n.Args {
+			if !onlyElement(arg) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// luceneClasses are the character classes \d, \w and \s written as lucene character ranges,
+// lucene reads \d as a plain d.
+var luceneClasses = map[byte]string{'d': "0-9", 'w': "a-zA-Z0-9_", 's': " \t\n\v\f\r"}
+
+// luceneEscapes are the control character escapes, written as the characters themselves.
+var luceneEscapes = map[byte]byte{'n': '\n', 'r': '\r', 't': '\t', 'f': '\f', 'v': '\v'}
+
+// luceneOperators are literal characters in a regular expression which are operators in lucene.
+const luceneOperators = `@&~<>#"`
+
+// luceneRegexp converts the pattern of Regex to a lucene regular expression. Lucene regular expressions are
+// anchored: ^ and $ are dropped and an unanchored side gets .* instead. The character classes \d, \w and \s
+// (and their negations outside brackets) are rewritten as ranges, the constructs lucene does not have
+// (lookarounds, flag groups, lazy quantifiers, \b, back references, ^ or $ inside the pattern) are rejected
+// instead of silently matching something else.
+func luceneRegexp(pattern string) (string, error) {
+	var b strings.Builder
+	start, end := false, false
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(pattern) {
+				return "", fmt.Errorf("trailing backslash")
+			}
+			i++
+			next := pattern[i]
+			if class, ok := luceneClasses[next]; ok {
+				if inClass {
+					b.WriteString(class)
+				} else {
+					b.WriteString("[" + class + "]")
+				}
+				continue
+			}
+			if class, ok := luceneClasses[next|0x20]; ok && next >= 'A' && next <= 'Z' {
+				if inClass {
+					return "", fmt.Errorf(`\%c inside brackets has no lucene equivalent`, next)
+				}
+				b.WriteString("[^" + class + "]")
+				continue
+			}
+			if control, ok := luceneEscapes[next]; ok {
+				b.WriteByte(control)
+				continue
+			}
+			if next < 0x80 && (unicode.IsLetter(rune(next)) || unicode.IsDigit(rune(next))) {
+				return "", fmt.Errorf(`\%c has no lucene equivalent`, next)
+			}
+			b.WriteByte('\\')
+			b.WriteByte(next)
+		case inClass:
+			if c == ']' {
+				inClass = false
+			} else if strings.IndexByte(luceneOperators, c) >= 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		case c == '[':
+			inClass = true
+			b.WriteByte(c)
+			if i+1 < len(pattern) && pattern[i+1] == '^' {
+				i++
+				b.WriteByte('^')
+			}
+			if i+1 < len(pattern) && pattern[i+1] == ']' {
+				// a ] right after the opening bracket is a literal
+				i++
+				b.WriteString(`\]`)
+			}
+		case c == '^':
+			if i != 0 {
+				return "", fmt.Errorf("^ is only supported at the start of the pattern")
+			}
+			start = true
+		case c == '$':
+			if i != len(pattern)-1 {
+				return "", fmt.Errorf("$ is only supported at the end of the pattern")
+			}
+			end = true
+		case c == '(' && strings.HasPrefix(pattern[i:], "(?:"):
+			// lucene groups do not capture
+			b.WriteByte('(')
+			i += 2
+		case c == '(' && strings.HasPrefix(pattern[i:], "(?"):
+			return "", fmt.Errorf("lookarounds and flag groups have no lucene equivalent")
+		case strings.IndexByte("*+?}", c) >= 0 && i+1 < len(pattern) && (pattern[i+1] == '?' || pattern[i+1] == '+'):
+			return "", fmt.Errorf("lazy and possessive quantifiers have no lucene equivalent")
+		case strings.IndexByte(luceneOperators, c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if inClass {
+		return "", fmt.Errorf("missing ] in the pattern")
+	}
+	result := b.String()
+	if !start {
+		result = ".*" + result
+	}
+	if !end {
+		result += ".*"
+	}
+	return result, nil
+}
+
+// wildcardEscaper escapes the special characters of a wildcard pattern.
+var wildcardEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+
+func term(field string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{"term": map[string]interface{}{field: value}}
+}
+
+func exists(field string) map[string]interface{} {
+	return map[string]interface{}{"exists": map[string]interface{}{"field": field}}
+}
+
+func boolQuery(occur string, q map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"bool": map[string]interface{}{occur: []interface{}{q}}}
+}
+
+func wildcardQuery(kind string, field string, value string, caseInsensitive bool) map[string]interface{} {
+	options := map[string]interface{}{"value": value}
+	if caseInsensitive {
+		options["case_insensitive"] = true
+	}
+	return map[string]interface{}{kind: map[string]interface{}{field: options}}
+}
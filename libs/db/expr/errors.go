@@ -0,0 +1,89 @@
+package expr
+
+import (
+	"fmt"
+	"go/scanner"
+)
+
+// ErrorCode classifies an expr Error so that callers can react to it without parsing the message.
+type ErrorCode string
+
+const (
+	ErrSyntax              ErrorCode = "syntax_error"
+	ErrUnsupportedNode     ErrorCode = "unsupported_node"
+	ErrUnsupportedOperator ErrorCode = "unsupported_operator"
+	ErrUnsupportedFunction ErrorCode = "unsupported_function"
+	ErrInvalidArgument     ErrorCode = "invalid_argument"
+	ErrInvalidOperand      ErrorCode = "invalid_operand"
+	ErrParameter           ErrorCode = "parameter_error"
+	ErrUnknownField        ErrorCode = "unknown_field"
+)
+
+// Span is a range of byte offsets in the original expression, End is exclusive.
+// The zero Span means the error is not attached to a position.
+type Span struct {
+	Start int
+	End   int
+}
+
+// IsValid reports whether the span points at a part of the expression.
+func (s Span) IsValid() bool {
+	return s.End > s.Start
+}
+
+// Error is returned for every expression that cannot be parsed, bound or translated.
+// Expr holds the offending sub-expression, Span its position in the original expression.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Span    Span
+	Expr    string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if !e.Span.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s at column %d: %s", e.Message, e.Span.Start+1, e.Expr)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError creates an Error pointing at span in the original expression.
+func newError(code ErrorCode, span Span, originalExpr string, format string, args ...interface{}) *Error {
+	e := &Error{Code: code, Message: fmt.Sprintf(format, args...), Span: span}
+	if span.IsValid() && span.End <= len(originalExpr) {
+		e.Expr = originalExpr[span.Start:span.End]
+	} else {
+		e.Span = Span{}
+	}
+	return e
+}
+
+// newSyntaxError converts a go/parser error list to an Error, positions are mapped back to the original expression.
+func newSyntaxError(err error, src *rewritten) *Error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok || len(list) == 0 {
+		return &Error{Code: ErrSyntax, Message: err.Error(), Err: err}
+	}
+	start := src.originalOffset(list[0].Pos.Offset)
+	end := start + 1
+	if end > len(src.template) {
+		// the expression ended unexpectedly, point at its last character
+		start, end = len(src.template)-1, len(src.template)
+	}
+	e := newError(ErrSyntax, Span{Start: start, End: end}, src.template, "%s", list[0].Msg)
+	e.Err = err
+	return e
+}
+
+// spanOf returns the position of an analyzed node.
+func spanOf(node interface{}) Span {
+	if n, ok := node.(Node); ok {
+		return n.Position()
+	}
+	return Span{}
+}
@@ -0,0 +1,772 @@
+package expr
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Evaluate reports whether value matches the query, without a database.
+// value may be a struct (fields are looked up by their field or bson tag, then by name),
+// a map[string]interface{}, a bson.M or a bson.D. The semantics follow the mongo translation:
+// a comparison on an array field matches when any element matches, == nil matches a missing
+// or null field, and the text search functions use the same regular expressions
+// (Regex patterns are compiled with the Go regexp syntax).
+func Evaluate(query string, value interface{}, args ...interface{}) (bool, error) {
+	q, err := Compile(query)
+	if err != nil {
+		return false, err
+	}
+	return q.Evaluate(value, args...)
+}
+
+// Evaluate binds the arguments and reports whether value matches the query, see Evaluate.
+func (q *Query) Evaluate(value interface{}, args ...interface{}) (bool, error) {
+	analyExpr, err := q.bind(args)
+	if err != nil {
+		return false, err
+	}
+	return evalCondition(analyExpr, value, q.template)
+}
+
+// MatchBSON reports whether value matches a mongo filter, without a database. The filter is read like FromBSON
+// (without its restrictions on values), so $expr and the operators FromBSON does not know are rejected.
+func MatchBSON(filter bson.D, value interface{}) (bool, error) {
+	if len(filter) == 0 {
+		return true, nil
+	}
+	node, err := bsonReader{anyValue: true}.fromFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	return evalCondition(node, value, "")
+}
+
+// CompareValues compares two document values in the bson order used for sorting: numbers by value,
+// values of different types by the order of their bson type.
+func CompareValues(a interface{}, b interface{}) int {
+	return compareOrdered(a, b)
+}
+
+// evalCondition evaluates a node used in a boolean context, it mirrors buildFilter.
+func evalCondition(node interface{}, doc interface{}, originalExpr string) (bool, error) {
+	switch n := node.(type) {
+	case Analyzer:
+		switch n.Op {
+		case "!":
+			ok, err := evalCondition(n.Left, doc, originalExpr)
+			return !ok, err
+		case "&&":
+			ok, err := evalCondition(n.Left, doc, originalExpr)
+			if err != nil || !ok {
+				return false, err
+			}
+			return evalCondition(n.Right, doc, originalExpr)
+		case "||":
+			ok, err := evalCondition(n.Left, doc, originalExpr)
+			if err != nil || ok {
+				return ok, err
+			}
+			return evalCondition(n.Right, doc, originalExpr)
+		}
+		return evalCompare(n, doc, originalExpr)
+	case FuncAnalyzer:
+		return evalFunc(n, doc, originalExpr)
+	case FieldAnalyzer:
+		// a bare field is a boolean flag
+		values, _ := lookupField(doc, n.Name)
+		return anyValue(values, func(v interface{}) bool { return equalValues(v, true) }), nil
+	default:
+		return false, newError(ErrInvalidOperand, spanOf(node), originalExpr, "expression is not a condition")
+	}
+}
+
+// evalCompare mirrors buildCompare: field-vs-constant comparisons use query semantics
+// (any array element may match), anything else uses aggregation ($expr) semantics.
+func evalCompare(expr Analyzer, doc interface{}, originalExpr string) (bool, error) {
+	if _, ok := opMapping[expr.Op]; !ok {
+		return false, newError(ErrUnsupportedOperator, expr.Span, originalExpr, "unsupported operator: %s", expr.Op)
+	}
+	op := expr.Op
+	left, right := expr.Left, expr.Right
+	if _, isField := right.(FieldAnalyzer); isField {
+		if _, isConst := left.(ConstAnalyzer); isConst || isNilAnalyzer(left) {
+			left, right = right, left
+			op = reversedOp[op]
+		}
+	}
+	field, ok := left.(FieldAnalyzer)
+	if !ok {
+		return evalExprCompare(op, left, right, doc, originalExpr)
+	}
+	if isNilAnalyzer(right) {
+		values, found := lookupField(doc, field.Name)
+		isNull := !found || anyValue(values, func(v interface{}) bool { return v == nil })
+		switch op {
+		case "==":
+			return isNull, nil
+		case "!=":
+			return !isNull, nil
+		default:
+			return false, newError(ErrInvalidOperand, expr.Span, originalExpr, "nil can only be compared with == or !=")
+		}
+	}
+	c, ok := right.(ConstAnalyzer)
+	if !ok {
+		return evalExprCompare(op, left, right, doc, originalExpr)
+	}
+	values, _ := lookupField(doc, field.Name)
+	switch op {
+	case "==":
+		return anyValue(values, func(v interface{}) bool { return equalValues(v, c.Value) }), nil
+	case "!=":
+		return !anyValue(values, func(v interface{}) bool { return equalValues(v, c.Value) }), nil
+	}
+	return anyValue(values, func(v interface{}) bool {
+		cmp, ok := compareValues(v, c.Value)
+		return ok && compareResult(op, cmp)
+	}), nil
+}
+
+func evalExprCompare(op string, left interface{}, right interface{}, doc interface{}, originalExpr string) (bool, error) {
+	l, err := evalOperand(left, doc, originalExpr)
+	if err != nil {
+		return false, err
+	}
+	r, err := evalOperand(right, doc, originalExpr)
+	if err != nil {
+		return false, err
+	}
+	return compareResult(op, compareOrdered(l, r)), nil
+}
+
+// evalOperand computes the value of a node the way an aggregation expression does, a missing field is null.
+func evalOperand(node interface{}, doc interface{}, originalExpr string) (interface{}, error) {
+	if isNilAnalyzer(node) {
+		return nil, nil
+	}
+	switch n := node.(type) {
+	case FieldAnalyzer:
+		return lookupValue(doc, n.Name), nil
+	case ConstAnalyzer:
+		return normalizeValue(n.Value), nil
+	case Analyzer:
+		if _, ok := opMapping[n.Op]; ok || n.Op == "!" {
+			return evalCondition(n, doc, originalExpr)
+		}
+		if _, ok := arithOps[n.Op]; !ok {
+			return nil, newError(ErrUnsupportedOperator, n.Span, originalExpr, "unsupported operator: %s", n.Op)
+		}
+		left, err := evalOperand(n.Left, doc, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		op, right := n.Op, interface{}(nil)
+		if n.Right == nil {
+			// unary minus
+			op, left, right = "*", int64(-1), left
+		} else if right, err = evalOperand(n.Right, doc, originalExpr); err != nil {
+			return nil, err
+		}
+		value, ok := arithmetic(op, left, right)
+		if !ok {
+			return nil, newError(ErrInvalidOperand, n.Span, originalExpr, "cannot apply %s to %T and %T", n.Op, left, right)
+		}
+		return value, nil
+	case FuncAnalyzer:
+		return evalBuiltin(n, doc, originalExpr)
+	default:
+		return nil, newError(ErrInvalidOperand, spanOf(node), originalExpr, "unsupported operand")
+	}
+}
+
+// evalFunc mirrors buildWithFunc.
+func evalFunc(f FuncAnalyzer, doc interface{}, originalExpr string) (bool, error) {
+	switch strings.ToLower(f.Name) {
+	case "isnull":
+		field, err := singleField(f, originalExpr)
+		if err != nil {
+			return false, err
+		}
+		values, found := lookupField(doc, field.Name)
+		return !found || anyValue(values, func(v interface{}) bool { return v == nil }), nil
+	case "exists", "notexists":
+		field, err := singleField(f, originalExpr)
+		if err != nil {
+			return false, err
+		}
+		_, found := lookupField(doc, field.Name)
+		return found == (strings.ToLower(f.Name) == "exists"), nil
+	case "contains", "startswith", "endswith", "icontains", "istartswith", "iendswith", "regex":
+		field, pattern, options, err := regexPattern(f, originalExpr)
+		if err != nil {
+			return false, err
+		}
+		if strings.Contains(options, "x") {
+			return false, newError(ErrInvalidArgument, f.Span, originalExpr, "flag x is not supported in memory")
+		}
+		if options != "" {
+			pattern = "(?" + options + ")" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, newError(ErrInvalidArgument, f.Span, originalExpr, "invalid pattern: %v", err)
+		}
+		values, _ := lookupField(doc, field.Name)
+		return anyValue(values, func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && re.MatchString(s)
+		}), nil
+	case "in", "notin":
+		field, list, err := inValues(f, originalExpr)
+		if err != nil {
+			return false, err
+		}
+		values, found := lookupField(doc, field.Name)
+		matched := false
+		for _, item := range list {
+			if item == nil && !found {
+				matched = true
+				break
+			}
+			if anyValue(values, func(v interface{}) bool { return equalValues(v, item) }) {
+				matched = true
+				break
+			}
+		}
+		if strings.ToLower(f.Name) == "notin" {
+			return !matched, nil
+		}
+		return matched, nil
+	case "search":
+		return evalSearch(f, doc, originalExpr)
+	case "any":
+		return evalAny(f, doc, originalExpr)
+	case "all":
+		return evalAll(f, doc, originalExpr)
+	default:
+		return false, newError(ErrUnsupportedFunction, f.Span, originalExpr, "unsupported function: %s", f.Name)
+	}
+}
+
+// evalBuiltin mirrors aggFunc.
+func evalBuiltin(f FuncAnalyzer, doc interface{}, originalExpr string) (interface{}, error) {
+	name := strings.ToLower(f.Name)
+	if _, ok := aggFunctions[name]; !ok {
+		return nil, newError(ErrUnsupportedFunction, f.Span, originalExpr, "unsupported function: %s", f.Name)
+	}
+	if len(f.Args) != 1 {
+		return nil, newError(ErrInvalidArgument, f.Span, originalExpr, "function '%s' expects exactly one argument", f.Name)
+	}
+	arg, err := evalOperand(f.Args[0], doc, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	switch v := normalizeValue(arg).(type) {
+	case nil:
+		if name == "lower" || name == "upper" {
+			return "", nil
+		}
+		return nil, nil
+	case time.Time:
+		switch name {
+		case "year":
+			return int64(v.UTC().Year()), nil
+		case "month":
+			return int64(v.UTC().Month()), nil
+		case "day":
+			return int64(v.UTC().Day()), nil
+		}
+	case string:
+		switch name {
+		case "lower":
+			return strings.ToLower(v), nil
+		case "upper":
+			return strings.ToUpper(v), nil
+		}
+	case []interface{}:
+		if name == "len" {
+			return int64(len(v)), nil
+		}
+	}
+	return nil, newError(ErrInvalidArgument, spanOf(f.Args[0]), originalExpr, "function '%s' does not apply to %T", f.Name, arg)
+}
+
+// arithmetic applies an arithmetic operator with aggregation semantics: null operands give null,
+// / always divides as floats, dates can be shifted by milliseconds and subtracted.
+func arithmetic(op string, a interface{}, b interface{}) (interface{}, bool) {
+	a, b = normalizeValue(a), normalizeValue(b)
+	if a == nil || b == nil {
+		return nil, true
+	}
+	if t, ok := a.(time.Time); ok {
+		switch y := b.(type) {
+		case int64:
+			return dateShift(op, t, float64(y))
+		case float64:
+			return dateShift(op, t, y)
+		case time.Time:
+			if op == "-" {
+				return t.Sub(y).Milliseconds(), true
+			}
+		}
+		return nil, false
+	}
+	x, xIsInt := a.(int64)
+	y, yIsInt := b.(int64)
+	if xIsInt && yIsInt {
+		switch op {
+		case "+", "-", "*":
+			if value, ok := intArithmetic(op, x, y); ok {
+				return value, true
+			}
+			// like the server, an integer overflow gives a double
+		case "/":
+			if y == 0 {
+				return nil, false
+			}
+			return float64(x) / float64(y), true
+		case "%":
+			if y == 0 {
+				return nil, false
+			}
+			return x % y, true
+		default:
+			return nil, false
+		}
+	}
+	fx, ok := toFloat(a)
+	if !ok {
+		return nil, false
+	}
+	fy, ok := toFloat(b)
+	if !ok {
+		return nil, false
+	}
+	switch op {
+	case "+":
+		return fx + fy, true
+	case "-":
+		return fx - fy, true
+	case "*":
+		return fx * fy, true
+	case "/":
+		if fy == 0 {
+			return nil, false
+		}
+		return fx / fy, true
+	case "%":
+		if fy == 0 {
+			return nil, false
+		}
+		return math.Mod(fx, fy), true
+	}
+	return nil, false
+}
+
+// intArithmetic adds, subtracts or multiplies integers, ok is false when the result overflows int64.
+func intArithmetic(op string, x int64, y int64) (int64, bool) {
+	switch op {
+	case "+":
+		r := x + y
+		return r, (x^r)&(y^r) >= 0
+	case "-":
+		r := x - y
+		return r, (x^y)&(x^r) >= 0
+	case "*":
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+		r := x * y
+		return r, r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64)
+	}
+	return 0, false
+}
+
+func dateShift(op string, t time.Time, ms float64) (interface{}, bool) {
+	switch op {
+	case "+":
+		return t.Add(time.Duration(ms * float64(time.Millisecond))), true
+	case "-":
+		return t.Add(-time.Duration(ms * float64(time.Millisecond))), true
+	}
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func compareResult(op string, cmp int) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+func anyValue(values []interface{}, match func(interface{}) bool) bool {
+	for _, v := range values {
+		if match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupField resolves a dotted path with query semantics: arrays met on the way are traversed,
+// and an array at the end of the path yields its elements as well as the array itself.
+// found is false when the path does not exist in the document.
+func lookupField(doc interface{}, path string) (values []interface{}, found bool) {
+	for _, v := range lookupPath(doc, splitPath(path)) {
+		found = true
+		values = append(values, v)
+		if items, ok := v.([]interface{}); ok {
+			for _, item := range items {
+				values = append(values, normalizeValue(item))
+			}
+		}
+	}
+	return values, found
+}
+
+// lookupValue resolves a dotted path with aggregation semantics, a missing field is nil.
+func lookupValue(doc interface{}, path string) interface{} {
+	values := lookupPath(doc, splitPath(path))
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// splitPath splits a dotted path, the empty path is the document itself (the element of a lambda).
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func lookupPath(doc interface{}, parts []string) []interface{} {
+	v := normalizeValue(doc)
+	if len(parts) == 0 {
+		return []interface{}{v}
+	}
+	if items, ok := v.([]interface{}); ok {
+		var values []interface{}
+		if index, err := strconv.Atoi(parts[0]); err == nil {
+			if index >= 0 && index < len(items) {
+				values = append(values, lookupPath(items[index], parts[1:])...)
+			}
+			return values
+		}
+		for _, item := range items {
+			values = append(values, lookupPath(item, parts)...)
+		}
+		return values
+	}
+	child, ok := getMember(v, parts[0])
+	if !ok {
+		return nil
+	}
+	return lookupPath(child, parts[1:])
+}
+
+// getMember returns the value stored under key in a document.
+func getMember(doc interface{}, key string) (interface{}, bool) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		v, ok := d[key]
+		return v, ok
+	case bson.M:
+		v, ok := d[key]
+		return v, ok
+	case bson.D:
+		for _, e := range d {
+			if e.Key == key {
+				return e.Value, true
+			}
+		}
+		return nil, false
+	}
+	v := reflect.ValueOf(doc)
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		item := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+		if !item.IsValid() {
+			return nil, false
+		}
+		return item.Interface(), true
+	case reflect.Struct:
+		return structMember(v, key)
+	}
+	return nil, false
+}
+
+// structMember finds a struct field by its field or bson tag, then by its name.
+// Fields of embedded structs are promoted; _id also matches a field tagged or named id.
+func structMember(v reflect.Value, key string) (interface{}, bool) {
+	if field, ok := findStructField(v, key, false); ok {
+		return field.Interface(), true
+	}
+	if field, ok := findStructField(v, key, true); ok {
+		return field.Interface(), true
+	}
+	if key == "_id" {
+		return structMember(v, "id")
+	}
+	return nil, false
+}
+
+func findStructField(v reflect.Value, key string, byName bool) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if found, ok := findStructField(v.Field(i), key, byName); ok {
+				return found, true
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if byName {
+			if strings.EqualFold(field.Name, key) {
+				return v.Field(i), true
+			}
+			continue
+		}
+		if tagName(field, "field") == key || tagName(field, "bson") == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// tagName returns the name part of a struct tag like `bson:"name,omitempty"`.
+func tagName(field reflect.StructField, key string) string {
+	name, _, _ := strings.Cut(field.Tag.Get(key), ",")
+	return name
+}
+
+// normalizeValue converts a document value to the small set of types the evaluator compares:
+// nil, bool, int64, float64, string, time.Time, primitive.ObjectID, []byte, []interface{} and documents.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, int64, float64, string, time.Time, primitive.ObjectID, []byte, bson.D, bson.M, map[string]interface{}:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case primitive.DateTime:
+		return v.Time().UTC()
+	case primitive.Binary:
+		return v.Data
+	case primitive.Decimal128:
+		if f, err := strconv.ParseFloat(v.String(), 64); err == nil {
+			return f
+		}
+		return v.String()
+	case bson.A:
+		return []interface{}(v)
+	case []interface{}:
+		return v
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return normalizeValue(rv.Elem().Interface())
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := rv.Uint(); u <= 1<<63-1 {
+			return int64(u)
+		}
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 && rv.Kind() == reflect.Slice {
+			return rv.Bytes()
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+		return items
+	}
+	return value
+}
+
+// compareValues compares two values of the same kind (numbers, strings, dates, booleans, object ids, binaries),
+// ok is false when the values cannot be compared, as a query operator like $gt would not match them.
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	a, b = normalizeValue(a), normalizeValue(b)
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return compareOrderedScalar(x, y), true
+		case float64:
+			return compareOrderedScalar(float64(x), y), true
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return compareOrderedScalar(x, float64(y)), true
+		case float64:
+			return compareOrderedScalar(x, y), true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case y:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y), true
+		}
+	}
+	return 0, false
+}
+
+func compareOrderedScalar[T int64 | float64](x T, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// equalValues reports whether two values are equal, numbers are compared by value.
+func equalValues(a interface{}, b interface{}) bool {
+	a, b = normalizeValue(a), normalizeValue(b)
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	x, xIsArray := a.([]interface{})
+	y, yIsArray := b.([]interface{})
+	if xIsArray && yIsArray {
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalValues(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// bsonTypeOrder is the position of a value's type in the bson comparison order used by aggregation expressions.
+func bsonTypeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int64, float64:
+		return 2
+	case string:
+		return 3
+	case []interface{}:
+		return 5
+	case []byte:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	}
+	// embedded documents
+	return 4
+}
+
+// compareOrdered compares any two values, values of different types are ordered by their bson type.
+func compareOrdered(a interface{}, b interface{}) int {
+	a, b = normalizeValue(a), normalizeValue(b)
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp
+	}
+	if ta, tb := bsonTypeOrder(a), bsonTypeOrder(b); ta != tb {
+		return compareOrderedScalar(int64(ta), int64(tb))
+	}
+	if x, ok := a.([]interface{}); ok {
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if cmp := compareOrdered(x[i], y[i]); cmp != 0 {
+				return cmp
+			}
+		}
+		return compareOrderedScalar(int64(len(x)), int64(len(y)))
+	}
+	if equalValues(a, b) {
+		return 0
+	}
+	// documents which are not equal, the order is only meant to be stable
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
@@ -7,8 +7,11 @@ import (
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -28,20 +31,24 @@ var opMapping = map[string]string{
 
 type Analyzer struct {
 	Op    string
-	Left  interface{}
-	Right interface{}
+	Left  Node
+	Right Node // nil for a unary operator
+	Span  Span
 }
 
 type ConstAnalyzer struct {
 	Value interface{}
+	Span  Span
 }
 
 type FuncAnalyzer struct {
 	Name string
-	Args []interface{}
+	Args []Node
+	Span Span
 }
 type FieldAnalyzer struct {
 	Name string
+	Span Span
 }
 type MongoExpression map[string]interface{}
 
@@ -75,168 +82,611 @@ func selectorExprToString(sel *ast.SelectorExpr) string {
 		}
 	}
 }
-func analyzeNode(fset *token.FileSet, node ast.Node) interface{} {
+
+// source is a parsed expression, it maps go/parser positions back to the original template.
+type source struct {
+	fset *token.FileSet
+	file *token.File
+	src  *rewritten
+}
+
+// span returns the position of an ast node in the original template.
+func (s *source) span(node ast.Node) Span {
+	start := s.src.originalOffset(s.file.Offset(node.Pos()))
+	end := s.src.originalOffset(s.file.Offset(node.End()))
+	return Span{Start: start, End: end}
+}
+
+func (s *source) errorf(code ErrorCode, node ast.Node, format string, args ...interface{}) *Error {
+	return newError(code, s.span(node), s.src.template, format, args...)
+}
+
+func analyzeNode(src *source, node ast.Node) (Node, error) {
 	switch n := node.(type) {
 	case *ast.BinaryExpr:
 		op := n.Op.String()
-		left := analyzeNode(fset, n.X)
-		right := analyzeNode(fset, n.Y)
-		if _, ok := right.(FuncAnalyzer); ok {
-			// right is of type FuncAnalyzer
-			// You can now use right as a FuncAnalyzer
-			// Example:
-			analyzer := right.(FuncAnalyzer)
-			// Use analyzer methods
-			if analyzer.Name == "IsNull" {
-				return Analyzer{Op: op, Left: left, Right: nil} // Use equality operator for IsNull function
-			}
+		left, err := analyzeNode(src, n.X)
+		if err != nil {
+			return nil, err
 		}
-
-		return Analyzer{Op: op, Left: left, Right: right}
+		right, err := analyzeNode(src, n.Y)
+		if err != nil {
+			return nil, err
+		}
+		return Analyzer{Op: op, Left: left, Right: right, Span: src.span(n)}, nil
 
 	case *ast.UnaryExpr:
 		op := n.Op.String()
-		operand := analyzeNode(fset, n.X)
-		return Analyzer{Op: op, Left: operand, Right: nil}
+		operand, err := analyzeNode(src, n.X)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := operand.(ConstAnalyzer); ok && op == "-" {
+			// fold negative number literals
+			switch v := c.Value.(type) {
+			case int:
+				return ConstAnalyzer{Value: -v, Span: src.span(n)}, nil
+			case float64:
+				return ConstAnalyzer{Value: -v, Span: src.span(n)}, nil
+			}
+		}
+		if op != "!" && op != "-" {
+			return nil, src.errorf(ErrUnsupportedOperator, n, "unsupported unary operator: %s", op)
+		}
+		return Analyzer{Op: op, Left: operand, Span: src.span(n)}, nil
 
 	case *ast.SelectorExpr:
 		x, ok := n.X.(*ast.Ident)
 		if !ok {
+			if _, ok := n.X.(*ast.SelectorExpr); !ok {
+				return nil, src.errorf(ErrUnsupportedNode, n, "unsupported selector base")
+			}
 			var buf strings.Builder
-			printer.Fprint(&buf, fset, n.X)
+			printer.Fprint(&buf, src.fset, n.X)
 			fName := buf.String() + "." + n.Sel.Name
 			//return fmt.Sprintf("Unsupported selector base: %s", buf.String())
 			// x.Name = selectorExprToString(n) // Use the string representation of the selector
-			return FieldAnalyzer{Name: fName}
+			return FieldAnalyzer{Name: fName, Span: src.span(n)}, nil
 		}
 		sel := n.Sel.Name
 		if strings.ToLower(x.Name) == "id" {
 			x.Name = "_id"
 		}
-		return FieldAnalyzer{Name: x.Name + "." + sel} // Now a FieldAnalyzer
+		return FieldAnalyzer{Name: x.Name + "." + sel, Span: src.span(n)}, nil // Now a FieldAnalyzer
 
 	case *ast.Ident:
 		if strings.ToLower(n.Name) == "id" {
-			return FieldAnalyzer{Name: "_id"} // Now a FieldAnalyzer
+			return FieldAnalyzer{Name: "_id", Span: src.span(n)}, nil // Now a FieldAnalyzer
 		}
 		if n.Name == identifier_nil {
-			return FuncAnalyzer{Name: "IsNull"} // Return nil for nil identifierreturn FieldAnalyzer{Name: n.Name}
+			return FuncAnalyzer{Name: "IsNull", Span: src.span(n)}, nil // Return nil for nil identifierreturn FieldAnalyzer{Name: n.Name}
+		}
+		if n.Name == "true" || n.Name == "false" {
+			return ConstAnalyzer{Value: n.Name == "true", Span: src.span(n)}, nil
 		}
-		return FieldAnalyzer{Name: n.Name} // Now a FieldAnalyzer
+		if src.src.isPlaceholder(src.file.Offset(n.Pos())) {
+			if n.Name == emptyList {
+				return ConstAnalyzer{Value: []interface{}{}, Span: src.span(n)}, nil
+			}
+			if index, ok := paramIndex(n.Name); ok {
+				return ParamAnalyzer{Index: index, Span: src.span(n)}, nil
+			}
+			if name, ok := paramName(n.Name); ok {
+				return ParamAnalyzer{Name: name, Span: src.span(n)}, nil
+			}
+		}
+		return FieldAnalyzer{Name: n.Name, Span: src.span(n)}, nil // Now a FieldAnalyzer
 
 	case *ast.BasicLit:
 		switch n.Kind {
-		case token.STRING:
-			val, _ := strconv.Unquote(n.Value)
-			return ConstAnalyzer{Value: val}
+		case token.STRING, token.CHAR:
+			val, err := strconv.Unquote(n.Value)
+			if err != nil {
+				return nil, src.errorf(ErrSyntax, n, "invalid string literal")
+			}
+			return ConstAnalyzer{Value: val, Span: src.span(n)}, nil
 		case token.INT:
-			val, _ := strconv.Atoi(n.Value)
-			return ConstAnalyzer{Value: val}
+			// base 0 reads the literals of Go: 0x10, 0o17, 0b101, 1_000
+			val, err := strconv.ParseInt(n.Value, 0, strconv.IntSize)
+			if err != nil {
+				return nil, src.errorf(ErrSyntax, n, "invalid integer literal")
+			}
+			return ConstAnalyzer{Value: int(val), Span: src.span(n)}, nil
 		case token.FLOAT:
-			val, _ := strconv.ParseFloat(n.Value, 64)
-			return ConstAnalyzer{Value: val}
+			val, err := strconv.ParseFloat(n.Value, 64)
+			if err != nil {
+				return nil, src.errorf(ErrSyntax, n, "invalid float literal")
+			}
+			return ConstAnalyzer{Value: val, Span: src.span(n)}, nil
 		default:
-			return ConstAnalyzer{Value: n.Value}
+			return nil, src.errorf(ErrUnsupportedNode, n, "unsupported literal")
 		}
 	case *ast.ParenExpr:
-		return analyzeNode(fset, n.X)
+		return analyzeNode(src, n.X)
 	case *ast.CallExpr:
-		fun := analyzeNode(fset, n.Fun)
-		args := make([]interface{}, len(n.Args))
+		fun, ok := n.Fun.(*ast.Ident)
+		if !ok {
+			return nil, src.errorf(ErrUnsupportedFunction, n.Fun, "function name must be an identifier")
+		}
+		args := make([]Node, len(n.Args))
 		for i, arg := range n.Args {
-			args[i] = analyzeNode(fset, arg)
+			a, err := analyzeNode(src, arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = a
 		}
-		return FuncAnalyzer{Name: fun.(FieldAnalyzer).Name, Args: args} //Use FieldAnalyzer.Name
+		return FuncAnalyzer{Name: fun.Name, Args: args, Span: src.span(n)}, nil
+	case *ast.FuncLit:
+		return analyzeLambda(src, n)
 	default:
-		var buf strings.Builder
-		printer.Fprint(&buf, fset, n)
-		return fmt.Sprintf("Unsupported node type: %s", buf.String())
+		return nil, src.errorf(ErrUnsupportedNode, n, "unsupported expression")
 	}
 }
 
-func AnalyzeFunction(expr string) (interface{}, error) {
+// parseTemplate parses an expression template, leaving its placeholders unbound.
+func parseTemplate(template string) (Node, *rewritten, error) {
+	r := rewritePlaceholders(template)
 	fset := token.NewFileSet()
-	node, err := parser.ParseExprFrom(fset, "", expr, 0)
+	node, err := parser.ParseExprFrom(fset, "", r.src, 0)
+	if err != nil {
+		return nil, nil, newSyntaxError(err, r)
+	}
+	var file *token.File
+	fset.Iterate(func(f *token.File) bool {
+		file = f
+		return false
+	})
+	analyExpr, err := analyzeNode(&source{fset: fset, file: file, src: r}, node)
 	if err != nil {
-		return nil, fmt.Errorf("parsing error: %w", err)
+		return nil, nil, err
+	}
+	if err := checkArithmetic(analyExpr, template); err != nil {
+		return nil, nil, err
 	}
+	return analyExpr, r, nil
+}
 
-	return analyzeNode(fset, node), nil
+// AnalyzeFunction analyzes an expression without binding its placeholders,
+// ? and @name are left as ParamAnalyzer nodes.
+func AnalyzeFunction(expr string) (interface{}, error) {
+	analyExpr, _, err := parseTemplate(expr)
+	return analyExpr, err
 }
+
+// AnalyzeExpressionWithPlaceholders analyzes the template and binds the arguments to its ? placeholders.
+// The arguments are bound as typed values in the analyzed tree, they are never spliced into the source text.
 func AnalyzeExpressionWithPlaceholders(expressionTemplate string, args ...interface{}) (interface{}, error) {
-	// Replace placeholders
-	var expr strings.Builder
-	parts := strings.Split(expressionTemplate, "?")
-	if len(parts)-1 != len(args) {
-		return nil, fmt.Errorf("number of placeholders does not match number of arguments")
-	}
-
-	for i, part := range parts {
-		expr.WriteString(part)
-		if i < len(args) {
-			switch v := args[i].(type) {
-			case string:
-				expr.WriteString("\"" + v + "\"")
-			case int:
-				expr.WriteString(strconv.Itoa(v))
-			case float64:
-				expr.WriteString(strconv.FormatFloat(v, 'G', 10, 64))
-			case nil:
-				expr.WriteString(identifier_nil)
-			default:
-				return nil, fmt.Errorf("unsupported argument type: %T", v)
-			}
-		}
+	analyExpr, r, err := parseTemplate(expressionTemplate)
+	if err != nil {
+		return nil, err
 	}
-
-	// Analyze the resulting expression
-	return AnalyzeFunction(expr.String())
+	q := &Query{template: expressionTemplate, tree: analyExpr, count: r.count, names: r.names}
+	return q.bind(args)
 }
+
 func builEq(left interface{}, right interface{}, originalExpr string) (bson.D, error) {
 	if left == nil {
-		return nil, fmt.Errorf("left operand cannot be nil")
+		return nil, newError(ErrInvalidOperand, spanOf(right), originalExpr, "left operand cannot be nil")
 	}
 	switch leftType := left.(type) {
 	case FieldAnalyzer:
 		if right == nil {
-			checkNotExist := bson.D{{leftType.Name, bson.D{{"$exists", false}}}}
-			checkNull := bson.D{{leftType.Name, "null"}}
-			return bson.D{{"$or", bson.A{checkNotExist, checkNull}}}, nil
-
+			// {field: null} matches a null value as well as a missing field
+			return bson.D{{leftType.Name, nil}}, nil
 		}
 		switch rightType := right.(type) {
 		case ConstAnalyzer:
 			return bson.D{{leftType.Name, rightType.Value}}, nil
 		default:
-			return nil, fmt.Errorf("unsupported right operand type: %T parse from %s", right, originalExpr)
+			return nil, newError(ErrInvalidOperand, spanOf(right), originalExpr, "unsupported right operand")
 		}
 
 	default:
 		// Handle unexpected types (e.g., return an error or default value)
-		return nil, fmt.Errorf("unsupported expression type: %T parse from %s", leftType, originalExpr)
+		return nil, newError(ErrInvalidOperand, spanOf(left), originalExpr, "unsupported left operand")
 	}
 }
 
-func convertToMongoExpr(analyExpr interface{}, originalExpr string) (interface{}, error) {
-	switch expr := analyExpr.(type) {
-	case Analyzer:
-		left, err := convertToMongoExpr(expr.Left, originalExpr)
+// reversedOp maps a comparison operator to the one used when its operands are swapped,
+// e.g. 10 < Size is translated as Size > 10.
+var reversedOp = map[string]string{
+	">":  "<",
+	">=": "<=",
+	"<":  ">",
+	"<=": ">=",
+	"==": "==",
+	"!=": "!=",
+}
+
+// isNilAnalyzer reports whether node is the analyzed form of a bare nil.
+func isNilAnalyzer(node interface{}) bool {
+	if node == nil {
+		return true
+	}
+	f, ok := node.(FuncAnalyzer)
+	return ok && f.Name == "IsNull" && len(f.Args) == 0
+}
+
+// collectOperands flattens a chain of the same logical operator, so that
+// a && b && c becomes [a, b, c] instead of [[a, b], c].
+func collectOperands(op string, node Node, operands []Node) []Node {
+	if a, ok := node.(Analyzer); ok && a.Op == op {
+		operands = collectOperands(op, a.Left, operands)
+		return collectOperands(op, a.Right, operands)
+	}
+	return append(operands, node)
+}
+
+func buildLogical(expr Analyzer, originalExpr string) (bson.D, error) {
+	operands := collectOperands(expr.Op, expr, nil)
+	filters := make(bson.A, 0, len(operands))
+	for _, operand := range operands {
+		filter, err := buildFilter(operand, originalExpr)
 		if err != nil {
 			return nil, err
 		}
-		right, err := convertToMongoExpr(expr.Right, originalExpr)
-		if err != nil {
-			return nil, err
+		filters = append(filters, filter)
+	}
+	return bson.D{{opMapping[expr.Op], filters}}, nil
+}
+
+// buildCompare translates a comparison. Field-vs-constant comparisons produce a plain
+// {field: {$op: value}} filter so that indexes can be used; anything else falls back to $expr.
+func buildCompare(expr Analyzer, originalExpr string) (bson.D, error) {
+	op, ok := opMapping[expr.Op]
+	if !ok {
+		return nil, newError(ErrUnsupportedOperator, expr.Span, originalExpr, "unsupported operator: %s", expr.Op)
+	}
+	left, right := foldOperand(expr.Left), foldOperand(expr.Right)
+	if _, isField := right.(FieldAnalyzer); isField {
+		if _, isConst := left.(ConstAnalyzer); isConst || isNilAnalyzer(left) {
+			left, right = right, left
+			op = opMapping[reversedOp[expr.Op]]
+		}
+	}
+	if filter, ok, err := sizeFilter(op, left, right, originalExpr); ok || err != nil {
+		return filter, err
+	}
+	field, ok := left.(FieldAnalyzer)
+	if !ok {
+		return buildExprCompare(op, left, right, originalExpr)
+	}
+	if isNilAnalyzer(right) {
+		switch op {
+		case "$eq":
+			return builEq(field, nil, originalExpr)
+		case "$ne":
+			return bson.D{{field.Name, bson.D{{"$ne", nil}}}}, nil
+		default:
+			return nil, newError(ErrInvalidOperand, expr.Span, originalExpr, "nil can only be compared with == or !=")
+		}
+	}
+	switch rightType := right.(type) {
+	case ConstAnalyzer:
+		if op == "$eq" {
+			return builEq(field, rightType, originalExpr)
+		}
+		return bson.D{{field.Name, bson.D{{op, rightType.Value}}}}, nil
+	default:
+		return buildExprCompare(op, left, right, originalExpr)
+	}
+}
+
+// buildExprCompare builds {$expr: {$op: [left, right]}} for comparisons a plain filter cannot express.
+func buildExprCompare(op string, left interface{}, right interface{}, originalExpr string) (bson.D, error) {
+	l, err := toAggExpr(left, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toAggExpr(right, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{{"$expr", bson.D{{op, bson.A{l, r}}}}}, nil
+}
+
+// toAggExpr translates an analyzed node to an aggregation expression, fields become "$field" references.
+func toAggExpr(node interface{}, originalExpr string) (interface{}, error) {
+	if isNilAnalyzer(node) {
+		return nil, nil
+	}
+	switch n := node.(type) {
+	case FieldAnalyzer:
+		return "$" + n.Name, nil
+	case ConstAnalyzer:
+		if s, ok := n.Value.(string); ok && strings.HasPrefix(s, "$") {
+			// keep string constants from being read as field paths
+			return bson.D{{"$literal", s}}, nil
 		}
-		if expr.Op == "==" {
-			return builEq(left, right, originalExpr)
+		return n.Value, nil
+	case Analyzer:
+		if c, ok := foldConstant(n); ok {
+			return toAggExpr(c, originalExpr)
 		}
-		// get the operator
-		if op, ok := opMapping[expr.Op]; ok {
-			return bson.D{{op, bson.A{left, right}}}, nil
-		} else {
-			return nil, fmt.Errorf("unsupported operator: %s parse from %s", expr.Op, originalExpr)
+		if n.Right == nil {
+			// unary operators
+			operand, err := toAggExpr(n.Left, originalExpr)
+			if err != nil {
+				return nil, err
+			}
+			switch n.Op {
+			case "!":
+				return bson.D{{"$not", bson.A{operand}}}, nil
+			case "-":
+				return bson.D{{"$multiply", bson.A{-1, operand}}}, nil
+			}
+		}
+		op, ok := opMapping[n.Op]
+		if !ok {
+			op, ok = arithOps[n.Op]
+		}
+		if !ok {
+			return nil, newError(ErrUnsupportedOperator, n.Span, originalExpr, "unsupported operator: %s", n.Op)
+		}
+		operands := []Node{n.Left, n.Right}
+		if op == "$and" || op == "$or" || op == "$add" || op == "$multiply" {
+			operands = collectOperands(n.Op, n, nil)
+		}
+		args := make(bson.A, 0, len(operands))
+		for _, operand := range operands {
+			arg, err := toAggExpr(operand, originalExpr)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
 		}
+		return bson.D{{op, args}}, nil
+	case FuncAnalyzer:
+		return aggFunc(n, originalExpr)
+	default:
+		return nil, newError(ErrInvalidOperand, spanOf(node), originalExpr, "unsupported operand")
+	}
+}
+
+// arithOps maps the arithmetic operators to their aggregation operator,
+// comparisons using them are translated to $expr.
+var arithOps = map[string]string{
+	"+": "$add",
+	"-": "$subtract",
+	"*": "$multiply",
+	"/": "$divide",
+	"%": "$mod",
+}
+
+// foldConstant computes arithmetic on constants, so that Size > 10 * 1024 stays a plain filter.
+func foldConstant(node interface{}) (ConstAnalyzer, bool) {
+	switch n := node.(type) {
+	case ConstAnalyzer:
+		return n, true
+	case Analyzer:
+		left, ok := foldConstant(n.Left)
+		if !ok {
+			return ConstAnalyzer{}, false
+		}
+		if n.Right == nil {
+			if n.Op != "-" {
+				return ConstAnalyzer{}, false
+			}
+			value, ok := arithmetic("*", int64(-1), left.Value)
+			return ConstAnalyzer{Value: value, Span: n.Span}, ok && value != nil
+		}
+		if _, ok := arithOps[n.Op]; !ok {
+			return ConstAnalyzer{}, false
+		}
+		right, ok := foldConstant(n.Right)
+		if !ok {
+			return ConstAnalyzer{}, false
+		}
+		value, ok := arithmetic(n.Op, left.Value, right.Value)
+		return ConstAnalyzer{Value: value, Span: n.Span}, ok && value != nil
+	}
+	return ConstAnalyzer{}, false
+}
+
+// checkArithmetic rejects the constant arithmetic which cannot be folded, a division or a modulo by 0
+// and an integer overflow, so that it fails when the query is compiled or bound instead of on the server.
+func checkArithmetic(node interface{}, originalExpr string) error {
+	switch n := node.(type) {
+	case Analyzer:
+		for _, operand := range []interface{}{n.Left, n.Right} {
+			if err := checkArithmetic(operand, originalExpr); err != nil {
+				return err
+			}
+		}
+		if _, ok := arithOps[n.Op]; !ok {
+			return nil
+		}
+		left, leftOk := foldConstant(n.Left)
+		if n.Right == nil {
+			if leftOk && normalizeValue(left.Value) == int64(math.MinInt64) {
+				return newError(ErrInvalidOperand, n.Span, originalExpr, "integer overflow")
+			}
+			return nil
+		}
+		right, rightOk := foldConstant(n.Right)
+		if !rightOk {
+			return nil
+		}
+		if f, ok := toFloat(normalizeValue(right.Value)); ok && f == 0 && (n.Op == "/" || n.Op == "%") {
+			return newError(ErrInvalidOperand, n.Span, originalExpr, "division by zero")
+		}
+		x, xIsInt := normalizeValue(left.Value).(int64)
+		y, yIsInt := normalizeValue(right.Value).(int64)
+		if leftOk && xIsInt && yIsInt && n.Op != "/" && n.Op != "%" {
+			if _, ok := intArithmetic(n.Op, x, y); !ok {
+				return newError(ErrInvalidOperand, n.Span, originalExpr, "integer overflow")
+			}
+		}
+	case FuncAnalyzer:
+		for _, arg := range n.Args {
+			if err := checkArithmetic(arg, originalExpr); err != nil {
+				return err
+			}
+		}
+	case LambdaAnalyzer:
+		return checkArithmetic(n.Body, originalExpr)
+	}
+	return nil
+}
+
+// foldOperand returns the folded constant of node when it is constant arithmetic, node otherwise.
+func foldOperand(node interface{}) interface{} {
+	if _, ok := node.(Analyzer); ok {
+		if c, ok := foldConstant(node); ok {
+			return c
+		}
+	}
+	return node
+}
+
+// aggFunctions maps the built-in functions usable in aggregation expressions to their operator,
+// they all take a single argument. len is the length of an array.
+var aggFunctions = map[string]string{
+	"year":  "$year",
+	"month": "$month",
+	"day":   "$dayOfMonth",
+	"len":   "$size",
+	"lower": "$toLower",
+	"upper": "$toUpper",
+}
+
+func aggFunc(f FuncAnalyzer, originalExpr string) (interface{}, error) {
+	op, ok := aggFunctions[strings.ToLower(f.Name)]
+	if !ok {
+		return nil, newError(ErrUnsupportedFunction, f.Span, originalExpr, "unsupported function: %s", f.Name)
+	}
+	if len(f.Args) != 1 {
+		return nil, newError(ErrInvalidArgument, f.Span, originalExpr, "function '%s' expects exactly one argument", f.Name)
+	}
+	arg, err := toAggExpr(f.Args[0], originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{{op, arg}}, nil
+}
+
+// buildFilter translates an analyzed node used in a boolean context into a find filter.
+func buildFilter(node interface{}, originalExpr string) (bson.D, error) {
+	switch expr := node.(type) {
+	case Analyzer:
+		if expr.Op == "!" {
+			return buildNot(expr.Left, originalExpr)
+		}
+		if expr.Op == "&&" || expr.Op == "||" {
+			return buildLogical(expr, originalExpr)
+		}
+		return buildCompare(expr, originalExpr)
+	case FuncAnalyzer:
+		return buildWithFunc(expr, originalExpr)
+	case FieldAnalyzer:
+		// a bare field is a boolean flag
+		return bson.D{{expr.Name, true}}, nil
+	case handledSearch:
+		return expr.Filter, nil
+	default:
+		return nil, newError(ErrInvalidOperand, spanOf(node), originalExpr, "expression is not a condition")
+	}
+}
+
+// buildNot translates !operand, pushing the negation down to the leaves:
+// !(a && b) becomes {$or: [!a, !b]}, !(a || b) becomes {$nor: [a, b]} and !!a becomes a.
+func buildNot(operand interface{}, originalExpr string) (bson.D, error) {
+	if search, ok := findSearch(operand); ok {
+		// a $text query cannot be under $nor or $not, even deep down
+		return nil, newError(ErrInvalidOperand, spanOf(search), originalExpr, "a text search cannot be negated")
+	}
+	if a, ok := operand.(Analyzer); ok {
+		switch a.Op {
+		case "!":
+			return buildFilter(a.Left, originalExpr)
+		case "&&":
+			operands := collectOperands(a.Op, a, nil)
+			filters := make(bson.A, 0, len(operands))
+			for _, item := range operands {
+				filter, err := buildNot(item, originalExpr)
+				if err != nil {
+					return nil, err
+				}
+				filters = append(filters, filter)
+			}
+			return bson.D{{"$or", filters}}, nil
+		case "||":
+			filter, err := buildLogical(a, originalExpr)
+			if err != nil {
+				return nil, err
+			}
+			return bson.D{{"$nor", filter[0].Value}}, nil
+		}
+	}
+	filter, err := buildFilter(operand, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	return negateFilter(filter), nil
+}
+
+// negatedOp holds the query operators which have a direct opposite.
+var negatedOp = map[string]string{
+	"$eq":  "$ne",
+	"$ne":  "$eq",
+	"$in":  "$nin",
+	"$nin": "$in",
+}
+
+// negateFilter returns the filter matching exactly the documents the given filter does not match.
+// A single field condition is negated in place with $ne, $nin or $not, anything else is wrapped in $nor.
+func negateFilter(filter bson.D) bson.D {
+	if len(filter) != 1 {
+		return bson.D{{"$nor", bson.A{filter}}}
+	}
+	key, value := filter[0].Key, filter[0].Value
+	switch key {
+	case "$or":
+		return bson.D{{"$nor", value}}
+	case "$nor":
+		return bson.D{{"$or", value}}
+	case "$and":
+		items := value.(bson.A)
+		negated := make(bson.A, len(items))
+		for i, item := range items {
+			negated[i] = negateFilter(item.(bson.D))
+		}
+		return bson.D{{"$or", negated}}
+	case "$expr":
+		return bson.D{{"$expr", bson.D{{"$not", bson.A{value}}}}}
+	}
+	if strings.HasPrefix(key, "$") {
+		return bson.D{{"$nor", bson.A{filter}}}
+	}
+	ops, ok := value.(bson.D)
+	if !ok || len(ops) == 0 || !strings.HasPrefix(ops[0].Key, "$") {
+		// plain equality
+		return bson.D{{key, bson.D{{"$ne", value}}}}
+	}
+	if len(ops) == 1 {
+		if op, ok := negatedOp[ops[0].Key]; ok {
+			if op == "$eq" {
+				return bson.D{{key, ops[0].Value}}
+			}
+			return bson.D{{key, bson.D{{op, ops[0].Value}}}}
+		}
+		switch ops[0].Key {
+		case "$exists":
+			if exists, ok := ops[0].Value.(bool); ok {
+				return bson.D{{key, bson.D{{"$exists", !exists}}}}
+			}
+		case "$not":
+			return bson.D{{key, ops[0].Value}}
+		}
+	}
+	return bson.D{{key, bson.D{{"$not", ops}}}}
+}
+
+func convertToMongoExpr(analyExpr interface{}, originalExpr string) (interface{}, error) {
+	switch expr := analyExpr.(type) {
+	case Analyzer:
+		return buildFilter(expr, originalExpr)
 	case ConstAnalyzer:
 		return expr, nil
 	case nil:
@@ -247,7 +697,7 @@ func convertToMongoExpr(analyExpr interface{}, originalExpr string) (interface{}
 		return buildWithFunc(expr, originalExpr)
 	default:
 		// Handle unexpected types (e.g., return an error or default value)
-		return nil, fmt.Errorf("unsupported expression type: %T, parse from %s", expr, originalExpr)
+		return nil, newError(ErrInvalidOperand, spanOf(analyExpr), originalExpr, "unsupported operand")
 	}
 }
 
@@ -256,15 +706,7 @@ func GetMongoQueryFromString(expr string, args ...interface{}) (bson.D, error) {
 	if err != nil {
 		return nil, err
 	}
-	ret, e := convertToMongoExpr(analyExpr, expr)
-	if e != nil {
-		return nil, e
-	}
-	mongoExpr, ok := ret.(bson.D)
-	if !ok {
-		return nil, fmt.Errorf("type assertion failed: expected bson.D, got %T", ret)
-	}
-	return mongoExpr, nil
+	return buildQuery(analyExpr, expr)
 }
 
 func ToPrettyJSON(data interface{}) string {
@@ -288,112 +730,215 @@ func ToPrettyJSONOfBSON(d bson.D) string {
 	r := ToPrettyJSON(m)
 	return r
 }
-func buildIsNull(f FuncAnalyzer, originalExpr string) (bson.D, error) {
+
+// singleField returns the field a one argument function like IsNull(Name) is called with.
+func singleField(f FuncAnalyzer, originalExpr string) (FieldAnalyzer, error) {
 	if len(f.Args) != 1 {
-		return nil, fmt.Errorf("function 'IsNull' expects exactly one argument, parse from %s", originalExpr)
+		return FieldAnalyzer{}, newError(ErrInvalidArgument, f.Span, originalExpr, "function '%s' expects exactly one argument", f.Name)
 	}
-	arg, err := convertToMongoExpr(f.Args[0], originalExpr)
-	if err != nil {
-		return nil, err
-	}
-	switch argType := arg.(type) {
-	case FieldAnalyzer:
-		checjNoyExist := bson.D{{argType.Name, bson.D{{"$exists", false}}}}
-		checkNull := bson.D{{argType.Name, "null"}}
-		return bson.D{{"$or", bson.A{checjNoyExist, checkNull}}}, nil
-	default:
-		return nil, fmt.Errorf("unsupported argument type: %T, parse from %s", argType, originalExpr)
+	field, ok := f.Args[0].(FieldAnalyzer)
+	if !ok {
+		return FieldAnalyzer{}, newError(ErrInvalidArgument, spanOf(f.Args[0]), originalExpr, "function '%s' expects a field", f.Name)
 	}
+	return field, nil
 }
 
-func buildContains(f FuncAnalyzer, originalExpr string) (bson.D, error) {
-	if len(f.Args) != 2 {
-		return nil, fmt.Errorf("function 'Like' expects exactly two arguments, parse from %s", originalExpr)
-	}
-	left, err := convertToMongoExpr(f.Args[0], originalExpr)
+// buildIsNull builds the filter of IsNull(field), the same as field == nil: a null or missing field.
+func buildIsNull(f FuncAnalyzer, originalExpr string) (bson.D, error) {
+	field, err := singleField(f, originalExpr)
 	if err != nil {
 		return nil, err
 	}
-	right, err := convertToMongoExpr(f.Args[1], originalExpr)
+	return builEq(field, nil, originalExpr)
+}
+
+// buildExists builds {field: {$exists: exists}} from Exists(field) / NotExists(field),
+// unlike == nil a field holding null exists.
+func buildExists(f FuncAnalyzer, exists bool, originalExpr string) (bson.D, error) {
+	field, err := singleField(f, originalExpr)
 	if err != nil {
 		return nil, err
 	}
-	switch leftType := left.(type) {
-	case FieldAnalyzer:
-		switch rightType := right.(type) {
-		case ConstAnalyzer:
-			return bson.D{{leftType.Name, bson.D{{"$regex", right.(ConstAnalyzer).Value.(string)}}}}, nil
-		default:
-			return nil, fmt.Errorf("unsupported right operand type: %T, parse from %s", rightType, originalExpr)
-
-		}
-	default:
-		return nil, fmt.Errorf("unsupported left operand type: %T, parse from %s", leftType, originalExpr)
-	}
+	return bson.D{{field.Name, bson.D{{"$exists", exists}}}}, nil
 }
-func buildStartsWith(f FuncAnalyzer, originalExpr string) (bson.D, error) {
+
+// fieldAndString returns the field and the string constant a two argument function like Contains(Name, ?) is called with.
+func fieldAndString(f FuncAnalyzer, originalExpr string) (FieldAnalyzer, string, error) {
 	if len(f.Args) != 2 {
-		return nil, fmt.Errorf("function 'StartsWith' expects exactly two arguments, parse from %s", originalExpr)
+		return FieldAnalyzer{}, "", newError(ErrInvalidArgument, f.Span, originalExpr, "function '%s' expects exactly two arguments", f.Name)
 	}
 	left, err := convertToMongoExpr(f.Args[0], originalExpr)
 	if err != nil {
-		return nil, err
+		return FieldAnalyzer{}, "", err
 	}
 	right, err := convertToMongoExpr(f.Args[1], originalExpr)
 	if err != nil {
-		return nil, err
+		return FieldAnalyzer{}, "", err
 	}
-	switch leftType := left.(type) {
-	case FieldAnalyzer:
-		switch rightType := right.(type) {
-		case ConstAnalyzer:
-			return bson.D{{leftType.Name, bson.D{{"$regex", "^" + right.(ConstAnalyzer).Value.(string)}}}}, nil
-		default:
-			return nil, fmt.Errorf("unsupported right operand type: %T, parse from %s", rightType, originalExpr)
+	field, ok := left.(FieldAnalyzer)
+	if !ok {
+		return FieldAnalyzer{}, "", newError(ErrInvalidArgument, spanOf(f.Args[0]), originalExpr, "function '%s' expects a field as first argument", f.Name)
+	}
+	value, ok := right.(ConstAnalyzer)
+	if !ok {
+		return FieldAnalyzer{}, "", newError(ErrInvalidArgument, spanOf(f.Args[1]), originalExpr, "function '%s' expects a constant as second argument", f.Name)
+	}
+	text, ok := value.Value.(string)
+	if !ok {
+		return FieldAnalyzer{}, "", newError(ErrInvalidArgument, spanOf(f.Args[1]), originalExpr, "function '%s' expects a string, got %T", f.Name, value.Value)
+	}
+	return field, text, nil
+}
+
+// regexFunctions maps the text search functions to their pattern template (%s is the escaped text)
+// and to their $options, the I variants are case-insensitive.
+var regexFunctions = map[string][2]string{
+	"contains":    {"%s", ""},
+	"startswith":  {"^%s", ""},
+	"endswith":    {"%s$", ""},
+	"icontains":   {"%s", "i"},
+	"istartswith": {"^%s", "i"},
+	"iendswith":   {"%s$", "i"},
+}
 
+// regexFlags are the $options accepted by Regex.
+const regexFlags = "imsx"
+
+// DefaultMaxPatternLength is the longest text or pattern the text search functions accept.
+const DefaultMaxPatternLength = 512
+
+var maxPatternLength atomic.Int64
+
+func init() {
+	maxPatternLength.Store(DefaultMaxPatternLength)
+}
+
+// SetMaxPatternLength changes the longest text or pattern Contains, StartsWith, EndsWith, their I variants
+// and Regex accept, longer ones are rejected before reaching the database. 0 removes the limit.
+func SetMaxPatternLength(length int) {
+	maxPatternLength.Store(int64(length))
+}
+
+// regexPattern returns the field, the $regex pattern and the $options of Contains, StartsWith, EndsWith,
+// IContains, IStartsWith, IEndsWith and Regex. The text of the search functions is matched literally,
+// only Regex(field, pattern, flags) takes a regular expression.
+func regexPattern(f FuncAnalyzer, originalExpr string) (FieldAnalyzer, string, string, error) {
+	name := strings.ToLower(f.Name)
+	args := f
+	if name == "regex" && len(f.Args) == 3 {
+		args.Args = f.Args[:2]
+	}
+	field, text, err := fieldAndString(args, originalExpr)
+	if err != nil {
+		return FieldAnalyzer{}, "", "", err
+	}
+	if limit := maxPatternLength.Load(); limit > 0 && int64(len(text)) > limit {
+		return FieldAnalyzer{}, "", "", newError(ErrInvalidArgument, spanOf(f.Args[1]), originalExpr, "function '%s': pattern is longer than %d bytes", f.Name, limit)
+	}
+	if name != "regex" {
+		spec := regexFunctions[name]
+		return field, fmt.Sprintf(spec[0], regexp.QuoteMeta(text)), spec[1], nil
+	}
+	options := ""
+	if len(f.Args) == 3 {
+		c, ok := f.Args[2].(ConstAnalyzer)
+		if ok {
+			options, ok = c.Value.(string)
+		}
+		if !ok {
+			return FieldAnalyzer{}, "", "", newError(ErrInvalidArgument, spanOf(f.Args[2]), originalExpr, "function '%s' expects a string of flags as third argument", f.Name)
+		}
+		for _, flag := range options {
+			if !strings.ContainsRune(regexFlags, flag) {
+				return FieldAnalyzer{}, "", "", newError(ErrInvalidArgument, spanOf(f.Args[2]), originalExpr, "function '%s': unsupported flag %q, expected one of %s", f.Name, flag, regexFlags)
+			}
 		}
-	default:
-		return nil, fmt.Errorf("unsupported left operand type: %T, parse from %s", leftType, originalExpr)
 	}
+	return field, text, options, nil
 }
-func buildEndsWith(f FuncAnalyzer, originalExpr string) (bson.D, error) {
-	if len(f.Args) != 2 {
-		return nil, fmt.Errorf("function 'EndsWith' expects exactly two arguments, parse from %s", originalExpr)
-	}
-	left, err := convertToMongoExpr(f.Args[0], originalExpr)
+
+// buildRegex builds {field: {$regex: pattern}} for the text search functions, with $options when they have flags.
+func buildRegex(f FuncAnalyzer, originalExpr string) (bson.D, error) {
+	field, pattern, options, err := regexPattern(f, originalExpr)
 	if err != nil {
 		return nil, err
 	}
-	right, err := convertToMongoExpr(f.Args[1], originalExpr)
+	if options != "" {
+		return bson.D{{field.Name, bson.D{{"$regex", pattern}, {"$options", options}}}}, nil
+	}
+	return bson.D{{field.Name, bson.D{{"$regex", pattern}}}}, nil
+}
+
+// inValues returns the field and the list of values In(field, ?) / NotIn(field, ?) are called with.
+// The values may be given as a single slice argument or as a list of constants.
+func inValues(f FuncAnalyzer, originalExpr string) (FieldAnalyzer, bson.A, error) {
+	if len(f.Args) < 2 {
+		return FieldAnalyzer{}, nil, newError(ErrInvalidArgument, f.Span, originalExpr, "function '%s' expects a field and a list of values", f.Name)
+	}
+	left, err := convertToMongoExpr(f.Args[0], originalExpr)
 	if err != nil {
-		return nil, err
+		return FieldAnalyzer{}, nil, err
 	}
-	switch leftType := left.(type) {
-	case FieldAnalyzer:
+	field, ok := left.(FieldAnalyzer)
+	if !ok {
+		return FieldAnalyzer{}, nil, newError(ErrInvalidArgument, spanOf(f.Args[0]), originalExpr, "function '%s' expects a field as first argument", f.Name)
+	}
+	values := bson.A{}
+	for _, arg := range f.Args[1:] {
+		if isNilAnalyzer(arg) {
+			// a bare nil is analyzed as IsNull()
+			values = append(values, nil)
+			continue
+		}
+		right, err := convertToMongoExpr(arg, originalExpr)
+		if err != nil {
+			return FieldAnalyzer{}, nil, err
+		}
 		switch rightType := right.(type) {
 		case ConstAnalyzer:
-			return bson.D{{leftType.Name, bson.D{{"$regex", right.(ConstAnalyzer).Value.(string) + "$"}}}}, nil
+			if items, ok := rightType.Value.([]interface{}); ok {
+				values = append(values, items...)
+			} else {
+				values = append(values, rightType.Value)
+			}
 		default:
-			return nil, fmt.Errorf("unsupported right operand type: %T, parse from %s", rightType, originalExpr)
-
+			return FieldAnalyzer{}, nil, newError(ErrInvalidArgument, spanOf(arg), originalExpr, "function '%s' expects constant values", f.Name)
 		}
-	default:
-		return nil, fmt.Errorf("unsupported left operand type: %T, parse from %s", leftType, originalExpr)
 	}
+	return field, values, nil
+}
+
+// buildIn builds {field: {$in: [...]}} (or $nin) from In(field, ?) / NotIn(field, ?).
+func buildIn(f FuncAnalyzer, op string, originalExpr string) (bson.D, error) {
+	field, values, err := inValues(f, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{{field.Name, bson.D{{op, values}}}}, nil
 }
 func buildWithFunc(f FuncAnalyzer, originalExpr string) (bson.D, error) {
 
 	switch strings.ToLower(f.Name) {
 	case "isnull":
 		return buildIsNull(f, originalExpr)
-	case "contains":
-		return buildContains(f, originalExpr)
-	case "startswith":
-		return buildStartsWith(f, originalExpr)
-	case "endswith":
-		return buildEndsWith(f, originalExpr)
+	case "exists":
+		return buildExists(f, true, originalExpr)
+	case "notexists":
+		return buildExists(f, false, originalExpr)
+	case "contains", "startswith", "endswith", "icontains", "istartswith", "iendswith", "regex":
+		return buildRegex(f, originalExpr)
+	case "in":
+		return buildIn(f, "$in", originalExpr)
+	case "notin":
+		return buildIn(f, "$nin", originalExpr)
+	case "search":
+		return buildSearch(f, originalExpr)
+	case "any":
+		return buildAny(f, originalExpr)
+	case "all":
+		return buildAll(f, originalExpr)
 	default:
-		return nil, fmt.Errorf("unsupported function: %s, parse from %s", f.Name, originalExpr)
+		return nil, newError(ErrUnsupportedFunction, f.Span, originalExpr, "unsupported function: %s", f.Name)
 
 	}
 }
@@ -0,0 +1,391 @@
+package expr
+
+import (
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindQuery is the compiled form of a find statement like
+//
+//	where Size > ? order by CreatedOn desc, Name limit 50 offset 100 select Name, Size
+//
+// ready to be passed to the driver's Find.
+type FindQuery struct {
+	Filter     bson.D
+	Sort       bson.D
+	Projection bson.D
+	Skip       *int64
+	Limit      *int64
+}
+
+// FindOptions returns the sort, projection, skip and limit of the query as driver options.
+func (q *FindQuery) FindOptions() *options.FindOptions {
+	opts := options.Find()
+	if len(q.Sort) > 0 {
+		opts.SetSort(q.Sort)
+	}
+	if len(q.Projection) > 0 {
+		opts.SetProjection(q.Projection)
+	}
+	if q.Skip != nil {
+		opts.SetSkip(*q.Skip)
+	}
+	if q.Limit != nil {
+		opts.SetLimit(*q.Limit)
+	}
+	return opts
+}
+
+// clause is one part of a find statement, text is the clause body without its keyword.
+type clause struct {
+	keyword string
+	text    string
+	offset  int // offset of text in the statement
+	count   int // number of ? placeholders in text
+}
+
+// findKeywords are the clause keywords of a find statement, "order" must be followed by "by".
+var findKeywords = map[string]bool{"where": true, "order": true, "limit": true, "offset": true, "skip": true, "select": true}
+
+// scanned is a token of a find statement.
+type scanned struct {
+	tok    token.Token
+	lit    string
+	offset int
+}
+
+// startsClause reports whether the token after a keyword can start the body of its clause,
+// so that a field named like a keyword, as in limit > 5, is not taken for one.
+func startsClause(keyword string, next scanned) bool {
+	switch keyword {
+	case "order":
+		return next.tok == token.IDENT && next.lit == "by"
+	case "limit", "offset", "skip":
+		return next.tok == token.INT || next.tok == token.SUB || next.tok == token.ILLEGAL ||
+			(next.tok == token.IDENT && !findKeywords[next.lit])
+	case "select":
+		return next.tok == token.IDENT && !findKeywords[next.lit]
+	}
+	switch next.tok {
+	case token.IDENT, token.INT, token.FLOAT, token.STRING, token.CHAR, token.LPAREN, token.NOT, token.SUB, token.ILLEGAL:
+		return true
+	}
+	return false
+}
+
+// splitClauses splits a find statement at its keywords, ignoring keywords inside parentheses and string literals.
+// Keywords are lowercase and stand alone: Limit, Owner.limit or limit == 5 are fields.
+// A statement which does not start with a keyword starts with an implicit where clause.
+func splitClauses(text string) ([]clause, error) {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(text))
+	s.Init(file, []byte(text), func(token.Position, string) {}, 0)
+	var tokens []scanned
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SELECT {
+			// select is a Go keyword
+			lit = "select"
+		}
+		tokens = append(tokens, scanned{tok: tok, lit: lit, offset: file.Offset(pos)})
+	}
+
+	type mark struct {
+		keyword    string
+		start, end int // start of the keyword, end of the keyword (and of "by")
+	}
+	var marks []mark
+	var placeholders []int
+	depth := 0
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch t.tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.ILLEGAL:
+			if t.lit == "?" {
+				placeholders = append(placeholders, t.offset)
+			}
+		case token.IDENT, token.SELECT:
+			if depth != 0 || !findKeywords[t.lit] || (i > 0 && tokens[i-1].tok == token.PERIOD) {
+				continue
+			}
+			next := scanned{tok: token.EOF}
+			if i+1 < len(tokens) {
+				next = tokens[i+1]
+			}
+			if !startsClause(t.lit, next) {
+				if t.lit == "order" && next.tok == token.IDENT {
+					return nil, newError(ErrSyntax, Span{Start: t.offset, End: t.offset + len(t.lit)}, text, "expected 'by' after 'order'")
+				}
+				continue
+			}
+			m := mark{keyword: t.lit, start: t.offset, end: t.offset + len(t.lit)}
+			if t.lit == "order" {
+				i++
+				m.end = next.offset + len(next.lit)
+			}
+			marks = append(marks, m)
+		}
+	}
+	if len(marks) == 0 || strings.TrimSpace(text[:marks[0].start]) != "" {
+		marks = append([]mark{{keyword: "where"}}, marks...)
+	}
+	clauses := make([]clause, 0, len(marks))
+	seen := map[string]bool{}
+	for i, m := range marks {
+		end := len(text)
+		if i+1 < len(marks) {
+			end = marks[i+1].start
+		}
+		keyword := m.keyword
+		if keyword == "skip" {
+			keyword = "offset"
+		}
+		if seen[keyword] {
+			return nil, newError(ErrSyntax, Span{Start: m.start, End: m.end}, text, "duplicate '%s' clause", m.keyword)
+		}
+		seen[keyword] = true
+		c := clause{keyword: keyword, text: text[m.end:end], offset: m.end}
+		for _, p := range placeholders {
+			if p >= m.end && p < end {
+				c.count++
+			}
+		}
+		if strings.TrimSpace(c.text) == "" {
+			return nil, newError(ErrSyntax, Span{Start: m.start, End: m.end}, text, "empty '%s' clause", m.keyword)
+		}
+		clauses = append(clauses, c)
+	}
+	return clauses, nil
+}
+
+// shiftError moves the span of an error raised for a clause to its position in the whole statement.
+func shiftError(err error, offset int, text string) error {
+	e, ok := err.(*Error)
+	if !ok || !e.Span.IsValid() {
+		return err
+	}
+	shifted := *e
+	shifted.Span = Span{Start: e.Span.Start + offset, End: e.Span.End + offset}
+	shifted.Expr = text[shifted.Span.Start:shifted.Span.End]
+	return &shifted
+}
+
+// ParseFind compiles a find statement. The clauses are optional and may appear in any order:
+//
+//	[where] <filter> order by <field> [asc|desc], ... limit <n> offset|skip <n> select <field>, ...
+//
+// The keywords are lowercase, fields named like them (Limit, Owner.Order) are not mistaken for keywords.
+// ? placeholders are bound to args in the order they appear in the statement,
+// limit and offset accept a placeholder as well.
+func ParseFind(text string, args ...interface{}) (*FindQuery, error) {
+	return parseFind(text, nil, args)
+}
+
+// ParseFindFor is like ParseFind, field names are mapped through the tags of the model T (see CompileFor).
+func ParseFindFor[T any](text string, args ...interface{}) (*FindQuery, error) {
+	t, err := modelType[T]()
+	if err != nil {
+		return nil, err
+	}
+	return parseFind(text, t, args)
+}
+
+func parseFind(text string, model reflect.Type, args []interface{}) (*FindQuery, error) {
+	clauses, err := splitClauses(text)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, c := range clauses {
+		total += c.count
+	}
+	if total != len(args) {
+		return nil, newError(ErrParameter, Span{}, text, "number of placeholders (%d) does not match number of arguments (%d)", total, len(args))
+	}
+	q := &FindQuery{Filter: bson.D{}}
+	next := 0
+	for _, c := range clauses {
+		clauseArgs := args[next : next+c.count]
+		next += c.count
+		if err := q.addClause(c, model, clauseArgs); err != nil {
+			return nil, shiftError(err, c.offset, text)
+		}
+	}
+	if q.usesScore() && !hasTextSearch(q.Filter) {
+		return nil, newError(ErrInvalidOperand, Span{}, text, "Score() requires a text search in the filter")
+	}
+	return q, nil
+}
+
+func (q *FindQuery) addClause(c clause, model reflect.Type, args []interface{}) error {
+	switch c.keyword {
+	case "where":
+		var compiled *Query
+		var err error
+		if model != nil {
+			compiled, err = compileForType(c.text, model)
+		} else {
+			compiled, err = Compile(c.text)
+		}
+		if err != nil {
+			return err
+		}
+		q.Filter, err = compiled.Bind(args...)
+		return err
+	case "order":
+		sort, err := parseSort(c.text, model)
+		q.Sort = sort
+		return err
+	case "select":
+		return forEachItem(c.text, func(item string, offset int) error {
+			if isScore(item) {
+				q.Projection = append(q.Projection, bson.E{Key: TextScoreField, Value: textScore()})
+				return nil
+			}
+			key, err := clauseField(item, model, c.text, offset)
+			if err != nil {
+				return err
+			}
+			q.Projection = append(q.Projection, bson.E{Key: key, Value: 1})
+			return nil
+		})
+	case "limit", "offset":
+		n, err := clauseInt(c, args)
+		if err != nil {
+			return err
+		}
+		if c.keyword == "limit" {
+			q.Limit = &n
+		} else {
+			q.Skip = &n
+		}
+	}
+	return nil
+}
+
+// usesScore reports whether the text search score is projected or sorted by.
+func (q *FindQuery) usesScore() bool {
+	for _, spec := range []bson.D{q.Sort, q.Projection} {
+		for _, e := range spec {
+			if e.Key == TextScoreField && reflect.DeepEqual(e.Value, textScore()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseSort parses a sort specification like "CreatedOn desc, Name".
+func parseSort(text string, model reflect.Type) (bson.D, error) {
+	var sort bson.D
+	err := forEachItem(text, func(item string, offset int) error {
+		if isScore(item) {
+			// the most relevant documents come first
+			sort = append(sort, bson.E{Key: TextScoreField, Value: textScore()})
+			return nil
+		}
+		name, direction := item, 1
+		if fields := strings.Fields(item); len(fields) == 2 {
+			switch strings.ToLower(fields[1]) {
+			case "asc":
+			case "desc":
+				direction = -1
+			default:
+				return newError(ErrSyntax, itemSpan(item, offset), text, "expected asc or desc")
+			}
+			name = fields[0]
+		}
+		key, err := clauseField(name, model, text, offset)
+		if err != nil {
+			return err
+		}
+		sort = append(sort, bson.E{Key: key, Value: direction})
+		return nil
+	})
+	return sort, err
+}
+
+// forEachItem calls fn for every comma separated item of a clause with the item's offset in the clause.
+func forEachItem(text string, fn func(item string, offset int) error) error {
+	offset := 0
+	for _, part := range strings.Split(text, ",") {
+		item := strings.TrimSpace(part)
+		if item == "" {
+			return newError(ErrSyntax, Span{Start: offset, End: offset + len(part)}, text, "empty item")
+		}
+		if err := fn(item, offset+strings.Index(part, item)); err != nil {
+			return err
+		}
+		offset += len(part) + 1
+	}
+	return nil
+}
+
+func itemSpan(item string, offset int) Span {
+	return Span{Start: offset, End: offset + len(item)}
+}
+
+// clauseField analyzes a field name of an order by or select clause.
+func clauseField(item string, model reflect.Type, text string, offset int) (string, error) {
+	node, _, err := parseTemplate(item)
+	if err != nil {
+		return "", shiftError(err, offset, text)
+	}
+	if model != nil {
+		if node, err = resolveFields(node, model, item); err != nil {
+			return "", shiftError(err, offset, text)
+		}
+	}
+	field, ok := node.(FieldAnalyzer)
+	if !ok {
+		return "", newError(ErrInvalidOperand, itemSpan(item, offset), text, "expected a field name")
+	}
+	return field.Name, nil
+}
+
+// clauseInt reads the value of a limit or offset clause, a non negative integer or a ? placeholder.
+func clauseInt(c clause, args []interface{}) (int64, error) {
+	item := strings.TrimSpace(c.text)
+	span := itemSpan(item, strings.Index(c.text, item))
+	var n int64
+	if item == "?" {
+		value, err := normalizeArgument(args[0])
+		if err != nil {
+			return 0, newError(ErrParameter, span, c.text, "%v", err)
+		}
+		switch v := value.(type) {
+		case int:
+			n = int64(v)
+		case int32:
+			n = int64(v)
+		case int64:
+			n = v
+		default:
+			return 0, newError(ErrParameter, span, c.text, "'%s' expects an integer, got %T", c.keyword, value)
+		}
+	} else {
+		parsed, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			return 0, newError(ErrSyntax, span, c.text, "'%s' expects an integer", c.keyword)
+		}
+		n = parsed
+	}
+	if n < 0 {
+		return 0, newError(ErrInvalidArgument, span, c.text, "'%s' cannot be negative", c.keyword)
+	}
+	return n, nil
+}
@@ -0,0 +1,653 @@
+package expr
+
+import (
+	"fmt"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Format prints a compiled query back to normalized expression text, two queries with the same
+// normalized text build the same filter. The normalization
+//
+//   - folds constant arithmetic: Size > 2 * 1024 becomes Size > 2048
+//   - puts fields on the left of comparisons: 10 < Size becomes Size > 10, Used < Size becomes Size > Used
+//   - sorts the operands of && and || and the values of In, NotIn and All, operands holding ?
+//     placeholders keep their relative order so that the arguments still bind in the same order
+//   - spells functions as documented (contains becomes Contains), IsNull(x) becomes x == nil and x == true becomes x
+//   - pushes ! down like the mongo translation: !(a == b) becomes a != b, !In becomes NotIn, !(a && b) becomes !a || !b
+//   - names func literal parameters e and removes redundant parentheses
+func Format(q *Query) string {
+	return formatNode(normalize(q.tree))
+}
+
+// funcNames holds the documented spelling of the functions, function names are case-insensitive.
+var funcNames = map[string]string{}
+
+func init() {
+	for _, name := range []string{"IsNull", "Exists", "NotExists", "Contains", "StartsWith", "EndsWith",
+		"IContains", "IStartsWith", "IEndsWith", "Regex", "In", "NotIn", "Search", "Any", "All",
+		"Len", "Lower", "Upper", "Year", "Month", "Day"} {
+		funcNames[strings.ToLower(name)] = name
+	}
+}
+
+// negatedFunc holds the functions which have a direct opposite.
+var negatedFunc = map[string]string{
+	"In":        "NotIn",
+	"NotIn":     "In",
+	"Exists":    "NotExists",
+	"NotExists": "Exists",
+}
+
+// lambdaParam is the name func literal parameters are printed with.
+const lambdaParam = "e"
+
+// normalize returns the normalized copy of an analyzed tree, see Format.
+func normalize(node Node) Node {
+	switch n := node.(type) {
+	case Analyzer:
+		switch {
+		case n.Op == "!":
+			return negate(normalize(n.Left))
+		case n.Op == "&&" || n.Op == "||":
+			operands := collectOperands(n.Op, n, nil)
+			for i, operand := range operands {
+				operands[i] = normalize(operand)
+			}
+			// normalizing may turn an operand into a chain of the same operator
+			var flat []Node
+			for _, operand := range operands {
+				flat = collectOperands(n.Op, operand, flat)
+			}
+			return chain(n.Op, sortOperands(flat))
+		case n.Right == nil && n.Op == "-":
+			if c, ok := foldConstant(n); ok {
+				return c
+			}
+			return Analyzer{Op: n.Op, Left: normalize(n.Left)}
+		}
+		if _, ok := arithOps[n.Op]; ok {
+			if c, ok := foldConstant(n); ok {
+				return c
+			}
+			return Analyzer{Op: n.Op, Left: normalize(n.Left), Right: normalize(n.Right)}
+		}
+		return normalizeCompare(n)
+	case FuncAnalyzer:
+		if isNilAnalyzer(n) {
+			return FuncAnalyzer{Name: "IsNull"}
+		}
+		name := n.Name
+		if canonical, ok := funcNames[strings.ToLower(name)]; ok {
+			name = canonical
+		}
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = normalize(arg)
+		}
+		switch name {
+		case "IsNull":
+			if len(args) == 1 {
+				return Analyzer{Op: "==", Left: args[0], Right: FuncAnalyzer{Name: "IsNull"}}
+			}
+		case "In", "NotIn", "All":
+			if len(args) > 1 && !containsParam(args[1:], false) {
+				args = append(args[:1], sortValues(args[1:])...)
+			}
+		}
+		return FuncAnalyzer{Name: name, Args: args}
+	case LambdaAnalyzer:
+		return LambdaAnalyzer{Param: lambdaParam, Body: normalize(n.Body)}
+	case ConstAnalyzer:
+		return ConstAnalyzer{Value: n.Value}
+	case FieldAnalyzer:
+		return FieldAnalyzer{Name: n.Name}
+	case ParamAnalyzer:
+		return ParamAnalyzer{Index: n.Index, Name: n.Name}
+	}
+	return node
+}
+
+// normalizeCompare orients a comparison: constants and nil go to the right, of two fields the smaller name goes left.
+func normalizeCompare(n Analyzer) Node {
+	op := n.Op
+	left, right := normalize(n.Left), normalize(n.Right)
+	swap := false
+	switch l := left.(type) {
+	case ConstAnalyzer, ParamAnalyzer:
+		_, rightConst := right.(ConstAnalyzer)
+		_, rightParam := right.(ParamAnalyzer)
+		swap = !isNilAnalyzer(right) && !rightConst && !rightParam
+	case FuncAnalyzer:
+		swap = isNilAnalyzer(l) && !isNilAnalyzer(right)
+	case FieldAnalyzer:
+		r, ok := right.(FieldAnalyzer)
+		swap = ok && r.Name < l.Name
+	}
+	if reversed, ok := reversedOp[op]; ok && swap {
+		op = reversed
+		left, right = right, left
+	}
+	if c, ok := right.(ConstAnalyzer); ok && c.Value == true {
+		if field, isField := left.(FieldAnalyzer); isField {
+			// in a filter x == true is the bare flag x, and x != true is !x
+			switch op {
+			case "==":
+				return field
+			case "!=":
+				return Analyzer{Op: "!", Left: field}
+			}
+		}
+	}
+	return Analyzer{Op: op, Left: left, Right: right}
+}
+
+// negate returns the normalized negation of a normalized condition.
+func negate(node Node) Node {
+	switch n := node.(type) {
+	case Analyzer:
+		switch n.Op {
+		case "!":
+			return n.Left
+		case "==", "!=":
+			if _, isField := n.Left.(FieldAnalyzer); isField {
+				return Analyzer{Op: negatedCompare[n.Op], Left: n.Left, Right: n.Right}
+			}
+		case "&&":
+			operands := collectOperands(n.Op, n, nil)
+			for i, operand := range operands {
+				operands[i] = negate(operand)
+			}
+			var flat []Node
+			for _, operand := range operands {
+				flat = collectOperands("||", operand, flat)
+			}
+			return chain("||", sortOperands(flat))
+		}
+	case FuncAnalyzer:
+		if name, ok := negatedFunc[n.Name]; ok {
+			return FuncAnalyzer{Name: name, Args: n.Args}
+		}
+	}
+	return Analyzer{Op: "!", Left: node}
+}
+
+var negatedCompare = map[string]string{"==": "!=", "!=": "=="}
+
+// chain joins operands with a logical operator, left to right.
+func chain(op string, operands []Node) Node {
+	node := operands[0]
+	for _, operand := range operands[1:] {
+		node = Analyzer{Op: op, Left: node, Right: operand}
+	}
+	return node
+}
+
+// sortOperands sorts the operands of && and || by their text. The operands holding ? placeholders keep their
+// relative order, they only move between the places the sort gives them.
+func sortOperands(operands []Node) []Node {
+	var positional []Node
+	for _, operand := range operands {
+		if containsParam([]Node{operand}, true) {
+			positional = append(positional, operand)
+		}
+	}
+	sorted := sortValues(operands)
+	for i, operand := range sorted {
+		if containsParam([]Node{operand}, true) {
+			sorted[i], positional = positional[0], positional[1:]
+		}
+	}
+	return sorted
+}
+
+// sortValues returns the nodes sorted by their text. Duplicates are dropped, unless they hold a ? placeholder
+// which consumes an argument.
+func sortValues(nodes []Node) []Node {
+	type item struct {
+		text string
+		node Node
+	}
+	items := make([]item, len(nodes))
+	for i, node := range nodes {
+		items[i] = item{formatNode(node), node}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].text < items[j].text })
+	sorted := make([]Node, 0, len(items))
+	for i, it := range items {
+		if i > 0 && items[i-1].text == it.text && !containsParam([]Node{it.node}, true) {
+			continue
+		}
+		sorted = append(sorted, it.node)
+	}
+	return sorted
+}
+
+// containsParam reports whether the nodes hold a placeholder, only ? placeholders when positional is set.
+func containsParam(nodes []Node, positional bool) bool {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case ParamAnalyzer:
+			if !positional || n.Name == "" {
+				return true
+			}
+		case Analyzer:
+			if containsParam([]Node{n.Left, n.Right}, positional) {
+				return true
+			}
+		case FuncAnalyzer:
+			if containsParam(n.Args, positional) {
+				return true
+			}
+		case LambdaAnalyzer:
+			if containsParam([]Node{n.Body}, positional) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// precedence is the Go precedence of the operators, operands bind tighter than any operator.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+const operandPrecedence = 6
+
+// formatNode prints a node with the parentheses its operator precedence requires.
+func formatNode(node Node) string {
+	text, _ := formatPrec(node, "")
+	return text
+}
+
+func formatPrec(node Node, param string) (string, int) {
+	if isNilAnalyzer(node) {
+		return "nil", operandPrecedence
+	}
+	switch n := node.(type) {
+	case Analyzer:
+		if n.Right == nil && (n.Op == "!" || n.Op == "-") {
+			operand, prec := formatPrec(n.Left, param)
+			if prec < operandPrecedence {
+				operand = "(" + operand + ")"
+			}
+			return n.Op + operand, operandPrecedence
+		}
+		prec := precedence[n.Op]
+		left, leftPrec := formatPrec(n.Left, param)
+		if leftPrec < prec {
+			left = "(" + left + ")"
+		}
+		right, rightPrec := formatPrec(n.Right, param)
+		if rightPrec <= prec {
+			right = "(" + right + ")"
+		}
+		return left + " " + n.Op + " " + right, prec
+	case FuncAnalyzer:
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i], _ = formatPrec(arg, param)
+		}
+		return n.Name + "(" + strings.Join(args, ", ") + ")", operandPrecedence
+	case LambdaAnalyzer:
+		body, _ := formatPrec(n.Body, n.Param)
+		return "func(" + n.Param + ") bool { return " + body + " }", operandPrecedence
+	case FieldAnalyzer:
+		switch {
+		case param == "":
+			return n.Name, operandPrecedence
+		case n.Name == "":
+			return param, operandPrecedence
+		}
+		return param + "." + n.Name, operandPrecedence
+	case ParamAnalyzer:
+		if n.Name != "" {
+			return "@" + n.Name, operandPrecedence
+		}
+		return "?", operandPrecedence
+	case ConstAnalyzer:
+		return formatLiteral(n.Value), operandPrecedence
+	}
+	return fmt.Sprint(node), operandPrecedence
+}
+
+// formatLiteral prints a constant as a literal of the expression language.
+func formatLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".eEIN") {
+			// keep whole floats from being read back as integers
+			text += ".0"
+		}
+		return text
+	}
+	return fmt.Sprint(value)
+}
+
+// FromBSON converts a mongo filter back to expression text, normalized like Format. It understands the filters
+// this package builds for the supported functions: comparisons, $and, $or, $nor, $not, $in, $nin, $exists,
+// $regex, $size, $all, $elemMatch and $text, an empty $in or $nin is In(Field, []) or NotIn(Field, []).
+// $expr and values without a literal in the expression language (dates, ObjectIDs, embedded documents)
+// are rejected.
+func FromBSON(filter bson.D) (string, error) {
+	node, err := bsonReader{}.fromFilter(filter)
+	if err != nil {
+		return "", err
+	}
+	return formatNode(normalize(node)), nil
+}
+
+// bsonReader converts mongo filters to analyzed trees. FromBSON only accepts fields and values which can be
+// written in an expression, MatchBSON evaluates the tree and accepts any.
+type bsonReader struct {
+	anyValue bool
+}
+
+// fromFilter converts a filter document, its conditions are joined with &&.
+func (r bsonReader) fromFilter(filter bson.D) (Node, error) {
+	if len(filter) == 0 {
+		return nil, newError(ErrInvalidOperand, Span{}, "", "an empty filter has no expression")
+	}
+	conditions := make([]Node, 0, len(filter))
+	for _, e := range filter {
+		var condition Node
+		var err error
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			condition, err = r.fromLogical(e.Key, e.Value)
+		case "$text":
+			condition, err = fromText(e.Value)
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				return nil, newError(ErrUnsupportedOperator, Span{}, "", "unsupported operator: %s", e.Key)
+			}
+			var field FieldAnalyzer
+			field, err = r.fromField(e.Key)
+			if err == nil {
+				condition, err = r.fromCondition(field, e.Value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return chain("&&", conditions), nil
+}
+
+func (r bsonReader) fromLogical(op string, value interface{}) (Node, error) {
+	items, ok := fromArray(value)
+	if !ok || len(items) == 0 {
+		return nil, newError(ErrInvalidOperand, Span{}, "", "%s expects a non empty array of filters", op)
+	}
+	conditions := make([]Node, len(items))
+	for i, item := range items {
+		filter, ok := item.(bson.D)
+		if !ok {
+			return nil, newError(ErrInvalidOperand, Span{}, "", "%s expects a non empty array of filters", op)
+		}
+		condition, err := r.fromFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		conditions[i] = condition
+	}
+	switch op {
+	case "$and":
+		return chain("&&", conditions), nil
+	case "$or":
+		return chain("||", conditions), nil
+	}
+	return Analyzer{Op: "!", Left: chain("||", conditions)}, nil
+}
+
+func fromText(value interface{}) (Node, error) {
+	doc, ok := value.(bson.D)
+	if !ok || len(doc) != 1 || doc[0].Key != "$search" {
+		return nil, newError(ErrInvalidOperand, Span{}, "", "only {$text: {$search: text}} is supported")
+	}
+	text, ok := doc[0].Value.(string)
+	if !ok {
+		return nil, newError(ErrInvalidOperand, Span{}, "", "$search expects a string")
+	}
+	return FuncAnalyzer{Name: "Search", Args: []Node{ConstAnalyzer{Value: text}}}, nil
+}
+
+// fromField checks that every part of a field path can be written in an expression.
+func (r bsonReader) fromField(name string) (FieldAnalyzer, error) {
+	if r.anyValue {
+		return FieldAnalyzer{Name: name}, nil
+	}
+	for _, part := range strings.Split(name, ".") {
+		if !token.IsIdentifier(part) {
+			return FieldAnalyzer{}, newError(ErrInvalidOperand, Span{}, "", "field %q cannot be written in an expression", name)
+		}
+	}
+	return FieldAnalyzer{Name: name}, nil
+}
+
+// fromCondition converts the condition on a field: a value (equality) or a document of operators.
+func (r bsonReader) fromCondition(field FieldAnalyzer, value interface{}) (Node, error) {
+	switch v := value.(type) {
+	case bson.D:
+		if len(v) > 0 && strings.HasPrefix(v[0].Key, "$") {
+			return r.fromOperators(field, v)
+		}
+	case primitive.Regex:
+		return fromRegex(field, v.Pattern, v.Options), nil
+	}
+	if value == nil {
+		return Analyzer{Op: "==", Left: field, Right: FuncAnalyzer{Name: "IsNull"}}, nil
+	}
+	c, err := r.fromValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return Analyzer{Op: "==", Left: field, Right: c}, nil
+}
+
+// fromCompare maps the comparison operators to the operators of the expression language.
+var fromCompare = map[string]string{}
+
+func init() {
+	for op, mongoOp := range opMapping {
+		if _, ok := reversedOp[op]; ok {
+			fromCompare[mongoOp] = op
+		}
+	}
+}
+
+// fromOperators converts {$op: value, ...}, the conditions are joined with &&.
+func (r bsonReader) fromOperators(field FieldAnalyzer, ops bson.D) (Node, error) {
+	conditions := make([]Node, 0, len(ops))
+	for _, e := range ops {
+		var condition Node
+		switch e.Key {
+		case "$options":
+			// read with $regex
+			continue
+		case "$regex":
+			pattern, options, ok := regexOf(e.Value, ops)
+			if !ok {
+				return nil, newError(ErrInvalidOperand, Span{}, "", "$regex expects a string")
+			}
+			condition = fromRegex(field, pattern, options)
+		case "$in", "$nin", "$all":
+			items, ok := fromArray(e.Value)
+			if !ok || len(items) == 0 && e.Key == "$all" {
+				return nil, newError(ErrInvalidOperand, Span{}, "", "%s expects a non empty array", e.Key)
+			}
+			args := []Node{field}
+			if len(items) == 0 {
+				// what In(field, ?) builds for an empty slice, formatted as In(field, [])
+				args = append(args, ConstAnalyzer{Value: []interface{}{}})
+			}
+			for _, item := range items {
+				if item == nil {
+					args = append(args, FuncAnalyzer{Name: "IsNull"})
+					continue
+				}
+				c, err := r.fromValue(item)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, c)
+			}
+			name := map[string]string{"$in": "In", "$nin": "NotIn", "$all": "All"}[e.Key]
+			condition = FuncAnalyzer{Name: name, Args: args}
+		case "$exists":
+			exists, ok := e.Value.(bool)
+			if !ok {
+				return nil, newError(ErrInvalidOperand, Span{}, "", "$exists expects a boolean")
+			}
+			condition = FuncAnalyzer{Name: "NotExists", Args: []Node{field}}
+			if exists {
+				condition = FuncAnalyzer{Name: "Exists", Args: []Node{field}}
+			}
+		case "$size":
+			size, err := r.fromValue(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			condition = Analyzer{Op: "==", Left: FuncAnalyzer{Name: "Len", Args: []Node{field}}, Right: size}
+		case "$not":
+			inner, err := r.fromCondition(field, e.Value)
+			if err != nil {
+				return nil, err
+			}
+			condition = Analyzer{Op: "!", Left: inner}
+		case "$elemMatch":
+			body, err := r.fromElemMatch(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			condition = FuncAnalyzer{Name: "Any", Args: []Node{field, LambdaAnalyzer{Param: lambdaParam, Body: body}}}
+		default:
+			op, ok := fromCompare[e.Key]
+			if !ok {
+				return nil, newError(ErrUnsupportedOperator, Span{}, "", "unsupported operator: %s", e.Key)
+			}
+			if e.Value == nil {
+				condition = Analyzer{Op: op, Left: field}
+				break
+			}
+			c, err := r.fromValue(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			condition = Analyzer{Op: op, Left: field, Right: c}
+		}
+		conditions = append(conditions, condition)
+	}
+	return chain("&&", conditions), nil
+}
+
+// fromElemMatch converts the sub-filter of $elemMatch, operators at its top level apply to the element itself.
+func (r bsonReader) fromElemMatch(value interface{}) (Node, error) {
+	doc, ok := value.(bson.D)
+	if !ok || len(doc) == 0 {
+		return nil, newError(ErrInvalidOperand, Span{}, "", "$elemMatch expects a filter")
+	}
+	switch key := doc[0].Key; key {
+	case "$and", "$or", "$nor", "$text":
+	default:
+		if strings.HasPrefix(key, "$") {
+			return r.fromOperators(FieldAnalyzer{}, doc)
+		}
+	}
+	return r.fromFilter(doc)
+}
+
+func regexOf(value interface{}, ops bson.D) (string, string, bool) {
+	if r, ok := value.(primitive.Regex); ok {
+		return r.Pattern, r.Options, true
+	}
+	pattern, ok := value.(string)
+	if !ok {
+		return "", "", false
+	}
+	options := ""
+	for _, e := range ops {
+		if e.Key == "$options" {
+			options, ok = e.Value.(string)
+		}
+	}
+	return pattern, options, ok
+}
+
+// fromRegex converts a regular expression, the patterns built by the text search functions get their function back.
+func fromRegex(field FieldAnalyzer, pattern string, options string) Node {
+	if options == "" || options == "i" {
+		prefix := ""
+		if options == "i" {
+			prefix = "I"
+		}
+		name, text := "Contains", pattern
+		switch {
+		case strings.HasPrefix(text, "^") && strings.HasSuffix(text, "$"):
+			name = ""
+		case strings.HasPrefix(text, "^"):
+			name, text = "StartsWith", text[1:]
+		case strings.HasSuffix(text, "$"):
+			name, text = "EndsWith", text[:len(text)-1]
+		}
+		if literal, ok := unquoteMeta(text); ok && name != "" {
+			return FuncAnalyzer{Name: prefix + name, Args: []Node{field, ConstAnalyzer{Value: literal}}}
+		}
+	}
+	args := []Node{field, ConstAnalyzer{Value: pattern}}
+	if options != "" {
+		args = append(args, ConstAnalyzer{Value: options})
+	}
+	return FuncAnalyzer{Name: "Regex", Args: args}
+}
+
+// unquoteMeta returns the text a pattern matches literally when the pattern is regexp.QuoteMeta of it.
+func unquoteMeta(pattern string) (string, bool) {
+	var text strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		text.WriteByte(pattern[i])
+	}
+	return text.String(), regexp.QuoteMeta(text.String()) == pattern
+}
+
+// fromValue converts a value to a constant, for FromBSON it must have a literal in the expression language.
+func (r bsonReader) fromValue(value interface{}) (ConstAnalyzer, error) {
+	if r.anyValue {
+		return ConstAnalyzer{Value: value}, nil
+	}
+	switch v := value.(type) {
+	case string, bool, int, int32, int64, float64:
+		return ConstAnalyzer{Value: v}, nil
+	case float32:
+		return ConstAnalyzer{Value: float64(v)}, nil
+	}
+	return ConstAnalyzer{}, newError(ErrInvalidOperand, Span{}, "", "%T value %v has no literal in an expression", value, value)
+}
+
+func fromArray(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case bson.A:
+		return v, true
+	case []interface{}:
+		return v, true
+	}
+	return nil, false
+}
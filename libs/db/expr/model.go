@@ -0,0 +1,225 @@
+package expr
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/unvs/libs/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// modelField is a field of a model as seen by the query language.
+type modelField struct {
+	key string       // the document key, from the field or bson tag, else the Go name
+	typ reflect.Type // the field type, pointers removed
+}
+
+var modelFieldsCache sync.Map // reflect.Type -> map[string]modelField
+
+// modelFields returns the fields of a struct type indexed by both their Go name and their document key,
+// from the metadata of the models registry.
+func modelFields(t reflect.Type) (map[string]modelField, error) {
+	if fields, ok := modelFieldsCache.Load(t); ok {
+		return fields.(map[string]modelField), nil
+	}
+	m, err := models.Of(t)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]modelField, 2*len(m.Fields))
+	for _, f := range m.Fields {
+		fields[f.Name] = modelField{key: f.Key, typ: derefType(f.Type)}
+	}
+	for _, f := range m.Fields {
+		if _, ok := fields[f.Key]; !ok {
+			fields[f.Key] = modelField{key: f.Key, typ: derefType(f.Type)}
+		}
+	}
+	modelFieldsCache.Store(t, fields)
+	return fields, nil
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// resolvePath maps a dotted path of Go names or document keys to the document path.
+// ok is false when a part of the path is not a field of the model.
+func resolvePath(t reflect.Type, path string) (string, bool) {
+	key, _, ok := resolvePathType(t, path)
+	return key, ok
+}
+
+// resolvePathType is resolvePath which also returns the Go type of the field the path ends at.
+func resolvePathType(t reflect.Type, path string) (string, reflect.Type, bool) {
+	parts := strings.Split(path, ".")
+	keys := make([]string, 0, len(parts))
+	for i, part := range parts {
+		t = derefType(t)
+		for t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			if t.Elem().Kind() == reflect.Uint8 {
+				break
+			}
+			t = derefType(t.Elem())
+			if _, err := strconv.Atoi(part); err == nil {
+				// array index
+				keys = append(keys, part)
+				part = ""
+				break
+			}
+		}
+		if part == "" {
+			continue
+		}
+		switch t.Kind() {
+		case reflect.Map, reflect.Interface:
+			// free form documents, the rest of the path cannot be checked
+			return strings.Join(append(keys, parts[i:]...), "."), t, true
+		case reflect.Struct:
+		default:
+			return "", nil, false
+		}
+		fields, err := modelFields(t)
+		if err != nil {
+			return "", nil, false
+		}
+		field, ok := fields[part]
+		if !ok && part == "_id" {
+			field, ok = fields["ID"]
+		}
+		if !ok {
+			return "", nil, false
+		}
+		keys = append(keys, field.key)
+		t = field.typ
+	}
+	return strings.Join(keys, "."), t, true
+}
+
+// resolveFields returns a copy of the analyzed tree with the field names mapped through the tags of the model.
+func resolveFields(node Node, t reflect.Type, originalExpr string) (Node, error) {
+	switch n := node.(type) {
+	case FieldAnalyzer:
+		key, ok := resolvePath(t, n.Name)
+		if !ok {
+			return nil, newError(ErrUnknownField, n.Span, originalExpr, "unknown field %s of %s", n.Name, t)
+		}
+		return FieldAnalyzer{Name: key, Span: n.Span}, nil
+	case Analyzer:
+		left, err := resolveFields(n.Left, t, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		right, err := resolveFields(n.Right, t, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		return Analyzer{Op: n.Op, Left: left, Right: right, Span: n.Span}, nil
+	case FuncAnalyzer:
+		if n.Args == nil {
+			return n, nil
+		}
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			if lambda, ok := arg.(LambdaAnalyzer); ok && i > 0 {
+				resolved, err := resolveLambda(lambda, n.Args[0], t, originalExpr)
+				if err != nil {
+					return nil, err
+				}
+				args[i] = resolved
+				continue
+			}
+			resolved, err := resolveFields(arg, t, originalExpr)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = resolved
+		}
+		return FuncAnalyzer{Name: n.Name, Args: args, Span: n.Span}, nil
+	default:
+		return node, nil
+	}
+}
+
+// resolveLambda maps the fields of a lambda body through the element type of the array it applies to.
+func resolveLambda(lambda LambdaAnalyzer, array Node, t reflect.Type, originalExpr string) (Node, error) {
+	field, ok := array.(FieldAnalyzer)
+	if !ok {
+		return lambda, nil
+	}
+	_, elem, ok := resolvePathType(t, field.Name)
+	if !ok {
+		return nil, newError(ErrUnknownField, field.Span, originalExpr, "unknown field %s of %s", field.Name, t)
+	}
+	elem = derefType(elem)
+	if elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array {
+		elem = elem.Elem()
+	}
+	body, err := resolveFields(lambda.Body, elem, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	return LambdaAnalyzer{Param: lambda.Param, Body: body, Span: lambda.Span}, nil
+}
+
+// modelType returns the struct type of T, pointers removed.
+func modelType[T any]() (reflect.Type, error) {
+	t := derefType(reflect.TypeOf((*T)(nil)).Elem())
+	if t.Kind() != reflect.Struct {
+		return nil, &Error{Code: ErrUnknownField, Message: "model must be a struct, got " + t.String()}
+	}
+	return t, nil
+}
+
+// CompileFor compiles a template for the model T: identifiers and dotted paths may use the Go field names
+// or the document keys, they are mapped to the keys given by the field (or bson) tags.
+// Fields which do not exist in T are rejected when compiling. The options are those of Compile.
+func CompileFor[T any](template string, opts ...CompileOption) (*Query, error) {
+	t, err := modelType[T]()
+	if err != nil {
+		return nil, err
+	}
+	q, err := compileForType(template, t)
+	if err != nil {
+		return nil, err
+	}
+	return q.with(opts), nil
+}
+
+func compileForType(template string, t reflect.Type) (*Query, error) {
+	key := cacheKey{template: template, model: t}
+	if q, ok := queryCache.get(key); ok {
+		return q, nil
+	}
+	tree, r, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	tree, err = resolveFields(tree, t, template)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{template: template, tree: tree, count: r.count, names: r.names}
+	queryCache.add(key, q)
+	return q, nil
+}
+
+// GetMongoQueryFor builds a mongo filter for the model T, e.g. with
+//
+//	type Accounts struct {
+//		Username string `field:"username"`
+//	}
+//
+// GetMongoQueryFor[Accounts]("Username == ?", "admin") returns {username: "admin"}.
+func GetMongoQueryFor[T any](expr string, args ...interface{}) (bson.D, error) {
+	q, err := CompileFor[T](expr)
+	if err != nil {
+		return nil, err
+	}
+	return q.Bind(args...)
+}
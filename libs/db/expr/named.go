@@ -0,0 +1,137 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// AnalyzeExpressionWithNamed analyzes the template and binds params to its @name parameters.
+// A parameter may be referenced several times, every referenced parameter must be given
+// and every given parameter must be referenced.
+func AnalyzeExpressionWithNamed(expressionTemplate string, params map[string]interface{}) (interface{}, error) {
+	analyExpr, r, err := parseTemplate(expressionTemplate)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{template: expressionTemplate, tree: analyExpr, count: r.count, names: r.names}
+	return q.bindNamed(params)
+}
+
+// GetMongoQueryFromNamed builds a mongo filter from an expression using @name parameters, e.g.
+//
+//	GetMongoQueryFromNamed("TenantId == @tenant && CreatedOn >= @from && CreatedOn < @to", map[string]any{...})
+func GetMongoQueryFromNamed(expr string, params map[string]interface{}) (bson.D, error) {
+	analyExpr, err := AnalyzeExpressionWithNamed(expr, params)
+	if err != nil {
+		return nil, err
+	}
+	return buildQuery(analyExpr, expr)
+}
+
+// GetMongoQueryFromStruct is like GetMongoQueryFromNamed but reads the parameters from the
+// exported fields of a struct, @Tenant is bound to the field Tenant.
+// Fields which are not referenced by the expression are ignored.
+func GetMongoQueryFromStruct(expr string, params interface{}) (bson.D, error) {
+	values, err := structParams(params)
+	if err != nil {
+		return nil, err
+	}
+	analyExpr, names, err := analyzeNamed(expr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkParams(names, values, false, expr); err != nil {
+		return nil, err
+	}
+	analyExpr, err = bindChecked(analyExpr, nil, values, expr)
+	if err != nil {
+		return nil, err
+	}
+	return buildQuery(analyExpr, expr)
+}
+
+func analyzeNamed(expressionTemplate string) (Node, []string, error) {
+	analyExpr, r, err := parseTemplate(expressionTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.count > 0 {
+		return nil, nil, newError(ErrParameter, Span{}, expressionTemplate, "positional placeholders (?) cannot be mixed with named parameters")
+	}
+	return analyExpr, r.names, nil
+}
+
+// checkParams reports the referenced parameters missing from params and,
+// when checkUnused is set, the given parameters which are not referenced.
+func checkParams(names []string, params map[string]interface{}, checkUnused bool, originalExpr string) error {
+	used := make(map[string]bool, len(names))
+	var missing []string
+	for _, name := range names {
+		used[name] = true
+		if _, ok := params[name]; !ok {
+			missing = append(missing, "@"+name)
+		}
+	}
+	var unused []string
+	if checkUnused {
+		for name := range params {
+			if !used[name] {
+				unused = append(unused, "@"+name)
+			}
+		}
+		sort.Strings(unused)
+	}
+	switch {
+	case len(missing) > 0 && len(unused) > 0:
+		return newError(ErrParameter, Span{}, originalExpr, "missing parameters: %s; unused parameters: %s", strings.Join(missing, ", "), strings.Join(unused, ", "))
+	case len(missing) > 0:
+		return newError(ErrParameter, Span{}, originalExpr, "missing parameters: %s", strings.Join(missing, ", "))
+	case len(unused) > 0:
+		return newError(ErrParameter, Span{}, originalExpr, "unused parameters: %s", strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+// structParams collects the exported fields of a struct (including embedded structs) by field name.
+func structParams(params interface{}) (map[string]interface{}, error) {
+	v := reflect.ValueOf(params)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, fmt.Errorf("params cannot be nil")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("params must be a struct or a pointer to a struct, but got %v", v.Kind())
+	}
+	values := make(map[string]interface{})
+	collectStructParams(v, values)
+	return values, nil
+}
+
+// collectStructParams adds the fields of v which are not already in values,
+// fields of embedded structs are shadowed by the fields of the outer struct.
+func collectStructParams(v reflect.Value, values map[string]interface{}) {
+	t := v.Type()
+	var embedded []reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, v.Field(i))
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if _, ok := values[field.Name]; !ok {
+			values[field.Name] = v.Field(i).Interface()
+		}
+	}
+	for _, e := range embedded {
+		collectStructParams(e, values)
+	}
+}
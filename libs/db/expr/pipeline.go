@@ -0,0 +1,327 @@
+package expr
+
+import (
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// Pipeline builds an aggregation pipeline, the expressions of its stages use the same syntax as filters:
+//
+//	expr.NewPipeline().
+//		Match("TenantId == ?", tenant).
+//		Group("Ext", map[string]string{"files": "count()", "size": "sum(Size)"}).
+//		Sort("size desc").
+//		Build()
+//
+// The first error met is kept and returned by Build, the following stages are ignored.
+type Pipeline struct {
+	stages driver.Pipeline
+	err    error
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// accumulatorOps maps the functions usable in Group to their accumulator operator.
+var accumulatorOps = map[string]string{
+	"sum":      "$sum",
+	"avg":      "$avg",
+	"min":      "$min",
+	"max":      "$max",
+	"first":    "$first",
+	"last":     "$last",
+	"push":     "$push",
+	"addtoset": "$addToSet",
+}
+
+func (p *Pipeline) add(build func() (bson.D, error)) *Pipeline {
+	if p.err != nil {
+		return p
+	}
+	stage, err := build()
+	if err != nil {
+		p.err = err
+		return p
+	}
+	p.stages = append(p.stages, stage)
+	return p
+}
+
+// Match adds a $match stage with the filter built from expr and its arguments.
+func (p *Pipeline) Match(expr string, args ...interface{}) *Pipeline {
+	return p.add(func() (bson.D, error) {
+		filter, err := GetMongoQueryFromString(expr, args...)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{"$match", filter}}, nil
+	})
+}
+
+// Group adds a $group stage. key is a comma separated list of expressions, items may be named:
+//
+//	"TenantId"                                        -> _id: "$TenantId"
+//	"TenantId, Ext"                                   -> _id: {TenantId: "$TenantId", Ext: "$Ext"}
+//	"year: year(CreatedOn), month: month(CreatedOn)"  -> _id: {year: {$year: "$CreatedOn"}, month: {$month: "$CreatedOn"}}
+//
+// An empty key groups all documents together. accumulators maps the output fields to
+// sum(x), avg(x), min(x), max(x), first(x), last(x), push(x), addToSet(x) or count().
+func (p *Pipeline) Group(key string, accumulators map[string]string) *Pipeline {
+	return p.add(func() (bson.D, error) {
+		id, err := groupKey(key)
+		if err != nil {
+			return nil, err
+		}
+		group := bson.D{{"_id", id}}
+		names := make([]string, 0, len(accumulators))
+		for name := range accumulators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			acc, err := accumulator(accumulators[name])
+			if err != nil {
+				return nil, err
+			}
+			group = append(group, bson.E{Key: name, Value: acc})
+		}
+		return bson.D{{"$group", group}}, nil
+	})
+}
+
+// Project adds a $project stage from a comma separated list of fields, -Field excludes a field
+// and name: expression adds a computed field.
+func (p *Pipeline) Project(fields string) *Pipeline {
+	return p.add(func() (bson.D, error) {
+		items, err := splitItems(fields)
+		if err != nil {
+			return nil, err
+		}
+		var projection bson.D
+		for _, item := range items {
+			node, _, err := parseTemplate(item.text)
+			if err != nil {
+				return nil, shiftError(err, item.offset, fields)
+			}
+			if item.name != "" {
+				value, err := toAggExpr(node, item.text)
+				if err != nil {
+					return nil, shiftError(err, item.offset, fields)
+				}
+				projection = append(projection, bson.E{Key: item.name, Value: value})
+				continue
+			}
+			include := 1
+			if a, ok := node.(Analyzer); ok && a.Op == "-" && a.Right == nil {
+				include, node = 0, a.Left
+			}
+			field, ok := node.(FieldAnalyzer)
+			if !ok {
+				return nil, newError(ErrInvalidOperand, Span{Start: item.offset, End: item.offset + len(item.text)}, fields, "expected a field name or name: expression")
+			}
+			projection = append(projection, bson.E{Key: field.Name, Value: include})
+		}
+		return bson.D{{"$project", projection}}, nil
+	})
+}
+
+// Sort adds a $sort stage from a specification like "CreatedOn desc, Name".
+func (p *Pipeline) Sort(spec string) *Pipeline {
+	return p.add(func() (bson.D, error) {
+		order, err := parseSort(spec, nil)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{"$sort", order}}, nil
+	})
+}
+
+// Unwind adds an $unwind stage for the array field at path.
+func (p *Pipeline) Unwind(path string) *Pipeline {
+	return p.add(func() (bson.D, error) {
+		field, err := clauseField(strings.TrimSpace(path), nil, path, strings.Index(path, strings.TrimSpace(path)))
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{"$unwind", "$" + field}}, nil
+	})
+}
+
+// Lookup adds a $lookup stage joining the documents of the collection from whose foreignField equals localField.
+func (p *Pipeline) Lookup(from, localField, foreignField, as string) *Pipeline {
+	return p.add(func() (bson.D, error) {
+		return bson.D{{"$lookup", bson.D{
+			{"from", from},
+			{"localField", localField},
+			{"foreignField", foreignField},
+			{"as", as},
+		}}}, nil
+	})
+}
+
+// Facet adds a $facet stage running each sub pipeline on the same input documents.
+func (p *Pipeline) Facet(facets map[string]*Pipeline) *Pipeline {
+	return p.add(func() (bson.D, error) {
+		names := make([]string, 0, len(facets))
+		for name := range facets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		facet := bson.D{}
+		for _, name := range names {
+			if facets[name] == nil {
+				return nil, newError(ErrInvalidArgument, Span{}, name, "facet '%s' has no pipeline", name)
+			}
+			stages, err := facets[name].Build()
+			if err != nil {
+				return nil, err
+			}
+			facet = append(facet, bson.E{Key: name, Value: stages})
+		}
+		return bson.D{{"$facet", facet}}, nil
+	})
+}
+
+// Build returns the stages of the pipeline, or the first error met while adding them.
+func (p *Pipeline) Build() (driver.Pipeline, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.stages == nil {
+		return driver.Pipeline{}, nil
+	}
+	return p.stages, nil
+}
+
+func groupKey(key string) (interface{}, error) {
+	if strings.TrimSpace(key) == "" || strings.TrimSpace(key) == identifier_nil {
+		return nil, nil
+	}
+	items, err := splitItems(key)
+	if err != nil {
+		return nil, err
+	}
+	id := bson.D{}
+	for _, item := range items {
+		node, _, err := parseTemplate(item.text)
+		if err != nil {
+			return nil, shiftError(err, item.offset, key)
+		}
+		value, err := toAggExpr(node, item.text)
+		if err != nil {
+			return nil, shiftError(err, item.offset, key)
+		}
+		if len(items) == 1 && item.name == "" {
+			return value, nil
+		}
+		name := item.name
+		if name == "" {
+			field, ok := node.(FieldAnalyzer)
+			if !ok {
+				return nil, newError(ErrInvalidOperand, Span{Start: item.offset, End: item.offset + len(item.text)}, key, "computed group keys must be named, e.g. month: month(CreatedOn)")
+			}
+			name = field.Name[strings.LastIndex(field.Name, ".")+1:]
+		}
+		id = append(id, bson.E{Key: name, Value: value})
+	}
+	return id, nil
+}
+
+// accumulator compiles a Group accumulator like sum(Size) or count().
+func accumulator(text string) (interface{}, error) {
+	node, _, err := parseTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := node.(FuncAnalyzer)
+	if !ok {
+		return nil, newError(ErrInvalidOperand, spanOf(node), text, "expected an accumulator like sum(Size) or count()")
+	}
+	name := strings.ToLower(f.Name)
+	if name == "count" {
+		if len(f.Args) != 0 {
+			return nil, newError(ErrInvalidArgument, f.Span, text, "function 'count' expects no argument")
+		}
+		return bson.D{{"$sum", 1}}, nil
+	}
+	op, ok := accumulatorOps[name]
+	if !ok {
+		return nil, newError(ErrUnsupportedFunction, f.Span, text, "unsupported accumulator: %s", f.Name)
+	}
+	if len(f.Args) != 1 {
+		return nil, newError(ErrInvalidArgument, f.Span, text, "function '%s' expects exactly one argument", f.Name)
+	}
+	arg, err := toAggExpr(f.Args[0], text)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{{op, arg}}, nil
+}
+
+// listItem is an element of a comma separated list, "name: expression" items carry their name.
+type listItem struct {
+	name   string
+	text   string
+	offset int // offset of text in the list
+}
+
+// splitItems splits a comma separated list of expressions, commas inside parentheses and strings are kept.
+func splitItems(list string) ([]listItem, error) {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(list))
+	s.Init(file, []byte(list), func(token.Position, string) {}, 0)
+
+	var items []listItem
+	depth, start, colon := 0, 0, -1
+	flush := func(end int) error {
+		text := list[start:end]
+		it := listItem{text: text, offset: start}
+		if colon >= 0 {
+			it.name = strings.TrimSpace(list[start:colon])
+			it.text, it.offset = list[colon+1:end], colon+1
+		}
+		trimmed := strings.TrimSpace(it.text)
+		if trimmed == "" || (colon >= 0 && !token.IsIdentifier(it.name)) {
+			return newError(ErrSyntax, Span{Start: start, End: end}, list, "invalid list item")
+		}
+		it.offset += strings.Index(it.text, trimmed)
+		it.text = trimmed
+		items = append(items, it)
+		return nil
+	}
+	for {
+		pos, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		offset := file.Offset(pos)
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.COLON:
+			if depth == 0 && colon < 0 {
+				colon = offset
+			}
+		case token.COMMA:
+			if depth == 0 {
+				if err := flush(offset); err != nil {
+					return nil, err
+				}
+				start, colon = offset+1, -1
+			}
+		}
+	}
+	if err := flush(len(list)); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
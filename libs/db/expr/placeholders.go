@@ -0,0 +1,315 @@
+package expr
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// placeholderPrefix is the identifier a ? placeholder is rewritten to before parsing,
+// the placeholder index is appended (__p0, __p1, ...). Only the identifiers at the rewritten offsets are
+// placeholders, the same names written in the template are fields (see isPlaceholder).
+const placeholderPrefix = "__p"
+
+// namedPrefix is the identifier a @name parameter is rewritten to before parsing (__n_name).
+const namedPrefix = "__n_"
+
+// emptyList is the identifier an empty list [] is rewritten to before parsing, In(Ext, []) is the text of
+// In(Ext, ?) bound to an empty slice.
+const emptyList = "__empty"
+
+// ParamAnalyzer is a placeholder in the analyzed tree, it is replaced by a ConstAnalyzer when arguments are bound.
+// Positional placeholders (?) carry their Index, named parameters (@name) carry their Name.
+type ParamAnalyzer struct {
+	Index int
+	Name  string
+	Span  Span
+}
+
+// rewritten is an expression template after its placeholders were replaced by identifiers.
+type rewritten struct {
+	template string   // the original expression
+	src      string   // the source handed to go/parser
+	count    int      // number of positional placeholders
+	names    []string // distinct parameter names in order of appearance
+	edits    []edit
+}
+
+// edit records one placeholder replacement, so that positions in src can be mapped back to template.
+type edit struct {
+	offset     int // offset of the replacement in src
+	length     int // length of the replacement in src
+	origOffset int // offset of the placeholder in template
+	origLength int // length of the placeholder in template
+}
+
+// originalOffset maps an offset in the rewritten source to the original template,
+// offsets inside a replacement are mapped to the start or the end of the placeholder.
+func (r *rewritten) originalOffset(offset int) int {
+	orig := offset
+	for _, e := range r.edits {
+		switch {
+		case offset < e.offset:
+			return orig
+		case offset == e.offset:
+			return e.origOffset
+		case offset < e.offset+e.length:
+			return e.origOffset + e.origLength
+		default:
+			orig = e.origOffset + e.origLength + offset - (e.offset + e.length)
+		}
+	}
+	return orig
+}
+
+// rewritePlaceholders replaces every ? and @name outside of string literals by a placeholder identifier, and
+// an empty list argument [] by emptyList, so that the template can be parsed as a Go expression.
+func rewritePlaceholders(template string) *rewritten {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(template))
+	// scanning errors are reported by the parser later on
+	s.Init(file, []byte(template), func(token.Position, string) {}, 0)
+
+	r := &rewritten{template: template}
+	var src strings.Builder
+	seen := map[string]bool{}
+	last, at, bracket := 0, -1, -1
+	prev := token.ILLEGAL
+	replace := func(start, end int, ident string) {
+		src.WriteString(template[last:start])
+		r.edits = append(r.edits, edit{offset: src.Len(), length: len(ident), origOffset: start, origLength: end - start})
+		src.WriteString(ident)
+		last = end
+	}
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		offset := file.Offset(pos)
+		if bracket >= 0 && tok == token.RBRACK && offset == bracket+1 {
+			replace(bracket, offset+1, emptyList)
+		}
+		if tok == token.LBRACK && (prev == token.LPAREN || prev == token.COMMA) {
+			// [] is a list as an argument only, x[] stays a syntax error
+			bracket = offset
+		} else {
+			bracket = -1
+		}
+		prev = tok
+		if at >= 0 && tok == token.IDENT && offset == at+1 {
+			// @name
+			replace(at, offset+len(lit), namedPrefix+lit)
+			if !seen[lit] {
+				seen[lit] = true
+				r.names = append(r.names, lit)
+			}
+		}
+		at = -1
+		if tok != token.ILLEGAL {
+			continue
+		}
+		switch lit {
+		case "?":
+			replace(offset, offset+1, placeholderPrefix+strconv.Itoa(r.count))
+			r.count++
+		case "@":
+			at = offset
+		}
+	}
+	src.WriteString(template[last:])
+	r.src = src.String()
+	return r
+}
+
+// isPlaceholder reports whether an identifier at an offset of src was written in place of a placeholder,
+// an identifier of the template which happens to look like one (__p0) is a field.
+func (r *rewritten) isPlaceholder(offset int) bool {
+	for _, e := range r.edits {
+		if e.offset == offset {
+			return true
+		}
+	}
+	return false
+}
+
+// paramName returns the parameter name of a rewritten @name identifier.
+func paramName(name string) (string, bool) {
+	if !strings.HasPrefix(name, namedPrefix) {
+		return "", false
+	}
+	return name[len(namedPrefix):], true
+}
+
+// paramIndex returns the placeholder index of a rewritten identifier.
+func paramIndex(name string) (int, bool) {
+	if !strings.HasPrefix(name, placeholderPrefix) {
+		return 0, false
+	}
+	index, err := strconv.Atoi(name[len(placeholderPrefix):])
+	if err != nil {
+		return 0, false
+	}
+	return index, true
+}
+
+// bindParams returns a copy of the analyzed tree with every ParamAnalyzer replaced by its argument,
+// positional placeholders are looked up in args and named parameters in params.
+func bindParams(node Node, args []interface{}, params map[string]interface{}, originalExpr string) (Node, error) {
+	switch n := node.(type) {
+	case ParamAnalyzer:
+		var arg interface{}
+		if n.Name != "" {
+			v, ok := params[n.Name]
+			if !ok {
+				return nil, newError(ErrParameter, n.Span, originalExpr, "missing parameter @%s", n.Name)
+			}
+			arg = v
+		} else {
+			if n.Index >= len(args) {
+				return nil, newError(ErrParameter, n.Span, originalExpr, "missing argument for placeholder %d", n.Index+1)
+			}
+			arg = args[n.Index]
+		}
+		value, err := normalizeArgument(arg)
+		if err != nil {
+			e := newError(ErrParameter, n.Span, originalExpr, "argument %d: %v", n.Index+1, err)
+			if n.Name != "" {
+				e = newError(ErrParameter, n.Span, originalExpr, "parameter @%s: %v", n.Name, err)
+			}
+			e.Err = err
+			return nil, e
+		}
+		if value == nil {
+			return FuncAnalyzer{Name: "IsNull", Span: n.Span}, nil
+		}
+		return ConstAnalyzer{Value: value, Span: n.Span}, nil
+	case Analyzer:
+		left, err := bindParams(n.Left, args, params, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		right, err := bindParams(n.Right, args, params, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		return Analyzer{Op: n.Op, Left: left, Right: right, Span: n.Span}, nil
+	case FuncAnalyzer:
+		if n.Args == nil {
+			return n, nil
+		}
+		bound := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			value, err := bindParams(arg, args, params, originalExpr)
+			if err != nil {
+				return nil, err
+			}
+			bound[i] = value
+		}
+		return FuncAnalyzer{Name: n.Name, Args: bound, Span: n.Span}, nil
+	case LambdaAnalyzer:
+		body, err := bindParams(n.Body, args, params, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		return LambdaAnalyzer{Param: n.Param, Body: body, Span: n.Span}, nil
+	default:
+		return node, nil
+	}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+// normalizeArgument checks a placeholder argument and converts it to a value the bson encoder
+// and the translators understand. Slices become []interface{} (used by In/NotIn),
+// unsigned integers become int64 since bson has no unsigned types. Documents are kept as they are.
+func normalizeArgument(arg interface{}) (interface{}, error) {
+	switch v := arg.(type) {
+	case nil:
+		return nil, nil
+	case string, bool, int, int32, int64, float64,
+		time.Time, primitive.ObjectID, primitive.DateTime, primitive.Decimal128, primitive.Binary, primitive.Regex,
+		[]byte:
+		return v, nil
+	case primitive.D, primitive.M, primitive.E:
+		// a bson.D is a slice, it must not be taken for a list of values
+		return v, nil
+	case int8:
+		return int32(v), nil
+	case int16:
+		return int32(v), nil
+	case float32:
+		return float64(v), nil
+	case uint8:
+		return int32(v), nil
+	case uint16:
+		return int32(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint:
+		return uintToInt64(uint64(v))
+	case uint64:
+		return uintToInt64(v)
+	}
+
+	value := reflect.ValueOf(arg)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, nil
+		}
+		return normalizeArgument(value.Elem().Interface())
+	}
+	if stringer, ok := arg.(fmt.Stringer); ok {
+		return stringer.String(), nil
+	}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		if value.Type().ConvertibleTo(bytesType) && value.Kind() == reflect.Slice {
+			return value.Convert(bytesType).Interface(), nil
+		}
+		items := make([]interface{}, value.Len())
+		for i := range items {
+			item, err := normalizeArgument(value.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			if _, nested := item.([]interface{}); nested {
+				return nil, fmt.Errorf("nested slice arguments are not supported")
+			}
+			items[i] = item
+		}
+		return items, nil
+	case reflect.Struct, reflect.Map:
+		// embedded documents are passed to the bson encoder as they are
+		return arg, nil
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Bool:
+		return value.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uintToInt64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	}
+	return nil, fmt.Errorf("unsupported argument type: %T", arg)
+}
+
+func uintToInt64(v uint64) (interface{}, error) {
+	if v > 1<<63-1 {
+		return nil, fmt.Errorf("unsigned value %d overflows int64", v)
+	}
+	return int64(v), nil
+}
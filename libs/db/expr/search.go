@@ -0,0 +1,225 @@
+package expr
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TextScoreField is the key the text search score is projected to and sorted by, see Score() in ParseFind.
+const TextScoreField = "score"
+
+// SearchHandler translates Search(?) and Search(field, ?) into a filter, field is empty for Search(?).
+// Without a handler Search builds a $text query on the text index of the collection: a collection has a
+// single text index and a query a single $text, so Search(field, ?), more than one Search and a Search in
+// the func of Any are rejected.
+// Deployments searching with another backend (e.g. Elasticsearch on the elastic_search.field_content field)
+// can compile their queries with WithSearchHandler and a handler that queries it and returns a filter on the
+// matched ids.
+type SearchHandler func(field string, text string) (bson.D, error)
+
+// WithSearchHandler translates the Search functions of the query with handler instead of $text.
+func WithSearchHandler(handler SearchHandler) CompileOption {
+	return func(o *CompileOptions) {
+		o.SearchHandler = handler
+	}
+}
+
+// textSearch is the default SearchHandler.
+func textSearch(field string, text string) (bson.D, error) {
+	return bson.D{{"$text", bson.D{{"$search", text}}}}, nil
+}
+
+// handledSearch is a Search function already translated by a SearchHandler, see resolveSearch.
+type handledSearch struct {
+	Filter bson.D
+	Span   Span
+}
+
+func (n handledSearch) Position() Span { return n.Span }
+func (handledSearch) node()            {}
+
+// resolveSearch translates the Search functions of a bound tree with handler, buildFilter then uses
+// their filters as they are.
+func resolveSearch(node Node, handler SearchHandler, originalExpr string) (Node, error) {
+	switch n := node.(type) {
+	case Analyzer:
+		left, err := resolveSearch(n.Left, handler, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		right, err := resolveSearch(n.Right, handler, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		n.Left, n.Right = left, right
+		return n, nil
+	case FuncAnalyzer:
+		if !isSearch(n) {
+			return n, nil
+		}
+		filter, err := searchFilter(n, handler, originalExpr)
+		if err != nil {
+			return nil, err
+		}
+		return handledSearch{Filter: filter, Span: n.Span}, nil
+	}
+	return node, nil
+}
+
+// isSearch reports whether node is a Search function.
+func isSearch(node interface{}) bool {
+	if _, ok := node.(handledSearch); ok {
+		return true
+	}
+	f, ok := node.(FuncAnalyzer)
+	return ok && strings.ToLower(f.Name) == "search"
+}
+
+// findSearch returns the first Search function of a tree.
+func findSearch(node interface{}) (interface{}, bool) {
+	if isSearch(node) {
+		return node, true
+	}
+	switch n := node.(type) {
+	case Analyzer:
+		if found, ok := findSearch(n.Left); ok {
+			return found, true
+		}
+		return findSearch(n.Right)
+	case FuncAnalyzer:
+		for _, arg := range n.Args {
+			if found, ok := findSearch(arg); ok {
+				return found, true
+			}
+		}
+	case LambdaAnalyzer:
+		return findSearch(n.Body)
+	}
+	return nil, false
+}
+
+// searchArgs returns the field (empty for Search(?)) and the text of a Search function.
+func searchArgs(f FuncAnalyzer, originalExpr string) (string, string, error) {
+	switch len(f.Args) {
+	case 1:
+		c, ok := f.Args[0].(ConstAnalyzer)
+		text, isString := c.Value.(string)
+		if !ok || !isString {
+			return "", "", newError(ErrInvalidArgument, spanOf(f.Args[0]), originalExpr, "function '%s' expects a string", f.Name)
+		}
+		return "", text, nil
+	case 2:
+		field, text, err := fieldAndString(f, originalExpr)
+		return field.Name, text, err
+	default:
+		return "", "", newError(ErrInvalidArgument, f.Span, originalExpr, "function '%s' expects a text and an optional field", f.Name)
+	}
+}
+
+// buildSearch translates Search to $text.
+func buildSearch(f FuncAnalyzer, originalExpr string) (bson.D, error) {
+	if len(f.Args) == 2 {
+		return nil, newError(ErrInvalidArgument, spanOf(f.Args[0]), originalExpr, "function '%s' searches the text index of the collection and takes no field", f.Name)
+	}
+	return searchFilter(f, textSearch, originalExpr)
+}
+
+// buildQuery is buildFilter for a whole expression, it rejects a second $text search.
+func buildQuery(node interface{}, originalExpr string) (bson.D, error) {
+	if searches := textSearches(node, nil); len(searches) > 1 {
+		return nil, newError(ErrInvalidOperand, searches[1].Span, originalExpr, "a query can only have one text search")
+	}
+	return buildFilter(node, originalExpr)
+}
+
+// textSearches appends the Search functions of a tree which are not translated by a SearchHandler.
+func textSearches(node interface{}, found []FuncAnalyzer) []FuncAnalyzer {
+	switch n := node.(type) {
+	case Analyzer:
+		found = textSearches(n.Left, found)
+		return textSearches(n.Right, found)
+	case FuncAnalyzer:
+		if isSearch(n) {
+			return append(found, n)
+		}
+		for _, arg := range n.Args {
+			found = textSearches(arg, found)
+		}
+	case LambdaAnalyzer:
+		return textSearches(n.Body, found)
+	}
+	return found
+}
+
+// searchFilter translates Search with handler.
+func searchFilter(f FuncAnalyzer, handler SearchHandler, originalExpr string) (bson.D, error) {
+	field, text, err := searchArgs(f, originalExpr)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, newError(ErrInvalidArgument, spanOf(f.Args[len(f.Args)-1]), originalExpr, "function '%s' expects a non empty text", f.Name)
+	}
+	filter, err := handler(field, text)
+	if err != nil {
+		e := newError(ErrInvalidArgument, f.Span, originalExpr, "%s: %v", f.Name, err)
+		e.Err = err
+		return nil, e
+	}
+	return filter, nil
+}
+
+// evalSearch approximates $text in memory: the field matches when it contains one of the words of the text,
+// ignoring case (there is no stemming). Search(?) has no field to look at and cannot be evaluated.
+func evalSearch(f FuncAnalyzer, doc interface{}, originalExpr string) (bool, error) {
+	field, text, err := searchArgs(f, originalExpr)
+	if err != nil {
+		return false, err
+	}
+	if field == "" {
+		return false, newError(ErrUnsupportedFunction, f.Span, originalExpr, "%s without a field cannot be evaluated in memory", f.Name)
+	}
+	words := strings.Fields(strings.ToLower(text))
+	values, _ := lookupField(doc, field)
+	return anyValue(values, func(v interface{}) bool {
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		s = strings.ToLower(s)
+		for _, word := range words {
+			if strings.Contains(s, word) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// isScore reports whether an item of an order by or select clause is Score().
+func isScore(item string) bool {
+	return strings.ToLower(strings.Join(strings.Fields(item), "")) == "score()"
+}
+
+// textScore returns the $meta expression of the text search score.
+func textScore() bson.D {
+	return bson.D{{"$meta", "textScore"}}
+}
+
+// hasTextSearch reports whether a filter contains a $text query, at the top level or in $and.
+func hasTextSearch(filter bson.D) bool {
+	for _, e := range filter {
+		switch e.Key {
+		case "$text":
+			return true
+		case "$and":
+			for _, item := range e.Value.(bson.A) {
+				if sub, ok := item.(bson.D); ok && hasTextSearch(sub) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,348 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SQLDialect selects the placeholder style and the functions of the generated SQL.
+type SQLDialect int
+
+const (
+	// SQLite uses ? placeholders.
+	SQLite SQLDialect = iota
+	// PostgreSQL uses $1, $2, ... placeholders.
+	PostgreSQL
+)
+
+// SQLWhere is a parameterized WHERE fragment, Args are bound to the placeholders of Clause in order.
+type SQLWhere struct {
+	Clause string
+	Args   []interface{}
+}
+
+// SQLTranslator translates expressions to a parameterized SQL WHERE fragment, values are never spliced
+// into the SQL text:
+//
+//	Size > ? && Contains(Name, ?)   ->   ("Size" > $1 AND "Name" LIKE $2 ESCAPE '\')
+//
+// The semantics follow the mongo translation: != and ! also match NULL, NotIn matches NULL.
+// Contains, StartsWith and EndsWith are case-sensitive (GLOB on SQLite, LIKE on PostgreSQL),
+// their I variants are not. Regex uses ~ on PostgreSQL and REGEXP on SQLite, which needs a regexp function
+// registered by the driver. Array functions (Any, All, Len) and Search have no SQL equivalent and are rejected.
+type SQLTranslator struct {
+	Dialect SQLDialect
+	// Column maps a field path to a column, by default every part of the path is quoted
+	// ("Owner"."Name") and _id becomes "id".
+	Column func(field string) string
+}
+
+func (t SQLTranslator) Translate(e *Expression) (interface{}, error) {
+	return t.Where(e)
+}
+
+// Where is Translate with the result typed as *SQLWhere.
+func (t SQLTranslator) Where(e *Expression) (*SQLWhere, error) {
+	b := &sqlBuilder{t: t, template: e.Template}
+	clause, err := b.cond(e.Root)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLWhere{Clause: clause, Args: b.args}, nil
+}
+
+// quoteColumn is the default SQLTranslator.Column.
+func quoteColumn(field string) string {
+	parts := strings.Split(field, ".")
+	for i, part := range parts {
+		if i == 0 && part == "_id" {
+			part = "id"
+		}
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// sqlOps maps the comparison operators to SQL.
+var sqlOps = map[string]string{">": ">", ">=": ">=", "<": "<", "<=": "<=", "==": "=", "!=": "<>"}
+
+type sqlBuilder struct {
+	t        SQLTranslator
+	template string
+	args     []interface{}
+}
+
+// arg adds a value to the arguments and returns its placeholder.
+func (b *sqlBuilder) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	if b.t.Dialect == PostgreSQL {
+		return "$" + strconv.Itoa(len(b.args))
+	}
+	return "?"
+}
+
+func (b *sqlBuilder) column(f FieldAnalyzer) string {
+	if b.t.Column != nil {
+		return b.t.Column(f.Name)
+	}
+	return quoteColumn(f.Name)
+}
+
+func (b *sqlBuilder) floatType() string {
+	if b.t.Dialect == PostgreSQL {
+		return "DOUBLE PRECISION"
+	}
+	return "REAL"
+}
+
+func (b *sqlBuilder) unsupported(node interface{}, format string, args ...interface{}) *Error {
+	return newError(ErrUnsupportedNode, spanOf(node), b.template, format, args...)
+}
+
+func (b *sqlBuilder) cond(node interface{}) (string, error) {
+	switch n := node.(type) {
+	case Analyzer:
+		switch n.Op {
+		case "!":
+			inner, err := b.cond(n.Left)
+			if err != nil {
+				return "", err
+			}
+			// a condition on NULL is unknown, its negation must match like in mongo
+			return "NOT COALESCE(" + inner + ", FALSE)", nil
+		case "&&", "||":
+			operands := collectOperands(n.Op, n, nil)
+			parts := make([]string, len(operands))
+			for i, operand := range operands {
+				part, err := b.cond(operand)
+				if err != nil {
+					return "", err
+				}
+				parts[i] = part
+			}
+			if n.Op == "&&" {
+				return "(" + strings.Join(parts, " AND ") + ")", nil
+			}
+			return "(" + strings.Join(parts, " OR ") + ")", nil
+		}
+		return b.compare(n)
+	case FuncAnalyzer:
+		return b.function(n)
+	case FieldAnalyzer:
+		// a bare field is a boolean flag
+		return b.column(n) + " = " + b.arg(true), nil
+	default:
+		return "", newError(ErrInvalidOperand, spanOf(node), b.template, "expression is not a condition")
+	}
+}
+
+func (b *sqlBuilder) compare(expr Analyzer) (string, error) {
+	op := expr.Op
+	if _, ok := sqlOps[op]; !ok {
+		return "", newError(ErrUnsupportedOperator, expr.Span, b.template, "unsupported operator: %s", op)
+	}
+	left, right := foldOperand(expr.Left), foldOperand(expr.Right)
+	if _, isField := right.(FieldAnalyzer); isField {
+		if _, isConst := left.(ConstAnalyzer); isConst || isNilAnalyzer(left) {
+			left, right = right, left
+			op = reversedOp[op]
+		}
+	}
+	l, err := b.operand(left)
+	if err != nil {
+		return "", err
+	}
+	if isNilAnalyzer(right) {
+		switch op {
+		case "==":
+			return l + " IS NULL", nil
+		case "!=":
+			return l + " IS NOT NULL", nil
+		default:
+			return "", newError(ErrInvalidOperand, expr.Span, b.template, "nil can only be compared with == or !=")
+		}
+	}
+	r, err := b.operand(right)
+	if err != nil {
+		return "", err
+	}
+	if _, isField := left.(FieldAnalyzer); isField && op == "!=" {
+		return "(" + l + " <> " + r + " OR " + l + " IS NULL)", nil
+	}
+	return l + " " + sqlOps[op] + " " + r, nil
+}
+
+// operand translates a value: a column, a placeholder, arithmetic or a scalar function.
+func (b *sqlBuilder) operand(node interface{}) (string, error) {
+	if isNilAnalyzer(node) {
+		return "NULL", nil
+	}
+	switch n := node.(type) {
+	case FieldAnalyzer:
+		return b.column(n), nil
+	case ConstAnalyzer:
+		if _, isList := n.Value.([]interface{}); isList {
+			return "", newError(ErrInvalidOperand, n.Span, b.template, "a list can only be used with In and NotIn")
+		}
+		return b.arg(n.Value), nil
+	case Analyzer:
+		if c, ok := foldConstant(n); ok {
+			return b.operand(c)
+		}
+		if _, ok := arithOps[n.Op]; !ok {
+			return b.cond(n)
+		}
+		left, err := b.operand(n.Left)
+		if err != nil {
+			return "", err
+		}
+		if n.Right == nil {
+			// parenthesized, -- would start a comment
+			return "(-" + left + ")", nil
+		}
+		right, err := b.operand(n.Right)
+		if err != nil {
+			return "", err
+		}
+		if n.Op == "/" {
+			// like $divide, the division of integers is not truncated
+			left = "CAST(" + left + " AS " + b.floatType() + ")"
+		}
+		return "(" + left + " " + n.Op + " " + right + ")", nil
+	case FuncAnalyzer:
+		return b.scalar(n)
+	default:
+		return "", newError(ErrInvalidOperand, spanOf(node), b.template, "unsupported operand")
+	}
+}
+
+// scalar translates the functions of aggFunctions.
+func (b *sqlBuilder) scalar(f FuncAnalyzer) (string, error) {
+	name := strings.ToLower(f.Name)
+	if _, ok := aggFunctions[name]; !ok || name == "len" {
+		return "", b.unsupported(f, "function %s cannot be translated to SQL", f.Name)
+	}
+	if len(f.Args) != 1 {
+		return "", newError(ErrInvalidArgument, f.Span, b.template, "function '%s' expects exactly one argument", f.Name)
+	}
+	arg, err := b.operand(f.Args[0])
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "lower", "upper":
+		return strings.ToUpper(name) + "(" + arg + ")", nil
+	}
+	if b.t.Dialect == PostgreSQL {
+		return "EXTRACT(" + strings.ToUpper(name) + " FROM " + arg + ")", nil
+	}
+	format := map[string]string{"year": "%Y", "month": "%m", "day": "%d"}[name]
+	return "CAST(strftime('" + format + "', " + arg + ") AS INTEGER)", nil
+}
+
+func (b *sqlBuilder) function(f FuncAnalyzer) (string, error) {
+	name := strings.ToLower(f.Name)
+	switch name {
+	case "isnull", "exists", "notexists":
+		// a column always exists, a missing value is NULL
+		field, err := singleField(f, b.template)
+		if err != nil {
+			return "", err
+		}
+		if name == "exists" {
+			return b.column(field) + " IS NOT NULL", nil
+		}
+		return b.column(field) + " IS NULL", nil
+	case "contains", "startswith", "endswith", "icontains", "istartswith", "iendswith":
+		field, text, err := fieldAndString(f, b.template)
+		if err != nil {
+			return "", err
+		}
+		if limit := maxPatternLength.Load(); limit > 0 && int64(len(text)) > limit {
+			return "", newError(ErrInvalidArgument, spanOf(f.Args[1]), b.template, "function '%s': pattern is longer than %d bytes", f.Name, limit)
+		}
+		insensitive := strings.HasPrefix(name, "i")
+		wildcard, escaped, op := "%", likeEscaper.Replace(text), "LIKE"
+		switch {
+		case b.t.Dialect == SQLite && !insensitive:
+			// LIKE ignores case on SQLite
+			wildcard, escaped, op = "*", globEscaper.Replace(text), "GLOB"
+		case b.t.Dialect == PostgreSQL && insensitive:
+			op = "ILIKE"
+		}
+		if !strings.HasSuffix(name, "startswith") {
+			escaped = wildcard + escaped
+		}
+		if !strings.HasSuffix(name, "endswith") {
+			escaped += wildcard
+		}
+		clause := b.column(field) + " " + op + " " + b.arg(escaped)
+		if op != "GLOB" {
+			clause += ` ESCAPE '\'`
+		}
+		return clause, nil
+	case "regex":
+		field, pattern, options, err := regexPattern(f, b.template)
+		if err != nil {
+			return "", err
+		}
+		if b.t.Dialect == PostgreSQL {
+			if strings.Trim(options, "i") != "" {
+				return "", newError(ErrInvalidArgument, spanOf(f.Args[2]), b.template, "PostgreSQL only supports the i flag")
+			}
+			op := "~"
+			if options == "i" {
+				op = "~*"
+			}
+			return b.column(field) + " " + op + " " + b.arg(pattern), nil
+		}
+		if options != "" {
+			pattern = "(?" + options + ")" + pattern
+		}
+		return b.column(field) + " REGEXP " + b.arg(pattern), nil
+	case "in", "notin":
+		field, values, err := inValues(f, b.template)
+		if err != nil {
+			return "", err
+		}
+		column := b.column(field)
+		placeholders := make([]string, 0, len(values))
+		withNil := false
+		for _, v := range values {
+			if v == nil {
+				withNil = true
+				continue
+			}
+			placeholders = append(placeholders, b.arg(v))
+		}
+		list := "(" + strings.Join(placeholders, ", ") + ")"
+		if name == "in" {
+			switch {
+			case len(placeholders) == 0 && withNil:
+				return column + " IS NULL", nil
+			case len(placeholders) == 0:
+				return "1 = 0", nil
+			case withNil:
+				return "(" + column + " IN " + list + " OR " + column + " IS NULL)", nil
+			}
+			return column + " IN " + list, nil
+		}
+		switch {
+		case len(placeholders) == 0 && withNil:
+			return column + " IS NOT NULL", nil
+		case len(placeholders) == 0:
+			return "1 = 1", nil
+		case withNil:
+			return column + " NOT IN " + list, nil
+		}
+		return "(" + column + " NOT IN " + list + " OR " + column + " IS NULL)", nil
+	}
+	return "", newError(ErrUnsupportedFunction, f.Span, b.template, "function %s cannot be translated to SQL", f.Name)
+}
+
+var (
+	// likeEscaper escapes the wildcards of a LIKE pattern, the escape character is \.
+	likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	// globEscaper escapes the wildcards of a GLOB pattern, which has no escape character.
+	globEscaper = strings.NewReplacer(`*`, `[*]`, `?`, `[?]`, `[`, `[[]`)
+)
@@ -0,0 +1,109 @@
+package expr
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Node is a node of the analyzed tree. The tree is made of:
+//
+//	Analyzer        a binary (Left Op Right) or unary (Op Left) operation
+//	ConstAnalyzer   a literal or a bound argument
+//	FieldAnalyzer   a field path
+//	FuncAnalyzer    a function call, a bare nil is FuncAnalyzer{Name: "IsNull"} without arguments
+//	ParamAnalyzer   a placeholder which is not bound yet
+//	LambdaAnalyzer  a func literal, the sub-filter of Any
+//
+// Analyzer.Left, Analyzer.Right, FuncAnalyzer.Args and LambdaAnalyzer.Body are typed as Node, so a translator
+// only switches over the types above. Right is nil, meaning absent, for the unary operators ! and -, and only
+// for them: x == nil has the IsNull node as its Right.
+type Node interface {
+	// Position returns the span of the node in the expression.
+	Position() Span
+	node()
+}
+
+func (n Analyzer) Position() Span       { return n.Span }
+func (n ConstAnalyzer) Position() Span  { return n.Span }
+func (n FieldAnalyzer) Position() Span  { return n.Span }
+func (n FuncAnalyzer) Position() Span   { return n.Span }
+func (n ParamAnalyzer) Position() Span  { return n.Span }
+func (n LambdaAnalyzer) Position() Span { return n.Span }
+
+func (Analyzer) node()       {}
+func (ConstAnalyzer) node()  {}
+func (FieldAnalyzer) node()  {}
+func (FuncAnalyzer) node()   {}
+func (ParamAnalyzer) node()  {}
+func (LambdaAnalyzer) node() {}
+
+// Expression is an analyzed expression with its arguments bound, the input of a Translator.
+// Template is the original expression, errors point into it.
+type Expression struct {
+	Template string
+	Root     Node
+}
+
+// Translator converts an expression into the query language of a backend.
+type Translator interface {
+	Translate(e *Expression) (interface{}, error)
+}
+
+// Parse analyzes an expression and binds its ? placeholders, the result can be handed to any Translator.
+func Parse(template string, args ...interface{}) (*Expression, error) {
+	q, err := Compile(template)
+	if err != nil {
+		return nil, err
+	}
+	return q.Expression(args...)
+}
+
+// Expression binds the arguments to the ? placeholders of the query and returns the bound tree.
+func (q *Query) Expression(args ...interface{}) (*Expression, error) {
+	tree, err := q.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return newExpression(q.template, tree)
+}
+
+// ExpressionNamed binds the @name parameters of the query and returns the bound tree.
+func (q *Query) ExpressionNamed(params map[string]interface{}) (*Expression, error) {
+	tree, err := q.bindNamed(params)
+	if err != nil {
+		return nil, err
+	}
+	return newExpression(q.template, tree)
+}
+
+func newExpression(template string, tree interface{}) (*Expression, error) {
+	root, ok := tree.(Node)
+	if !ok {
+		return nil, newError(ErrInvalidOperand, Span{}, template, "expression is not a condition")
+	}
+	return &Expression{Template: template, Root: root}, nil
+}
+
+// Translate parses an expression, binds its arguments and translates it with t.
+func Translate(t Translator, template string, args ...interface{}) (interface{}, error) {
+	e, err := Parse(template, args...)
+	if err != nil {
+		return nil, err
+	}
+	return t.Translate(e)
+}
+
+// MongoTranslator translates expressions to mongo filters (bson.D), as GetMongoQueryFromString does.
+type MongoTranslator struct {
+	// SearchHandler translates Search, $text when nil (see WithSearchHandler).
+	SearchHandler SearchHandler
+}
+
+func (t MongoTranslator) Translate(e *Expression) (interface{}, error) {
+	return t.Filter(e)
+}
+
+// Filter is Translate with the result typed as bson.D.
+func (t MongoTranslator) Filter(e *Expression) (bson.D, error) {
+	q := &Query{template: e.Template, search: t.SearchHandler}
+	return q.filter(e.Root)
+}
@@ -0,0 +1,221 @@
+package expr
+
+import (
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// updateFunc describes a function of an update expression: its update operator and
+// the kind of its second argument.
+type updateFunc struct {
+	op    string
+	value string // "" (no value), "any", "number", "list" ($each), "field" (Rename), "-1" or "1" (Pop)
+}
+
+// updateFunctions are the functions of an update expression, the first argument is always the field.
+var updateFunctions = map[string]updateFunc{
+	"set":         {"$set", "any"},
+	"setoninsert": {"$setOnInsert", "any"},
+	"unset":       {"$unset", ""},
+	"inc":         {"$inc", "number"},
+	"mul":         {"$mul", "number"},
+	"min":         {"$min", "any"},
+	"max":         {"$max", "any"},
+	"rename":      {"$rename", "field"},
+	"currentdate": {"$currentDate", ""},
+	"push":        {"$push", "any"},
+	"pushall":     {"$push", "list"},
+	"addtoset":    {"$addToSet", "any"},
+	"addalltoset": {"$addToSet", "list"},
+	"pull":        {"$pull", "any"},
+	"pullall":     {"$pullAll", "list"},
+	"pop":         {"$pop", "1"},
+	"popfirst":    {"$pop", "-1"},
+}
+
+// ParseUpdate compiles an update expression, a comma separated list of update functions:
+//
+//	Set(Name, ?), Inc(DownloadCount, 1), Push(Tags, ?), Unset(TempPath), CurrentDate(ModifiedOn)
+//
+// becomes {$set: {Name: ...}, $inc: {DownloadCount: 1}, $push: {Tags: ...}, $unset: {TempPath: ""}, $currentDate: {ModifiedOn: true}}.
+// The functions are Set, SetOnInsert, Unset, Inc, Mul, Min, Max, Rename, CurrentDate, Push, PushAll,
+// AddToSet, AddAllToSet, Pull, PullAll, Pop and PopFirst (PushAll, AddAllToSet and PullAll take a list).
+// A path can only be updated once: updating a path twice, or a path and one of its sub-paths, is rejected.
+// ? placeholders are bound to args in the order they appear.
+func ParseUpdate(text string, args ...interface{}) (bson.D, error) {
+	return parseUpdate(text, nil, args)
+}
+
+// ParseUpdateFor is like ParseUpdate, field names are mapped through the tags of the model T (see CompileFor)
+// and fields which do not exist in T are rejected.
+func ParseUpdateFor[T any](text string, args ...interface{}) (bson.D, error) {
+	t, err := modelType[T]()
+	if err != nil {
+		return nil, err
+	}
+	return parseUpdate(text, t, args)
+}
+
+func parseUpdate(text string, model reflect.Type, args []interface{}) (bson.D, error) {
+	items, err := splitItems(text)
+	if err != nil {
+		return nil, err
+	}
+	trees := make([]Node, len(items))
+	counts := make([]int, len(items))
+	total := 0
+	for i, item := range items {
+		if item.name != "" {
+			return nil, newError(ErrSyntax, itemSpan(item.text, item.offset), text, "expected an update function")
+		}
+		tree, r, err := parseTemplate(item.text)
+		if err != nil {
+			return nil, shiftError(err, item.offset, text)
+		}
+		if len(r.names) > 0 {
+			return nil, newError(ErrParameter, itemSpan(item.text, item.offset), text, "named parameters (@%s) are not supported in updates", r.names[0])
+		}
+		if model != nil {
+			if tree, err = resolveFields(tree, model, item.text); err != nil {
+				return nil, shiftError(err, item.offset, text)
+			}
+		}
+		trees[i], counts[i] = tree, r.count
+		total += r.count
+	}
+	if total != len(args) {
+		return nil, newError(ErrParameter, Span{}, text, "number of placeholders (%d) does not match number of arguments (%d)", total, len(args))
+	}
+	u := &update{}
+	next := 0
+	for i, item := range items {
+		bound, err := bindChecked(trees[i], args[next:next+counts[i]], nil, item.text)
+		next += counts[i]
+		if err == nil {
+			err = u.add(bound, item.text)
+		}
+		if err != nil {
+			return nil, shiftError(err, item.offset, text)
+		}
+	}
+	return u.doc, nil
+}
+
+// update collects the operators of an update expression.
+type update struct {
+	doc   bson.D
+	paths []string
+}
+
+func (u *update) add(node interface{}, originalExpr string) error {
+	f, ok := node.(FuncAnalyzer)
+	if !ok {
+		return newError(ErrInvalidOperand, spanOf(node), originalExpr, "expected an update function like Set(Field, value)")
+	}
+	spec, ok := updateFunctions[strings.ToLower(f.Name)]
+	if !ok {
+		return newError(ErrUnsupportedFunction, f.Span, originalExpr, "unsupported update function: %s", f.Name)
+	}
+	expected := 2
+	if spec.value == "" || spec.value == "1" || spec.value == "-1" {
+		expected = 1
+	}
+	if len(f.Args) != expected {
+		return newError(ErrInvalidArgument, f.Span, originalExpr, "function '%s' expects %d argument(s)", f.Name, expected)
+	}
+	field, ok := f.Args[0].(FieldAnalyzer)
+	if !ok {
+		return newError(ErrInvalidArgument, spanOf(f.Args[0]), originalExpr, "function '%s' expects a field as first argument", f.Name)
+	}
+	if err := u.claim(field, originalExpr); err != nil {
+		return err
+	}
+	var value interface{}
+	switch spec.value {
+	case "":
+		value = true
+		if spec.op == "$unset" {
+			value = ""
+		}
+	case "1":
+		value = 1
+	case "-1":
+		value = -1
+	case "field":
+		target, ok := f.Args[1].(FieldAnalyzer)
+		if !ok {
+			return newError(ErrInvalidArgument, spanOf(f.Args[1]), originalExpr, "function '%s' expects a field as second argument", f.Name)
+		}
+		if err := u.claim(target, originalExpr); err != nil {
+			return err
+		}
+		value = target.Name
+	default:
+		v, err := updateValue(f, spec.value, originalExpr)
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+	u.set(spec.op, field.Name, value)
+	return nil
+}
+
+// claim registers a path updated by the expression, it fails when the path or one of its parents
+// or sub-paths is already updated since the server rejects such updates.
+func (u *update) claim(field FieldAnalyzer, originalExpr string) error {
+	for _, path := range u.paths {
+		if path == field.Name || strings.HasPrefix(field.Name, path+".") || strings.HasPrefix(path, field.Name+".") {
+			return newError(ErrInvalidArgument, field.Span, originalExpr, "update of %s conflicts with the update of %s", field.Name, path)
+		}
+	}
+	u.paths = append(u.paths, field.Name)
+	return nil
+}
+
+// set adds field: value to the document of an operator, operators keep the order of their first use.
+func (u *update) set(op string, field string, value interface{}) {
+	for i, e := range u.doc {
+		if e.Key == op {
+			u.doc[i].Value = append(e.Value.(bson.D), bson.E{Key: field, Value: value})
+			return
+		}
+	}
+	u.doc = append(u.doc, bson.E{Key: op, Value: bson.D{{field, value}}})
+}
+
+// updateValue returns the constant second argument of an update function, checked against its kind.
+func updateValue(f FuncAnalyzer, kind string, originalExpr string) (interface{}, error) {
+	arg := foldOperand(f.Args[1])
+	if isNilAnalyzer(arg) {
+		if kind != "any" {
+			return nil, newError(ErrInvalidArgument, spanOf(arg), originalExpr, "function '%s' does not accept nil", f.Name)
+		}
+		return nil, nil
+	}
+	c, ok := arg.(ConstAnalyzer)
+	if !ok {
+		return nil, newError(ErrInvalidArgument, spanOf(arg), originalExpr, "function '%s' expects a constant value", f.Name)
+	}
+	switch kind {
+	case "number":
+		switch c.Value.(type) {
+		case int, int32, int64, float64, primitive.Decimal128:
+		default:
+			return nil, newError(ErrInvalidArgument, c.Span, originalExpr, "function '%s' expects a number, got %T", f.Name, c.Value)
+		}
+	case "list":
+		items, ok := c.Value.([]interface{})
+		if !ok {
+			return nil, newError(ErrInvalidArgument, c.Span, originalExpr, "function '%s' expects a list, got %T", f.Name, c.Value)
+		}
+		if strings.ToLower(f.Name) == "pullall" {
+			return bson.A(items), nil
+		}
+		return bson.D{{"$each", bson.A(items)}}, nil
+	}
+	return c.Value, nil
+}
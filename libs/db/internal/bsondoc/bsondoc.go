@@ -0,0 +1,71 @@
+// Package bsondoc has the helpers on decoded documents and index specifications shared by dbcontext and its
+// in-memory fake, so that the fake reads documents the way the production code does.
+package bsondoc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/unvs/libs/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetPath returns the value at a dotted path of a document.
+func GetPath(doc bson.D, path string) (interface{}, bool) {
+	key, rest, nested := strings.Cut(path, ".")
+	for _, e := range doc {
+		if e.Key != key {
+			continue
+		}
+		if !nested {
+			return e.Value, true
+		}
+		if child, ok := e.Value.(bson.D); ok {
+			return GetPath(child, rest)
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// Number returns the value of a numeric document value.
+func Number(value interface{}) (float64, bool) {
+	n, _, ok := NumberKind(value)
+	return n, ok
+}
+
+// NumberKind is Number also telling whether the value is an integer.
+func NumberKind(value interface{}) (n float64, isInt bool, ok bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true, true
+	case int32:
+		return float64(v), true, true
+	case int64:
+		return float64(v), true, true
+	case float64:
+		return v, false, true
+	}
+	return 0, false, false
+}
+
+// SameKeys reports whether two index key specifications are equal, in order.
+func SameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+// SameOptions reports whether two indexes have the same options, expiries are compared in seconds.
+func SameOptions(a, b models.Index) bool {
+	if a.Unique != b.Unique || a.Sparse != b.Sparse || a.TTL != b.TTL {
+		return false
+	}
+	return !a.TTL || a.ExpireAfter/time.Second == b.ExpireAfter/time.Second
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Registry is the bson registry of the default codecs where structs are encoded and decoded through their
+// metadata, so models need no bson tags. Keys of a document which are not fields of the model are skipped.
+// A zero _id is not stored, so that the driver generates an ObjectID for it.
+// Set it on the client or database (options.Database().SetRegistry) the models are stored with.
+var Registry = newRegistry()
+
+func newRegistry() *bsoncodec.Registry {
+	r := bson.NewRegistry()
+	// types with their own codec (time.Time, primitive.*, bson.Marshaler, ...) are looked up before kinds
+	r.RegisterKindEncoder(reflect.Struct, structCodec{})
+	r.RegisterKindDecoder(reflect.Struct, structCodec{})
+	return r
+}
+
+// Marshal encodes a value with Registry.
+func Marshal(value interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	vw, err := bsonrw.NewBSONValueWriter(buf)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := bson.NewEncoder(vw)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.SetRegistry(Registry); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a document into the value pointed to by value with Registry.
+func Unmarshal(data []byte, value interface{}) error {
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(data))
+	if err != nil {
+		return err
+	}
+	if err := dec.SetRegistry(Registry); err != nil {
+		return err
+	}
+	return dec.Decode(value)
+}
+
+type structCodec struct{}
+
+func (structCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if !val.IsValid() || val.Kind() != reflect.Struct {
+		return bsoncodec.ValueEncoderError{Name: "models.EncodeValue", Kinds: []reflect.Kind{reflect.Struct}, Received: val}
+	}
+	m, err := Of(val.Type())
+	if err != nil {
+		return err
+	}
+	dw, err := vw.WriteDocument()
+	if err != nil {
+		return err
+	}
+	for _, f := range m.Fields {
+		fv := val.FieldByIndex(f.Index)
+		if f.OmitEmpty && isEmpty(fv) || f == m.ID && fv.IsZero() {
+			continue
+		}
+		evw, err := dw.WriteDocumentElement(f.Key)
+		if err != nil {
+			return err
+		}
+		if fv.Kind() == reflect.Interface {
+			if fv.IsNil() {
+				if err := evw.WriteNull(); err != nil {
+					return err
+				}
+				continue
+			}
+			fv = fv.Elem()
+		}
+		enc, err := ec.LookupEncoder(fv.Type())
+		if err != nil {
+			return fmt.Errorf("encoding %s.%s: %w", m.Type, f.Name, err)
+		}
+		if err := enc.EncodeValue(ec, evw, fv); err != nil {
+			return fmt.Errorf("encoding %s.%s: %w", m.Type, f.Name, err)
+		}
+	}
+	return dw.WriteDocumentEnd()
+}
+
+func (structCodec) DecodeValue(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Kind() != reflect.Struct {
+		return bsoncodec.ValueDecoderError{Name: "models.DecodeValue", Kinds: []reflect.Kind{reflect.Struct}, Received: val}
+	}
+	switch vr.Type() {
+	case bsontype.Type(0), bsontype.EmbeddedDocument:
+	case bsontype.Null:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadNull()
+	case bsontype.Undefined:
+		val.Set(reflect.Zero(val.Type()))
+		return vr.ReadUndefined()
+	default:
+		return fmt.Errorf("cannot decode %v into %s", vr.Type(), val.Type())
+	}
+	m, err := Of(val.Type())
+	if err != nil {
+		return err
+	}
+	dr, err := vr.ReadDocument()
+	if err != nil {
+		return err
+	}
+	for {
+		key, evr, err := dr.ReadElement()
+		if errors.Is(err, bsonrw.ErrEOD) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f, ok := m.byKey[key]
+		if !ok {
+			if err := evr.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		fv := val.FieldByIndex(f.Index)
+		dec, err := dc.LookupDecoder(fv.Type())
+		if err != nil {
+			return fmt.Errorf("decoding %s.%s: %w", m.Type, f.Name, err)
+		}
+		if err := dec.DecodeValue(dc, evr, fv); err != nil {
+			return fmt.Errorf("decoding %s.%s: %w", m.Type, f.Name, err)
+		}
+	}
+}
+
+// isEmpty reports whether an omitempty field is left out: zero values, and empty slices, maps and strings.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
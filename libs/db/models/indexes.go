@@ -0,0 +1,221 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Index is an index of a collection. Models declare single field indexes with index tags:
+//
+//	Username  string    `field:"username" index:"unique"`
+//	CreatedOn time.Time `field:"created_on" index:"desc"`
+//	ExpiresOn time.Time `field:"expires_on" index:"ttl=0s"`
+//	Body      string    `field:"body" index:"text,name=body_search"`
+//
+// The options of an index tag are asc (the default), desc, text, unique, sparse, ttl=<duration> and
+// name=<name>. Compound indexes are returned by an Indexes method, see Indexer.
+type Index struct {
+	Name        string        // the mongo default name (username_1, owner_1_created_on_-1) when empty
+	Keys        bson.D        // field -> 1, -1 or "text", the fields are Go names or document keys
+	Unique      bool          // documents may not share the values of the keys
+	Sparse      bool          // documents without the keys are not indexed
+	TTL         bool          // a TTL index on a single date field
+	ExpireAfter time.Duration // the documents of a TTL index expire this long after the date of the key
+}
+
+// Indexer is implemented by models declaring indexes which tags cannot express, like compound indexes:
+//
+//	func (Files) Indexes() []models.Index {
+//		return []models.Index{{Keys: bson.D{{"Owner", 1}, {"CreatedOn", -1}}}}
+//	}
+type Indexer interface {
+	Indexes() []Index
+}
+
+// Indexes returns the indexes declared by the model, with the keys mapped to document keys and the names set.
+// The _id index of every collection is not part of them.
+func (m *Metadata) Indexes() ([]Index, error) {
+	m.indexOnce.Do(func() {
+		m.indexes, m.indexErr = m.declaredIndexes()
+	})
+	return m.indexes, m.indexErr
+}
+
+func (m *Metadata) declaredIndexes() ([]Index, error) {
+	var indexes []Index
+	for _, f := range m.Fields {
+		if f.IndexHint == "" {
+			continue
+		}
+		idx, err := parseIndexTag(f.Key, f.IndexHint)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: field %s: %w", m.Type, f.Name, err)
+		}
+		indexes = append(indexes, idx)
+	}
+	if indexer, ok := reflect.New(m.Type).Interface().(Indexer); ok {
+		for _, idx := range indexer.Indexes() {
+			resolved, err := m.resolveIndex(idx)
+			if err != nil {
+				return nil, fmt.Errorf("model %s: %w", m.Type, err)
+			}
+			indexes = append(indexes, resolved)
+		}
+	}
+	names := map[string]bool{}
+	for _, idx := range indexes {
+		if names[idx.Name] {
+			return nil, fmt.Errorf("model %s: index %s is declared twice", m.Type, idx.Name)
+		}
+		names[idx.Name] = true
+	}
+	return indexes, nil
+}
+
+func parseIndexTag(key string, tag string) (Index, error) {
+	idx := Index{Keys: bson.D{{Key: key, Value: int32(1)}}}
+	for _, option := range strings.Split(tag, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch name {
+		case "", "asc":
+		case "desc":
+			idx.Keys[0].Value = int32(-1)
+		case "text":
+			idx.Keys[0].Value = "text"
+		case "unique":
+			idx.Unique = true
+		case "sparse":
+			idx.Sparse = true
+		case "ttl":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return Index{}, fmt.Errorf("index ttl: %w", err)
+			}
+			idx.TTL, idx.ExpireAfter = true, d
+		case "name":
+			idx.Name = value
+		default:
+			return Index{}, fmt.Errorf("unknown index option %q", option)
+		}
+	}
+	err := checkIndex(&idx)
+	return idx, err
+}
+
+// resolveIndex maps the keys of an index returned by an Indexes method to document keys.
+func (m *Metadata) resolveIndex(idx Index) (Index, error) {
+	if len(idx.Keys) == 0 {
+		return Index{}, fmt.Errorf("index %q has no keys", idx.Name)
+	}
+	keys := make(bson.D, len(idx.Keys))
+	for i, e := range idx.Keys {
+		key, err := m.KeyOf(e.Key)
+		if err != nil {
+			return Index{}, err
+		}
+		value, err := indexOrder(e.Value)
+		if err != nil {
+			return Index{}, fmt.Errorf("index key %s: %w", e.Key, err)
+		}
+		keys[i] = bson.E{Key: key, Value: value}
+	}
+	idx.Keys = keys
+	err := checkIndex(&idx)
+	return idx, err
+}
+
+// indexOrder normalizes the value of an index key: 1 and -1 become int32, strings ("text", "2dsphere") are kept.
+func indexOrder(value interface{}) (interface{}, error) {
+	var order float64
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int:
+		order = float64(v)
+	case int32:
+		order = float64(v)
+	case int64:
+		order = float64(v)
+	case float64:
+		order = v
+	default:
+		return nil, fmt.Errorf("unsupported index order %v (%T)", value, value)
+	}
+	switch {
+	case order > 0:
+		return int32(1), nil
+	case order < 0:
+		return int32(-1), nil
+	}
+	return nil, fmt.Errorf("index order must be 1 or -1, got 0")
+}
+
+// checkIndex checks the options of an index and sets its default name.
+func checkIndex(idx *Index) error {
+	if idx.TTL && len(idx.Keys) != 1 {
+		return fmt.Errorf("a TTL index has a single key, got %d", len(idx.Keys))
+	}
+	if idx.TTL && idx.ExpireAfter < 0 {
+		return fmt.Errorf("negative index ttl %s", idx.ExpireAfter)
+	}
+	if idx.Name == "" {
+		idx.Name = DefaultIndexName(idx.Keys)
+	}
+	return nil
+}
+
+// DefaultIndexName returns the name mongo gives to an index of the keys, e.g. owner_1_created_on_-1.
+func DefaultIndexName(keys bson.D) string {
+	parts := make([]string, 0, 2*len(keys))
+	for _, e := range keys {
+		parts = append(parts, e.Key, fmt.Sprint(e.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// KeyOf maps a dotted path of Go names or document keys to the document path.
+func (m *Metadata) KeyOf(path string) (string, error) {
+	keys := []string{}
+	current := m
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		if current == nil {
+			// an element of a map or of an interface, the rest of the path cannot be checked
+			return strings.Join(append(keys, parts[i:]...), "."), nil
+		}
+		f, ok := current.FieldByName(part)
+		if !ok {
+			f, ok = current.FieldByKey(part)
+		}
+		if !ok && part == "_id" {
+			f, ok = current.FieldByName("ID")
+		}
+		if !ok {
+			return "", fmt.Errorf("unknown field %s of %s", path, m.Type)
+		}
+		keys = append(keys, f.Key)
+		t := f.Type
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		current = nil
+		switch t.Kind() {
+		case reflect.Struct:
+			nested, err := Of(t)
+			if err != nil {
+				return "", err
+			}
+			current = nested
+		case reflect.Map, reflect.Interface:
+		default:
+			if i < len(parts)-1 {
+				return "", fmt.Errorf("unknown field %s of %s", path, m.Type)
+			}
+		}
+	}
+	return strings.Join(keys, "."), nil
+}
@@ -0,0 +1,234 @@
+// Package models reads the metadata of model structs from their struct tags:
+//
+//	type Accounts struct {
+//		tableName struct{} `table:"accounts"`
+//		ID        int      `field:"id"`
+//		Username  string   `field:"username" index:"unique"`
+//		Email     string   `field:"email,omitempty"`
+//	}
+//
+// The table tag of any field (usually an unexported marker) names the collection. The field tag gives the
+// document key of a field and its options, the bson tag is read when there is no field tag, else the key
+// is the Go name. The key id is stored as _id. Fields of embedded structs are inlined, declared fields
+// shadow them. The metadata of a type is parsed once and cached, Registry encodes and decodes models with it.
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metadata describes a model struct.
+type Metadata struct {
+	Type       reflect.Type
+	Collection string   // from the table tag, "" when the model has none
+	Fields     []*Field // declared fields first, then the ones of embedded structs
+	ID         *Field   // the field stored as _id, nil when there is none
+
+	byName map[string]*Field
+	byKey  map[string]*Field
+
+	indexOnce sync.Once
+	indexes   []Index
+	indexErr  error
+}
+
+// Field is a field of a model.
+type Field struct {
+	Name      string       // the Go name
+	Key       string       // the document key
+	Type      reflect.Type // the Go type, pointers kept
+	Index     []int        // see reflect.Value.FieldByIndex, through embedded structs
+	OmitEmpty bool         // the field is not stored when it is empty
+	IndexHint string       // the index tag, e.g. "unique"
+}
+
+// FieldByName returns a field by its Go name.
+func (m *Metadata) FieldByName(name string) (*Field, bool) {
+	f, ok := m.byName[name]
+	return f, ok
+}
+
+// FieldByKey returns a field by its document key.
+func (m *Metadata) FieldByKey(key string) (*Field, bool) {
+	f, ok := m.byKey[key]
+	return f, ok
+}
+
+var (
+	metadataCache sync.Map // reflect.Type -> *Metadata
+
+	registeredMu sync.Mutex
+	registered   = map[reflect.Type]*Metadata{}
+)
+
+// Of returns the metadata of a struct type, pointers are removed.
+func Of(t reflect.Type) (*Metadata, error) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("model must be a struct, got %v", t)
+	}
+	if m, ok := metadataCache.Load(t); ok {
+		return m.(*Metadata), nil
+	}
+	m, err := parse(t)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := metadataCache.LoadOrStore(t, m)
+	return actual.(*Metadata), nil
+}
+
+// For returns the metadata of the model T.
+func For[T any]() (*Metadata, error) {
+	return Of(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// Register parses the metadata of models given as values or pointers, a model without table tag is an error.
+// Registered models are listed by Registered.
+func Register(models ...interface{}) error {
+	for _, model := range models {
+		m, err := Of(reflect.TypeOf(model))
+		if err != nil {
+			return err
+		}
+		if m.Collection == "" {
+			return fmt.Errorf("model %s has no table tag", m.Type)
+		}
+		registeredMu.Lock()
+		registered[m.Type] = m
+		registeredMu.Unlock()
+	}
+	return nil
+}
+
+// RegisterAll registers the types of the fields of a struct listing models, like models.Model.
+func RegisterAll(list interface{}) error {
+	t := reflect.TypeOf(list)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("model list must be a struct, got %v", t)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if err := Register(reflect.Zero(t.Field(i).Type).Interface()); err != nil {
+			return fmt.Errorf("%s.%s: %w", t, t.Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+// Registered returns the registered models ordered by collection.
+func Registered() []*Metadata {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	result := make([]*Metadata, 0, len(registered))
+	for _, m := range registered {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Collection != result[j].Collection {
+			return result[i].Collection < result[j].Collection
+		}
+		return result[i].Type.String() < result[j].Type.String()
+	})
+	return result
+}
+
+func parse(t reflect.Type) (*Metadata, error) {
+	m := &Metadata{Type: t, byName: map[string]*Field{}, byKey: map[string]*Field{}}
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if m.Collection == "" {
+			m.Collection = sf.Tag.Get("table")
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, sf)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		f, err := parseField(sf)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: %w", t, err)
+		}
+		if f == nil {
+			continue
+		}
+		if other, ok := m.byKey[f.Key]; ok {
+			return nil, fmt.Errorf("model %s: fields %s and %s are both stored as %s", t, other.Name, f.Name, f.Key)
+		}
+		m.add(f)
+	}
+	// declared fields shadow the fields of embedded structs
+	for _, sf := range embedded {
+		inner, err := Of(sf.Type)
+		if err != nil {
+			return nil, err
+		}
+		if m.Collection == "" {
+			m.Collection = inner.Collection
+		}
+		for _, f := range inner.Fields {
+			if _, ok := m.byName[f.Name]; ok {
+				continue
+			}
+			if _, ok := m.byKey[f.Key]; ok {
+				continue
+			}
+			promoted := *f
+			promoted.Index = append([]int{sf.Index[0]}, f.Index...)
+			m.add(&promoted)
+		}
+	}
+	return m, nil
+}
+
+func (m *Metadata) add(f *Field) {
+	m.Fields = append(m.Fields, f)
+	m.byName[f.Name] = f
+	m.byKey[f.Key] = f
+	if f.Key == "_id" {
+		m.ID = f
+	}
+}
+
+// parseField reads the tags of a struct field, nil is returned for a field tagged "-".
+func parseField(sf reflect.StructField) (*Field, error) {
+	tag, strict := sf.Tag.Lookup("field")
+	if !strict {
+		tag = sf.Tag.Get("bson")
+	}
+	key, options, _ := strings.Cut(tag, ",")
+	if key == "-" && options == "" {
+		return nil, nil
+	}
+	if key == "" {
+		key = sf.Name
+	}
+	if key == "id" {
+		key = "_id"
+	}
+	f := &Field{Name: sf.Name, Key: key, Type: sf.Type, Index: sf.Index, IndexHint: sf.Tag.Get("index")}
+	for _, option := range strings.Split(options, ",") {
+		switch option {
+		case "":
+		case "omitempty":
+			f.OmitEmpty = true
+		default:
+			// the other options of bson tags (minsize, truncate, ...) are ignored
+			if strict {
+				return nil, fmt.Errorf("field %s: unknown option %q", sf.Name, option)
+			}
+		}
+	}
+	return f, nil
+}
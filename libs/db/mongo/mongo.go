@@ -101,6 +101,10 @@ func GetAllTags(t reflect.Type) (map[string]string, error) {
 				return nil, fmt.Errorf("error getting tags from embedded struct %s: %w", field.Name, err)
 			}
 			for k, v := range embeddedTags {
+				// fields of the outer struct shadow the promoted ones
+				if outer, ok := t.FieldByName(k); ok && len(outer.Index) == 1 {
+					continue
+				}
 				tags[k] = v
 			}
 		}
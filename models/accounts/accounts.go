@@ -3,5 +3,5 @@ package accounts
 type Accounts struct {
 	tableName struct{} `table:"accounts"`
 	ID        int      `field:"id"`
-	Username  string   `field:"username"`
+	Username  string   `field:"username" index:"unique"`
 }
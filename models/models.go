@@ -1,9 +1,15 @@
 package models
 
 import (
+	dbmodels "github.com/unvs/libs/db/models"
 	"github.com/unvs/models/accounts"
 )
 
 type Model struct {
-	Account accounts.Accounts `table:"accounts`
+	Account accounts.Accounts `table:"accounts"`
+}
+
+// Register adds the models listed by Model to the models registry of libs/db/models.
+func Register() error {
+	return dbmodels.RegisterAll(Model{})
 }
@@ -0,0 +1,81 @@
+// compares parsing every call (GetMongoQueryFromString) with a compiled query (Compile + Bind),
+// run with: go run ./test/bench_expr
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+)
+
+const filter = "TenantId == ? && (Owner == ? || SharedWith == ?) && In(Ext, ?) && CreatedOn >= ? && !Deleted && Contains(Name, ?)"
+
+func args(i int) []interface{} {
+	return []interface{}{
+		fmt.Sprintf("tenant-%d", i%10),
+		"user", "user",
+		[]string{"doc", "pdf"},
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		"report",
+	}
+}
+
+func main() {
+	// compiled and parsed queries must produce the same filter, also when bound concurrently
+	q := expr.MustCompile(filter)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			expected, err := expr.GetMongoQueryFromString(filter, args(i)...)
+			if err != nil {
+				panic(err)
+			}
+			actual, err := q.Bind(args(i)...)
+			if err != nil {
+				panic(err)
+			}
+			if !reflect.DeepEqual(expected, actual) {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if failed > 0 {
+		fmt.Printf("FAIL %d compiled queries differ from the parsed ones\n", failed)
+		os.Exit(1)
+	}
+
+	parsed := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := expr.GetMongoQueryFromString(filter, args(i)...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	compiled := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			q, err := expr.Compile(filter)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := q.Bind(args(i)...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	fmt.Printf("GetMongoQueryFromString %s %s\n", parsed, parsed.MemString())
+	fmt.Printf("Compile+Bind            %s %s\n", compiled, compiled.MemString())
+}
@@ -0,0 +1,294 @@
+// checks the CRUD functions of dbcontext against the in-memory fake, run with: go run ./test/test_dbcontext
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	dbcontext "github.com/unvs/libs/db/ctx"
+	"github.com/unvs/libs/db/ctx/dbtest"
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Privilege struct {
+	User  string `bson:"user"`
+	Write bool   `bson:"write"`
+}
+
+type File struct {
+	tableName  struct{}    `table:"files"`
+	ID         int         `bson:"_id"`
+	Name       string      `bson:"name"`
+	Ext        string      `bson:"ext"`
+	Size       int64       `bson:"size"`
+	Tags       []string    `bson:"tags"`
+	Privileges []Privilege `bson:"privileges"`
+	Version    int         `bson:"version"`
+}
+
+type Note struct {
+	tableName struct{}           `table:"notes"`
+	ID        primitive.ObjectID `bson:"_id"`
+	Text      string             `bson:"text"`
+}
+
+type Untabled struct {
+	Name string `bson:"name"`
+}
+
+var files = []File{
+	{ID: 1, Name: "report.pdf", Ext: "pdf", Size: 2048, Tags: []string{"q1"}, Privileges: []Privilege{{"bob", true}}},
+	{ID: 2, Name: "notes.txt", Ext: "txt", Size: 12, Tags: []string{"draft"}},
+	{ID: 3, Name: "budget.xls", Ext: "xls", Size: 4096, Privileges: []Privilege{{"bob", false}, {"alice", true}}},
+	{ID: 4, Name: "summary.pdf", Ext: "pdf", Size: 512},
+}
+
+type testCase struct {
+	name     string
+	run      func(db *dbcontext.DB) (interface{}, error)
+	expected interface{}
+}
+
+func names(docs []File, err error) (interface{}, error) {
+	result := []string{}
+	for _, doc := range docs {
+		result = append(result, doc.Name)
+	}
+	return result, err
+}
+
+var cases = []testCase{
+	{"collection name", func(db *dbcontext.DB) (interface{}, error) {
+		return dbcontext.CollectionName[File]()
+	}, "files"},
+	{"find one", func(db *dbcontext.DB) (interface{}, error) {
+		f, err := dbcontext.FindOne[File](context.Background(), db, "Size > ? && Ext == ?", 1000, "xls")
+		return f.Name, err
+	}, "budget.xls"},
+	{"find one ordered", func(db *dbcontext.DB) (interface{}, error) {
+		f, err := dbcontext.FindOne[File](context.Background(), db, "Ext == \"pdf\" order by Size")
+		return f.Name, err
+	}, "summary.pdf"},
+	{"find", func(db *dbcontext.DB) (interface{}, error) {
+		return names(dbcontext.Find[File](context.Background(), db, "Size > ? order by Name desc", 100))
+	}, []string{"summary.pdf", "report.pdf", "budget.xls"}},
+	{"find all", func(db *dbcontext.DB) (interface{}, error) {
+		return names(dbcontext.Find[File](context.Background(), db, ""))
+	}, []string{"report.pdf", "notes.txt", "budget.xls", "summary.pdf"}},
+	{"find skip limit", func(db *dbcontext.DB) (interface{}, error) {
+		return names(dbcontext.Find[File](context.Background(), db, "order by Size skip 1 limit 2"))
+	}, []string{"summary.pdf", "report.pdf"}},
+	{"find select", func(db *dbcontext.DB) (interface{}, error) {
+		docs, err := dbcontext.Find[File](context.Background(), db, "Ext == \"pdf\" order by _id select Name")
+		return docs, err
+	}, []File{{ID: 1, Name: "report.pdf"}, {ID: 4, Name: "summary.pdf"}}},
+	{"find lambda", func(db *dbcontext.DB) (interface{}, error) {
+		return names(dbcontext.Find[File](context.Background(), db, "Any(Privileges, func(p) bool { return p.User == ? && p.Write })", "alice"))
+	}, []string{"budget.xls"}},
+	{"count", func(db *dbcontext.DB) (interface{}, error) {
+		return dbcontext.Count[File](context.Background(), db, "Ext == ?", "pdf")
+	}, int64(2)},
+	{"count all", func(db *dbcontext.DB) (interface{}, error) {
+		return dbcontext.Count[File](context.Background(), db, "")
+	}, int64(4)},
+	{"update one", func(db *dbcontext.DB) (interface{}, error) {
+		r, err := dbcontext.UpdateOne[File](context.Background(), db, "Name == ?", "Set(Size, ?), Inc(Version, 1), Push(Tags, ?)", "notes.txt", 64, "final")
+		if err != nil {
+			return nil, err
+		}
+		f, err := dbcontext.FindOne[File](context.Background(), db, "_id == 2")
+		return []interface{}{r.MatchedCount, r.ModifiedCount, f.Size, f.Version, f.Tags}, err
+	}, []interface{}{int64(1), int64(1), int64(64), 1, []string{"draft", "final"}}},
+	{"update many", func(db *dbcontext.DB) (interface{}, error) {
+		r, err := dbcontext.UpdateMany[File](context.Background(), db, "Ext == ?", "AddToSet(Tags, ?)", "pdf", "q1")
+		if err != nil {
+			return nil, err
+		}
+		docs, err := dbcontext.Find[File](context.Background(), db, "Ext == \"pdf\" order by _id")
+		tags := [][]string{}
+		for _, doc := range docs {
+			tags = append(tags, doc.Tags)
+		}
+		return []interface{}{r.MatchedCount, r.ModifiedCount, tags}, err
+	}, []interface{}{int64(2), int64(1), [][]string{{"q1"}, {"q1"}}}},
+	{"update pull", func(db *dbcontext.DB) (interface{}, error) {
+		if _, err := dbcontext.UpdateOne[File](context.Background(), db, "_id == 2", "Pull(Tags, ?), Pop(Privileges)", "draft"); err != nil {
+			return nil, err
+		}
+		f, err := dbcontext.FindOne[File](context.Background(), db, "_id == 2")
+		return f.Tags, err
+	}, []string{"final"}},
+	{"update no match", func(db *dbcontext.DB) (interface{}, error) {
+		r, err := dbcontext.UpdateOne[File](context.Background(), db, "Size > ?", "Unset(Tags)", 1<<20)
+		if err != nil {
+			return nil, err
+		}
+		return []int64{r.MatchedCount, r.ModifiedCount}, nil
+	}, []int64{0, 0}},
+	{"insert one", func(db *dbcontext.DB) (interface{}, error) {
+		r, err := dbcontext.InsertOne(context.Background(), db, File{ID: 5, Name: "draft.doc", Ext: "doc"})
+		if err != nil {
+			return nil, err
+		}
+		n, err := dbcontext.Count[File](context.Background(), db, "")
+		return []interface{}{r.InsertedID, n}, err
+	}, []interface{}{int32(5), int64(5)}},
+	{"insert many", func(db *dbcontext.DB) (interface{}, error) {
+		r, err := dbcontext.InsertMany(context.Background(), db, []File{{ID: 6, Name: "a.doc", Ext: "doc"}, {ID: 7, Name: "b.doc", Ext: "doc"}})
+		if err != nil {
+			return nil, err
+		}
+		return len(r.InsertedIDs), nil
+	}, 2},
+	{"delete one", func(db *dbcontext.DB) (interface{}, error) {
+		r, err := dbcontext.DeleteOne[File](context.Background(), db, "Ext == ?", "doc")
+		if err != nil {
+			return nil, err
+		}
+		n, err := dbcontext.Count[File](context.Background(), db, "Ext == \"doc\"")
+		return []int64{r.DeletedCount, n}, err
+	}, []int64{1, 2}},
+	{"delete many", func(db *dbcontext.DB) (interface{}, error) {
+		r, err := dbcontext.DeleteMany[File](context.Background(), db, "Ext == ?", "doc")
+		if err != nil {
+			return nil, err
+		}
+		return names(dbcontext.Find[File](context.Background(), db, fmt.Sprintf("_id > %d order by _id", r.DeletedCount)))
+	}, []string{"budget.xls", "summary.pdf"}},
+	{"dict", func(db *dbcontext.DB) (interface{}, error) {
+		if err := dbcontext.InsertOneByDictIn(context.Background(), db, "settings", map[string]interface{}{"key": "theme", "value": "light"}); err != nil {
+			return nil, err
+		}
+		if err := dbcontext.UpdateOneByDictIn(context.Background(), db, "settings", "key == ?", map[string]interface{}{"value": "dark"}, "theme"); err != nil {
+			return nil, err
+		}
+		doc, err := dbcontext.FindOneToDictIn(context.Background(), db, "settings", "key == ?", "theme")
+		return doc["value"], err
+	}, "dark"},
+	{"zero object ids", func(db *dbcontext.DB) (interface{}, error) {
+		if _, err := dbcontext.InsertOne(context.Background(), db, Note{Text: "a"}); err != nil {
+			return nil, err
+		}
+		if _, err := dbcontext.InsertMany(context.Background(), db, []Note{{Text: "b"}, {Text: "c"}}); err != nil {
+			return nil, err
+		}
+		notes, err := dbcontext.Find[Note](context.Background(), db, "order by Text")
+		ids := map[primitive.ObjectID]bool{}
+		texts := []string{}
+		for _, n := range notes {
+			ids[n.ID] = !n.ID.IsZero()
+			texts = append(texts, n.Text)
+		}
+		return []interface{}{len(ids), ids[primitive.ObjectID{}], texts}, err
+	}, []interface{}{3, false, []string{"a", "b", "c"}}},
+	{"partial insert", func(db *dbcontext.DB) (interface{}, error) {
+		r, err := dbcontext.InsertMany(context.Background(), db, []File{{ID: 20, Name: "x.doc"}, {ID: 1, Name: "copy.pdf"}, {ID: 21, Name: "y.doc"}})
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("expected a duplicate key error, got %v", err)
+		}
+		return r.InsertedIDs, nil
+	}, []interface{}{int32(20)}},
+}
+
+var failures = []struct {
+	name  string
+	run   func(db *dbcontext.DB) error
+	check func(err error) bool
+}{
+	{"no documents", func(db *dbcontext.DB) error {
+		_, err := dbcontext.FindOne[File](context.Background(), db, "Name == ?", "missing")
+		return err
+	}, func(err error) bool { return errors.Is(err, mongo.ErrNoDocuments) }},
+	{"no documents dict", func(db *dbcontext.DB) error {
+		_, err := dbcontext.FindOneToDictIn(context.Background(), db, "settings", "key == ?", "missing")
+		return err
+	}, func(err error) bool { return errors.Is(err, mongo.ErrNoDocuments) }},
+	{"dict without collection", func(db *dbcontext.DB) error {
+		_, err := dbcontext.FindOneToDict(db, "key == \"theme\"")
+		return err
+	}, func(err error) bool { return err != nil }},
+	{"canceled", func(db *dbcontext.DB) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := dbcontext.Find[File](ctx, db, "")
+		return err
+	}, func(err error) bool { return errors.Is(err, context.Canceled) }},
+	{"zero int id", func(db *dbcontext.DB) error {
+		_, err := dbcontext.InsertOne(context.Background(), db, File{Name: "new.doc"})
+		return err
+	}, func(err error) bool { return err != nil && !mongo.IsDuplicateKeyError(err) }},
+	{"duplicate key", func(db *dbcontext.DB) error {
+		_, err := dbcontext.InsertOne(context.Background(), db, File{ID: 1, Name: "copy.pdf"})
+		return err
+	}, mongo.IsDuplicateKeyError},
+	{"no table tag", func(db *dbcontext.DB) error {
+		_, err := dbcontext.Find[Untabled](context.Background(), db, "")
+		return err
+	}, func(err error) bool { return err != nil && err.Error() == "model main.Untabled has no table tag" }},
+	{"bad filter", func(db *dbcontext.DB) error {
+		_, err := dbcontext.Count[File](context.Background(), db, "Size >")
+		return err
+	}, isExprError},
+	{"unknown field", func(db *dbcontext.DB) error {
+		_, err := dbcontext.DeleteMany[File](context.Background(), db, "Owner == ?", "bob")
+		return err
+	}, isExprError},
+	{"missing filter argument", func(db *dbcontext.DB) error {
+		_, err := dbcontext.UpdateOne[File](context.Background(), db, "Name == ? && Ext == ?", "Set(Size, 1)", "a")
+		return err
+	}, func(err error) bool { return err != nil }},
+	{"extra update argument", func(db *dbcontext.DB) error {
+		_, err := dbcontext.UpdateOne[File](context.Background(), db, "Name == ?", "Set(Size, ?)", "a", 1, 2)
+		return err
+	}, isExprError},
+	{"empty filter argument", func(db *dbcontext.DB) error {
+		_, err := dbcontext.Count[File](context.Background(), db, "", 1)
+		return err
+	}, func(err error) bool { return err != nil }},
+}
+
+func isExprError(err error) bool {
+	var e *expr.Error
+	return errors.As(err, &e)
+}
+
+func main() {
+	db := dbtest.NewFake("test")
+	if _, err := dbcontext.InsertMany(context.Background(), db, files); err != nil {
+		fmt.Printf("FAIL insert: %v\n", err)
+		os.Exit(1)
+	}
+	failed := 0
+	// the cases run in order against the same collection
+	for _, c := range cases {
+		actual, err := c.run(db)
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", c.name, err)
+			failed++
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			fmt.Printf("FAIL %s\n  expected %#v\n  actual   %#v\n", c.name, c.expected, actual)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	for _, c := range failures {
+		err := c.run(db)
+		if !c.check(err) {
+			fmt.Printf("FAIL %s: unexpected error %v\n", c.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s: %v\n", c.name, err)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
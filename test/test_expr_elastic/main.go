@@ -0,0 +1,167 @@
+// checks the backend translators of expr (mongo and elasticsearch), run with: go run ./test/test_expr_elastic
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type testCase struct {
+	name     string
+	expr     string
+	args     []interface{}
+	expected string // the query as JSON, keys sorted
+}
+
+var es = expr.ElasticTranslator{ContentField: "content_bm25"}
+
+var cases = []testCase{
+	{"term", "Ext == ?", []interface{}{"pdf"}, `{"term":{"Ext":"pdf"}}`},
+	{"not term", "Ext != \"tmp\"", nil, `{"bool":{"must_not":[{"term":{"Ext":"tmp"}}]}}`},
+	{"range", "Size >= ?", []interface{}{10}, `{"range":{"Size":{"gte":10}}}`},
+	{"range reversed", "10 < Size", nil, `{"range":{"Size":{"gt":10}}}`},
+	{"folded constant", "Size > 2 * 1024", nil, `{"range":{"Size":{"gt":2048}}}`},
+	{"must", "Size > 1 && Size < 10 && !Deleted", nil,
+		`{"bool":{"must":[{"range":{"Size":{"gt":1}}},{"range":{"Size":{"lt":10}}},{"bool":{"must_not":[{"term":{"Deleted":true}}]}}]}}`},
+	{"should", "Ext == \"doc\" || Ext == \"pdf\"", nil,
+		`{"bool":{"minimum_should_match":1,"should":[{"term":{"Ext":"doc"}},{"term":{"Ext":"pdf"}}]}}`},
+	{"nil", "TempPath == nil && Owner != nil", nil,
+		`{"bool":{"must":[{"bool":{"must_not":[{"exists":{"field":"TempPath"}}]}},{"exists":{"field":"Owner"}}]}}`},
+	{"exists", "Exists(Owner) && NotExists(TempPath)", nil,
+		`{"bool":{"must":[{"exists":{"field":"Owner"}},{"bool":{"must_not":[{"exists":{"field":"TempPath"}}]}}]}}`},
+	{"contains", "Contains(Name, ?)", []interface{}{"a*b?"}, `{"wildcard":{"Name":{"value":"*a\\*b\\?*"}}}`},
+	{"starts with", "StartsWith(Name, ?)", []interface{}{"rep"}, `{"wildcard":{"Name":{"value":"rep*"}}}`},
+	{"iends with", "IEndsWith(Name, ?)", []interface{}{".PDF"}, `{"wildcard":{"Name":{"case_insensitive":true,"value":"*.PDF"}}}`},
+	{"regex", "Regex(Name, ?, \"i\")", []interface{}{`^report-\d+`}, `{"regexp":{"Name":{"case_insensitive":true,"value":"report-[0-9]+.*"}}}`},
+	{"regex classes", "Regex(Name, ?)", []interface{}{`\w+@[\d_]+\S$`}, `{"regexp":{"Name":{"value":".*[a-zA-Z0-9_]+\\@[0-9_]+[^ \t\n\u000b\f\r]"}}}`},
+	{"regex escaped dollar", "Regex(Name, ?)", []interface{}{`(?:a|b)\$`}, `{"regexp":{"Name":{"value":".*(a|b)\\$.*"}}}`},
+	{"in", "In(Ext, ?)", []interface{}{[]string{"doc", "pdf"}}, `{"terms":{"Ext":["doc","pdf"]}}`},
+	{"not in", "NotIn(Ext, ?)", []interface{}{[]string{"tmp"}}, `{"bool":{"must_not":[{"terms":{"Ext":["tmp"]}}]}}`},
+	{"all", "All(Tags, ?)", []interface{}{[]string{"a", "b"}}, `{"bool":{"must":[{"term":{"Tags":"a"}},{"term":{"Tags":"b"}}]}}`},
+	{"search content", "Search(?) && Ext == \"pdf\"", []interface{}{"annual report"},
+		`{"bool":{"must":[{"match":{"content_bm25":"annual report"}},{"term":{"Ext":"pdf"}}]}}`},
+	{"search field", "Search(Title, ?)", []interface{}{"report"}, `{"match":{"Title":"report"}}`},
+	{"nested", "Any(Privileges, func(p) bool { return p.User == ? && p.Write })", []interface{}{"bob"},
+		`{"nested":{"path":"Privileges","query":{"bool":{"must":[{"term":{"Privileges.User":"bob"}},{"term":{"Privileges.Write":true}}]}}}}`},
+	{"plain array", "Any(Tags, func(t) bool { return t == ? })", []interface{}{"q1"}, `{"term":{"Tags":"q1"}}`},
+}
+
+var unsupported = []struct {
+	expr string
+	code expr.ErrorCode
+	at   string
+}{
+	{"Size > Used", expr.ErrUnsupportedNode, "Size > Used"},
+	{"Size / 1024 > 1", expr.ErrUnsupportedNode, "Size / 1024 > 1"},
+	{"Len(Tags) == 2", expr.ErrUnsupportedNode, "Len(Tags) == 2"},
+	{"Regex(Name, \"a\", \"m\")", expr.ErrInvalidArgument, "\"m\""},
+	{"Regex(Name, \"a(?=b)\")", expr.ErrInvalidArgument, "\"a(?=b)\""},
+	{"Regex(Name, \"(?i)a\")", expr.ErrInvalidArgument, "\"(?i)a\""},
+	{"Regex(Name, `\\bword`)", expr.ErrInvalidArgument, "`\\bword`"},
+	{"Regex(Name, \"a+?\")", expr.ErrInvalidArgument, "\"a+?\""},
+	{"Regex(Name, \"a|^b\")", expr.ErrInvalidArgument, "\"a|^b\""},
+}
+
+// fields walks the public tree and lists the field paths of an expression.
+func fields(node expr.Node, found []string) []string {
+	switch n := node.(type) {
+	case expr.FieldAnalyzer:
+		found = append(found, n.Name)
+	case expr.Analyzer:
+		found = fields(n.Left, found)
+		if n.Right != nil {
+			// a unary operator
+			found = fields(n.Right, found)
+		}
+	case expr.FuncAnalyzer:
+		for _, arg := range n.Args {
+			found = fields(arg, found)
+		}
+	case expr.LambdaAnalyzer:
+		found = fields(n.Body, found)
+	}
+	return found
+}
+
+func main() {
+	failed := 0
+	for _, c := range cases {
+		q, err := expr.Translate(es, c.expr, c.args...)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s: %v\n", c.name, c.expr, err)
+			failed++
+			continue
+		}
+		data, _ := json.Marshal(q)
+		if string(data) != c.expected {
+			fmt.Printf("FAIL %s: %s\n  expected %s\n  actual   %s\n", c.name, c.expr, c.expected, data)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	for _, c := range unsupported {
+		_, err := expr.Translate(es, c.expr)
+		var e *expr.Error
+		if !errors.As(err, &e) || e.Code != c.code || e.Expr != c.at {
+			fmt.Printf("FAIL %q: expected %s at %q, got %v\n", c.expr, c.code, c.at, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %v\n", err)
+	}
+
+	// the same expression goes to mongo for the metadata and to the search index for the content
+	e, err := expr.Parse("Owner == ? && Size > ? && Search(?)", "alice", 10, "invoice")
+	if err != nil {
+		fmt.Printf("FAIL parse: %v\n", err)
+		os.Exit(1)
+	}
+	filter, err := expr.MongoTranslator{}.Translate(e)
+	expected := bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "Owner", Value: "alice"}},
+		bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 10}}}},
+		bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "invoice"}}}},
+	}}}
+	if err != nil || !reflect.DeepEqual(filter, expected) {
+		fmt.Printf("FAIL mongo translator\n  expected %v\n  actual   %v %v\n", expected, filter, err)
+		failed++
+	} else {
+		fmt.Printf("ok   mongo translator\n")
+	}
+	if _, err := es.Translate(e); err != nil {
+		fmt.Printf("FAIL elastic translator on the same expression: %v\n", err)
+		failed++
+	}
+	// unary operators have no right operand, a comparison with nil has the IsNull node on the right
+	unary, err := expr.Parse("!Deleted && Size == nil")
+	if err == nil {
+		and := unary.Root.(expr.Analyzer)
+		not, compare := and.Left.(expr.Analyzer), and.Right.(expr.Analyzer)
+		isNull, ok := compare.Right.(expr.FuncAnalyzer)
+		if not.Right != nil || !ok || isNull.Name != "IsNull" || len(isNull.Args) != 0 {
+			err = fmt.Errorf("unexpected tree %#v", unary.Root)
+		}
+	}
+	if err != nil {
+		fmt.Printf("FAIL operands: %v\n", err)
+		failed++
+	} else {
+		fmt.Printf("ok   operands\n")
+	}
+	if found := fields(e.Root, nil); !reflect.DeepEqual(found, []string{"Owner", "Size"}) {
+		fmt.Printf("FAIL walking the tree: %v\n", found)
+		failed++
+	} else {
+		fmt.Printf("ok   walking the tree\n")
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
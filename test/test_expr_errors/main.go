@@ -0,0 +1,84 @@
+// checks the error codes and positions reported by expr, run with: go run ./test/test_expr_errors
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	expr "github.com/unvs/libs/db/expr"
+)
+
+type testCase struct {
+	name string
+	expr string
+	args []interface{}
+	code expr.ErrorCode
+	text string // the sub-expression the error must point at
+}
+
+var cases = []testCase{
+	{"syntax", "Size > ", nil, expr.ErrSyntax, " "},
+	{"syntax after placeholder", "Name == ? Size > 1", []interface{}{"a"}, expr.ErrSyntax, "S"},
+	{"integer literal out of range", "Size > 0x1_0000_0000_0000_0000", nil, expr.ErrSyntax, "0x1_0000_0000_0000_0000"},
+	{"unary address", "Name == ? &&& Size", []interface{}{"a"}, expr.ErrUnsupportedOperator, "& Size"},
+	{"unsupported operator", "Name == ? && Size & 4", []interface{}{"a"}, expr.ErrUnsupportedOperator, "Size & 4"},
+	{"unsupported function", "Name == ? && Foo(Size)", []interface{}{"a"}, expr.ErrUnsupportedFunction, "Foo(Size)"},
+	{"unsupported node", "Tags[0] == ?", []interface{}{"a"}, expr.ErrUnsupportedNode, "Tags[0]"},
+	{"wrong argument count", "Contains(Name)", nil, expr.ErrInvalidArgument, "Contains(Name)"},
+	{"wrong argument type", "Contains(Name, ?)", []interface{}{12}, expr.ErrInvalidArgument, "?"},
+	{"unsupported argument", "Name == ?", []interface{}{make(chan int)}, expr.ErrParameter, "?"},
+	{"placeholder count", "Name == ?", nil, expr.ErrParameter, ""},
+	{"nil ordering", "Size > nil", nil, expr.ErrInvalidOperand, "Size > nil"},
+	{"not a condition", "\"text\"", nil, expr.ErrInvalidOperand, "\"text\""},
+	{"regex flag", "Regex(Name, ?, \"g\")", []interface{}{"a"}, expr.ErrInvalidArgument, "\"g\""},
+	{"pattern too long", "Contains(Name, ?)", []interface{}{strings.Repeat("a", expr.DefaultMaxPatternLength+1)}, expr.ErrInvalidArgument, "?"},
+	{"lambda outer field", "Any(Tags, func(t) bool { return Name == t })", nil, expr.ErrInvalidArgument, "Name"},
+	{"lambda two parameters", "Any(Tags, func(a, b) bool { return a == b })", nil, expr.ErrUnsupportedNode, "func(a, b) bool"},
+	{"lambda statements", "Any(Tags, func(t) bool { x := 1; return t == x })", nil, expr.ErrUnsupportedNode, "{ x := 1; return t == x }"},
+	{"division by zero", "Size / 0 > 1", nil, expr.ErrInvalidOperand, "Size / 0"},
+	{"modulo by zero", "Size % ? == 1", []interface{}{0}, expr.ErrInvalidOperand, "Size % ?"},
+	{"division by folded zero", "Size / (2 - 2) > 1", nil, expr.ErrInvalidOperand, "Size / (2 - 2)"},
+	{"division by float zero", "Size / ? > 1", []interface{}{0.0}, expr.ErrInvalidOperand, "Size / ?"},
+	{"overflow", "Size > ? * 2", []interface{}{int64(math.MaxInt64)}, expr.ErrInvalidOperand, "? * 2"},
+	{"overflow in sum", "Size > 9223372036854775807 + ?", []interface{}{1}, expr.ErrInvalidOperand, "9223372036854775807 + ?"},
+	{"overflow in difference", "Size > ? - 1", []interface{}{int64(math.MinInt64)}, expr.ErrInvalidOperand, "? - 1"},
+	{"overflow in negation", "Size > -?", []interface{}{int64(math.MinInt64)}, expr.ErrInvalidOperand, "-?"},
+	{"negative length", "Len(Tags) == -1", nil, expr.ErrInvalidArgument, "-1"},
+	{"float length", "Len(Tags) != ?", []interface{}{2.5}, expr.ErrInvalidArgument, "?"},
+	{"string length", "? == Len(Tags)", []interface{}{"2"}, expr.ErrInvalidArgument, "?"},
+	{"folded negative length", "Len(Tags) == 1 - 2", nil, expr.ErrInvalidArgument, "1 - 2"},
+	{"negated search", "!Search(?)", []interface{}{"a"}, expr.ErrInvalidOperand, "Search(?)"},
+	{"search in negated or", "!(Deleted || Search(?))", []interface{}{"a"}, expr.ErrInvalidOperand, "Search(?)"},
+	{"search deep under not", "!(Size > 1 && !(Deleted || Search(Content, ?)))", []interface{}{"a"}, expr.ErrInvalidOperand, "Search(Content, ?)"},
+	{"element with or", "Any(Tags, func(t) bool { return t == \"a\" || t == \"b\" })", nil, expr.ErrInvalidArgument, "func(t) bool { return t == \"a\" || t == \"b\" }"},
+}
+
+func main() {
+	failed := 0
+	for _, c := range cases {
+		_, err := expr.GetMongoQueryFromString(c.expr, c.args...)
+		var e *expr.Error
+		switch {
+		case err == nil:
+			fmt.Printf("FAIL %s: expected an error\n", c.name)
+			failed++
+		case !errors.As(err, &e):
+			fmt.Printf("FAIL %s: expected *expr.Error, got %T: %v\n", c.name, err, err)
+			failed++
+		case e.Code != c.code || e.Expr != c.text:
+			fmt.Printf("FAIL %s: expected %s at %q, got %s at %q (%v)\n", c.name, c.code, c.text, e.Code, e.Expr, err)
+			failed++
+		case e.Expr != "" && c.expr[e.Span.Start:e.Span.End] != e.Expr:
+			fmt.Printf("FAIL %s: span %v does not match %q\n", c.name, e.Span, e.Expr)
+			failed++
+		default:
+			fmt.Printf("ok   %s: %v\n", c.name, err)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,190 @@
+// checks the in-memory evaluation of expr filters, run with: go run ./test/test_expr_evaluate
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type Audit struct {
+	CreatedOn time.Time `bson:"created_on"`
+	CreatedBy string    `field:"created_by"`
+}
+
+type File struct {
+	Audit
+	ID       int      `field:"id"`
+	Name     string   `field:"name"`
+	Size     int64    `bson:"size,omitempty"`
+	Ext      string   `field:"ext"`
+	Tags     []string `field:"tags"`
+	Scores   []int    `field:"scores"`
+	Versions []Owner  `field:"versions"`
+	Deleted  bool     `field:"deleted"`
+	Owner    *Owner   `field:"owner"`
+	TempPath *string  `field:"temp_path"`
+}
+
+type Owner struct {
+	Name  string `field:"name"`
+	Write bool   `field:"write"`
+}
+
+type testCase struct {
+	name     string
+	expr     string
+	args     []interface{}
+	expected bool
+}
+
+var created = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+var file = File{
+	Audit:    Audit{CreatedOn: created, CreatedBy: "bob"},
+	ID:       7,
+	Name:     "report(1).docx",
+	Size:     2048,
+	Ext:      "docx",
+	Tags:     []string{"finance", "q1"},
+	Scores:   []int{72, 83},
+	Versions: []Owner{{Name: "alice", Write: true}, {Name: "bob"}},
+	Owner:    &Owner{Name: "alice"},
+}
+
+var cases = []testCase{
+	{"eq tag", "name == ?", []interface{}{"report(1).docx"}, true},
+	{"eq go name", "Name == ?", []interface{}{"report(1).docx"}, true},
+	{"id", "id == 7", nil, true},
+	{"gt int vs float", "size > 1024.5", nil, true},
+	{"lte", "size <= 2047", nil, false},
+	{"constant on the left", "4096 > size", nil, true},
+	{"bson tag of embedded struct", "created_on >= ?", []interface{}{created}, true},
+	{"field tag of embedded struct", "created_by == \"bob\"", nil, true},
+	{"nested pointer", "owner.name == \"alice\"", nil, true},
+	{"array contains", "tags == \"q1\"", nil, true},
+	{"array ne", "tags != \"q1\"", nil, false},
+	{"in", "In(ext, ?)", []interface{}{[]string{"pdf", "docx"}}, true},
+	{"not in", "NotIn(ext, ?)", []interface{}{[]string{"pdf", "docx"}}, false},
+	{"in array field", "In(tags, ?)", []interface{}{[]string{"q2", "finance"}}, true},
+	{"contains", "Contains(name, ?)", []interface{}{"port"}, true},
+	{"starts with", "StartsWith(name, ?)", []interface{}{"rep"}, true},
+	{"ends with", "EndsWith(name, ?)", []interface{}{".pdf"}, false},
+	{"contains literal", "Contains(name, ?)", []interface{}{"(1).docx"}, true},
+	{"contains is not a regex", "Contains(name, ?)", []interface{}{"rep.rt"}, false},
+	{"icontains", "IContains(name, ?)", []interface{}{"REPORT"}, true},
+	{"istartswith", "IStartsWith(name, ?)", []interface{}{"Rep"}, true},
+	{"iendswith", "IEndsWith(name, ?)", []interface{}{".DOCX"}, true},
+	{"case sensitive", "StartsWith(name, ?)", []interface{}{"Rep"}, false},
+	{"regex", "Regex(name, ?)", []interface{}{`^report\(\d\)`}, true},
+	{"regex flags", "Regex(name, ?, \"i\")", []interface{}{`^REPORT`}, true},
+	{"missing is nil", "missing == nil", nil, true},
+	{"nil pointer is nil", "temp_path == nil", nil, true},
+	{"present is not nil", "name != nil", nil, true},
+	{"is null", "IsNull(temp_path)", nil, true},
+	{"exists", "Exists(name)", nil, true},
+	{"missing does not exist", "NotExists(missing)", nil, true},
+	{"nested exists", "Exists(owner.name) && NotExists(owner.email)", nil, true},
+	{"missing does not compare", "missing > 1", nil, false},
+	{"not gt matches missing", "!(missing > 1)", nil, true},
+	{"bare field", "deleted", nil, false},
+	{"not bare field", "!deleted", nil, true},
+	{"and or", "ext == \"docx\" && (size > 1048576 || Contains(name, \"report\"))", nil, true},
+	{"field vs field", "size > id", nil, true},
+	{"string vs number", "name > 1", nil, false},
+	{"any", "Any(versions, func(v) bool { return v.name == ? && v.write })", []interface{}{"alice"}, true},
+	{"any same element", "Any(versions, func(v) bool { return v.name == ? && v.write })", []interface{}{"bob"}, false},
+	{"any element itself", "Any(scores, func(s) bool { return s >= 80 && s < 85 })", nil, true},
+	{"any no element", "Any(scores, func(s) bool { return s > 72 && s < 83 })", nil, false},
+	{"any missing", "Any(missing, func(s) bool { return s > 1 })", nil, false},
+	{"all", "All(tags, ?)", []interface{}{[]string{"q1", "finance"}}, true},
+	{"all partial", "All(tags, ?)", []interface{}{[]string{"q1", "q2"}}, false},
+	{"len", "Len(tags) == ?", []interface{}{2}, true},
+	{"len ne", "Len(versions) != 2", nil, false},
+	{"search field", "Search(name, ?)", []interface{}{"budget REPORT"}, true},
+	{"search no word", "Search(name, ?)", []interface{}{"invoice"}, false},
+	{"arithmetic", "size / 1024 == 2", nil, true},
+	{"arithmetic vs field", "size - id * 2 > 2000", nil, true},
+	{"folded placeholder", "size >= ? * 1024", []interface{}{2}, true},
+	{"mod", "size % 3 == 2", nil, true},
+	{"overflow gives a double", "size * ? > 0", []interface{}{int64(math.MaxInt64)}, true},
+	{"unary minus", "-size < 0", nil, true},
+	{"missing propagates null", "missing + 1 > 0", nil, false},
+	{"lower", "lower(owner.name) == \"alice\"", nil, true},
+	{"upper", "upper(ext) == \"DOCX\"", nil, true},
+	{"len", "len(tags) == 2", nil, true},
+	{"year", "year(created_on) == 2024", nil, true},
+	{"month day", "month(created_on) == 3 && day(created_on) == 1", nil, true},
+}
+
+// nullCases tell a null field from a missing one, expected holds the result for {deleted: null} and for {}.
+var nullCases = []struct {
+	expr     string
+	expected [2]bool
+}{
+	{"deleted == nil", [2]bool{true, true}},
+	{"deleted != nil", [2]bool{false, false}},
+	{"!(deleted == nil)", [2]bool{false, false}},
+	{"IsNull(deleted)", [2]bool{true, true}},
+	{"Exists(deleted)", [2]bool{true, false}},
+	{"NotExists(deleted)", [2]bool{false, true}},
+	{"Exists(deleted) && deleted == nil", [2]bool{true, false}},
+	{"In(deleted, nil)", [2]bool{true, true}},
+}
+
+func main() {
+	docs := map[string]interface{}{
+		"struct":         file,
+		"struct pointer": &file,
+		"map": map[string]interface{}{
+			"created_on": created, "created_by": "bob", "_id": 7, "name": "report(1).docx", "size": 2048.0,
+			"ext": "docx", "tags": []interface{}{"finance", "q1"}, "deleted": false,
+			"owner": map[string]interface{}{"name": "alice"}, "temp_path": nil, "scores": []interface{}{72, 83},
+			"versions": []interface{}{map[string]interface{}{"name": "alice", "write": true}, map[string]interface{}{"name": "bob"}},
+		},
+		"bson.D": bson.D{
+			{Key: "created_on", Value: created}, {Key: "created_by", Value: "bob"}, {Key: "_id", Value: int32(7)}, {Key: "name", Value: "report(1).docx"}, {Key: "size", Value: int64(2048)},
+			{Key: "ext", Value: "docx"}, {Key: "tags", Value: bson.A{"finance", "q1"}}, {Key: "deleted", Value: false},
+			{Key: "owner", Value: bson.M{"name": "alice"}}, {Key: "scores", Value: bson.A{int32(72), int32(83)}},
+			{Key: "versions", Value: bson.A{bson.D{{Key: "name", Value: "alice"}, {Key: "write", Value: true}}, bson.D{{Key: "name", Value: "bob"}, {Key: "write", Value: false}}}},
+		},
+	}
+	failed := 0
+	for kind, doc := range docs {
+		for _, c := range cases {
+			if kind != "struct" && kind != "struct pointer" && c.name == "eq go name" {
+				continue
+			}
+			actual, err := expr.Evaluate(c.expr, doc, c.args...)
+			if err != nil {
+				fmt.Printf("FAIL %s/%s: %s: %v\n", kind, c.name, c.expr, err)
+				failed++
+				continue
+			}
+			if actual != c.expected {
+				fmt.Printf("FAIL %s/%s: %s: expected %v\n", kind, c.name, c.expr, c.expected)
+				failed++
+				continue
+			}
+		}
+	}
+	for _, c := range nullCases {
+		for i, doc := range []bson.D{{{Key: "deleted", Value: nil}}, {}} {
+			actual, err := expr.Evaluate(c.expr, doc)
+			if err != nil || actual != c.expected[i] {
+				fmt.Printf("FAIL %s on %v: expected %v, got %v %v\n", c.expr, doc, c.expected[i], actual, err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Printf("ok   %d cases on %d kinds of documents\n", len(cases), len(docs))
+	fmt.Printf("ok   %d null cases\n", len(nullCases))
+}
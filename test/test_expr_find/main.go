@@ -0,0 +1,143 @@
+// checks the find statements of expr (where, order by, limit, offset, select), run with: go run ./test/test_expr_find
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type File struct {
+	Name      string `field:"name"`
+	Size      int64  `field:"size"`
+	CreatedOn int64  `field:"created_on"`
+}
+
+func int64Ptr(n int64) *int64 {
+	return &n
+}
+
+type testCase struct {
+	name     string
+	run      func() (*expr.FindQuery, error)
+	expected *expr.FindQuery
+}
+
+var cases = []testCase{
+	{"full statement", func() (*expr.FindQuery, error) {
+		return expr.ParseFind("where Size > ? && Contains(Name, \"limit\") order by CreatedOn desc, Name limit 50 offset 100 select Name, Size", 10)
+	}, &expr.FindQuery{
+		Filter: bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 10}}}},
+			bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: "limit"}}}},
+		}}},
+		Sort:       bson.D{{Key: "CreatedOn", Value: -1}, {Key: "Name", Value: 1}},
+		Projection: bson.D{{Key: "Name", Value: 1}, {Key: "Size", Value: 1}},
+		Skip:       int64Ptr(100),
+		Limit:      int64Ptr(50),
+	}},
+	{"implicit where and placeholders in limit", func() (*expr.FindQuery, error) {
+		return expr.ParseFind("Size > ? limit ? skip ?", 1, 20, 40)
+	}, &expr.FindQuery{
+		Filter: bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 1}}}},
+		Skip:   int64Ptr(40),
+		Limit:  int64Ptr(20),
+	}},
+	{"no filter", func() (*expr.FindQuery, error) {
+		return expr.ParseFind("order by id limit 10")
+	}, &expr.FindQuery{
+		Filter: bson.D{},
+		Sort:   bson.D{{Key: "_id", Value: 1}},
+		Limit:  int64Ptr(10),
+	}},
+	{"fields named like keywords", func() (*expr.FindQuery, error) {
+		return expr.ParseFind("Limit > ? && Order == ? && Skip && !Where order by Offset desc, Owner.Limit limit 5 select Select, Owner.Order", 1, "a")
+	}, &expr.FindQuery{
+		Filter: bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "Limit", Value: bson.D{{Key: "$gt", Value: 1}}}},
+			bson.D{{Key: "Order", Value: "a"}},
+			bson.D{{Key: "Skip", Value: true}},
+			bson.D{{Key: "Where", Value: bson.D{{Key: "$ne", Value: true}}}},
+		}}},
+		Sort:       bson.D{{Key: "Offset", Value: -1}, {Key: "Owner.Limit", Value: 1}},
+		Projection: bson.D{{Key: "Select", Value: 1}, {Key: "Owner.Order", Value: 1}},
+		Limit:      int64Ptr(5),
+	}},
+	{"lowercase fields named like keywords", func() (*expr.FindQuery, error) {
+		return expr.ParseFind("limit >= 2 && owner.skip == ? && offset < skip order by where", "x")
+	}, &expr.FindQuery{
+		Filter: bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "limit", Value: bson.D{{Key: "$gte", Value: 2}}}},
+			bson.D{{Key: "owner.skip", Value: "x"}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$offset", "$skip"}}}}},
+		}}},
+		Sort: bson.D{{Key: "where", Value: 1}},
+	}},
+	{"model fields", func() (*expr.FindQuery, error) {
+		return expr.ParseFindFor[File]("Size > ? order by CreatedOn desc select Name", 1)
+	}, &expr.FindQuery{
+		Filter:     bson.D{{Key: "size", Value: bson.D{{Key: "$gt", Value: 1}}}},
+		Sort:       bson.D{{Key: "created_on", Value: -1}},
+		Projection: bson.D{{Key: "name", Value: 1}},
+	}},
+	{"text search by relevance", func() (*expr.FindQuery, error) {
+		return expr.ParseFind("Search(?) && Size > 1 order by Score(), Name select Name, Score()", "report")
+	}, &expr.FindQuery{
+		Filter: bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "report"}}}},
+			bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 1}}}},
+		}}},
+		Sort:       bson.D{{Key: expr.TextScoreField, Value: bson.D{{Key: "$meta", Value: "textScore"}}}, {Key: "Name", Value: 1}},
+		Projection: bson.D{{Key: "Name", Value: 1}, {Key: expr.TextScoreField, Value: bson.D{{Key: "$meta", Value: "textScore"}}}},
+	}},
+}
+
+var invalid = []struct {
+	text string
+	args []interface{}
+	code expr.ErrorCode
+	at   string
+}{
+	{"Size > 1 order CreatedOn", nil, expr.ErrSyntax, "order"},
+	{"Size > 1 limit -1", nil, expr.ErrInvalidArgument, "-1"},
+	{"Size > 1 limit ten", nil, expr.ErrSyntax, "ten"},
+	{"Size > 1 order by Name up", nil, expr.ErrSyntax, "Name up"},
+	{"Size > 1 limit 1 limit 2", nil, expr.ErrSyntax, "limit"},
+	{"Size > 1 LIMIT 2", nil, expr.ErrSyntax, "L"},
+	{"Size > ? limit ?", []interface{}{1}, expr.ErrParameter, ""},
+	{"Size > 1 && Foo(Name) limit 1", nil, expr.ErrUnsupportedFunction, "Foo(Name)"},
+	{"Size > 1 select Name, Len(Name)", nil, expr.ErrInvalidOperand, "Len(Name)"},
+	{"Size > 1 order by Score()", nil, expr.ErrInvalidOperand, ""},
+	{"!Search(?) select Score()", []interface{}{"a"}, expr.ErrInvalidOperand, "Search(?)"},
+	{"Search(\"  \")", nil, expr.ErrInvalidArgument, "\"  \""},
+}
+
+func main() {
+	failed := 0
+	for _, c := range cases {
+		actual, err := c.run()
+		if err != nil || !reflect.DeepEqual(actual, c.expected) {
+			fmt.Printf("FAIL %s\n  expected %+v\n  actual   %+v %v\n", c.name, c.expected, actual, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	for _, c := range invalid {
+		_, err := expr.ParseFind(c.text, c.args...)
+		var e *expr.Error
+		if !errors.As(err, &e) || e.Code != c.code || e.Expr != c.at {
+			fmt.Printf("FAIL %q: expected %s at %q, got %v\n", c.text, c.code, c.at, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %v\n", err)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
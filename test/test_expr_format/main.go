@@ -0,0 +1,180 @@
+// checks expr.Format and expr.FromBSON, run with: go run ./test/test_expr_format
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var formatCases = []struct {
+	name     string
+	expr     string
+	expected string
+}{
+	{"spacing", "Size>10&&Ext==\"pdf\"", `Ext == "pdf" && Size > 10`},
+	{"parentheses", "((Size > 10)) && (Ext == \"pdf\" || (Ext == \"doc\"))", `(Ext == "doc" || Ext == "pdf") && Size > 10`},
+	{"needed parentheses", "(a || b) && c", "(a || b) && c"},
+	{"flattened", "a && (b && (c && d))", "a && b && c && d"},
+	{"sorted", "z || y || x", "x || y || z"},
+	{"duplicates", "Size > 1 && Size > 1", "Size > 1"},
+	{"folded", "Size > 2 * 1024 && Ratio < 1 / 4", "Ratio < 0.25 && Size > 2048"},
+	{"whole float", "Ratio == 4 / 2", "Ratio == 2.0"},
+	{"arithmetic kept", "Size - Used > 10 * (2 + 3)", "Size - Used > 50"},
+	{"arithmetic parentheses", "(Size - (Used - 1)) * 2 > 0", "(Size - (Used - 1)) * 2 > 0"},
+	{"constant left", "10 < Size", "Size > 10"},
+	{"field order", "Used < Size", "Size > Used"},
+	{"nil left", "nil == Owner", "Owner == nil"},
+	{"isnull", "isnull(Owner)", "Owner == nil"},
+	{"true", "Deleted == true && Archived != true", "!Archived && Deleted"},
+	{"function names", "contains(Name, \"a\") && istartswith(Name, \"b\")", `Contains(Name, "a") && IStartsWith(Name, "b")`},
+	{"in values", "In(Ext, \"pdf\", \"doc\", \"pdf\")", `In(Ext, "doc", "pdf")`},
+	{"not eq", "!(Ext == \"pdf\")", `Ext != "pdf"`},
+	{"not not", "!!Deleted", "Deleted"},
+	{"not in", "!In(Ext, \"a\")", `NotIn(Ext, "a")`},
+	{"not exists", "!Exists(Owner)", "NotExists(Owner)"},
+	{"de morgan", "!(Size > 1 && Ext == \"pdf\")", `!(Size > 1) || Ext != "pdf"`},
+	{"nor kept", "!(a || b)", "!(a || b)"},
+	{"id", "id == 5", "_id == 5"},
+	{"lambda", "Any(Privileges, func(p) bool { return p.Write && p.User == \"bob\" })",
+		`Any(Privileges, func(e) bool { return e.User == "bob" && e.Write })`},
+	{"lambda element", "Any(Scores, func(s int) bool { return 80 <= s })", "Any(Scores, func(e) bool { return e >= 80 })"},
+	{"string escapes", `Name == "a \"quoted\"\n"`, `Name == "a \"quoted\"\n"`},
+	{"named", "@max > Size && Owner == @owner", "Owner == @owner && Size < @max"},
+	{"positional order", "Size > ? && Ext == ? && Deleted", "Deleted && Size > ? && Ext == ?"},
+	{"unary minus", "-Size < -5", "-Size < -5"},
+}
+
+// the filter of the expression converted back must be its formatted text
+var roundTrips = []struct {
+	expr string
+	args []interface{}
+}{
+	{"Ext == \"pdf\" && Size > 1024", nil},
+	{"Size >= 1 && Size < 5 || Owner == nil", nil},
+	{"Owner != \"alice\" && !Deleted", nil},
+	{"!(Ext == \"doc\" || Ext == \"pdf\")", nil},
+	{"!(Size > 10)", nil},
+	{"Contains(Name, ?) && IEndsWith(Name, ?)", []interface{}{"a.b", ".PDF"}},
+	{"StartsWith(Name, ?)", []interface{}{"report (1)"}},
+	{"Regex(Name, ?, \"i\")", []interface{}{`^report-\d+$`}},
+	{"Regex(Name, ?)", []interface{}{`a+`}},
+	{"In(Ext, ?) && NotIn(Owner, ?)", []interface{}{[]string{"pdf", "doc"}, []interface{}{"bob", nil}}},
+	{"In(Ext, ?) || NotIn(Owner, ?)", []interface{}{[]string{}, []string{}}},
+	{"In(Ext, [])", nil},
+	{"Exists(Owner) && NotExists(TempPath)", nil},
+	{"Len(Tags) == 2", nil},
+	{"All(Tags, ?)", []interface{}{[]string{"b", "a"}}},
+	{"Any(Privileges, func(p) bool { return p.User == ? && p.Write })", []interface{}{"bob"}},
+	{"Any(Scores, func(s) bool { return s >= 80 && s < 85 })", nil},
+	{"Search(?) && Size > 2.5", []interface{}{"annual report"}},
+	{"_id == 7", nil},
+}
+
+var rejected = []struct {
+	name   string
+	filter bson.D
+	code   expr.ErrorCode
+}{
+	{"$expr", bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{"$Size", "$Used"}}}}}, expr.ErrUnsupportedOperator},
+	{"date", bson.D{{Key: "Created", Value: bson.D{{Key: "$gt", Value: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}}}, expr.ErrInvalidOperand},
+	{"object id", bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, expr.ErrInvalidOperand},
+	{"embedded document", bson.D{{Key: "Owner", Value: bson.D{{Key: "Name", Value: "bob"}}}}, expr.ErrInvalidOperand},
+	{"empty all", bson.D{{Key: "Tags", Value: bson.D{{Key: "$all", Value: bson.A{}}}}}, expr.ErrInvalidOperand},
+	{"field name", bson.D{{Key: "content-type", Value: "pdf"}}, expr.ErrInvalidOperand},
+	{"unknown operator", bson.D{{Key: "Loc", Value: bson.D{{Key: "$near", Value: bson.A{1, 2}}}}}, expr.ErrUnsupportedOperator},
+}
+
+func main() {
+	failed := 0
+	for _, c := range formatCases {
+		q, err := expr.Compile(c.expr)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s: %v\n", c.name, c.expr, err)
+			failed++
+			continue
+		}
+		formatted := expr.Format(q)
+		if formatted != c.expected {
+			fmt.Printf("FAIL %s: %s\n  expected %s\n  actual   %s\n", c.name, c.expr, c.expected, formatted)
+			failed++
+			continue
+		}
+		// the normalized text compiles and is already normalized
+		again, err := expr.Compile(formatted)
+		if err != nil || expr.Format(again) != formatted {
+			fmt.Printf("FAIL %s: formatting %s again: %v\n", c.name, formatted, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+
+	for _, c := range roundTrips {
+		filter, err := expr.GetMongoQueryFromString(c.expr, c.args...)
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", c.expr, err)
+			failed++
+			continue
+		}
+		text, err := expr.FromBSON(filter)
+		if err != nil {
+			fmt.Printf("FAIL %s: FromBSON(%v): %v\n", c.expr, filter, err)
+			failed++
+			continue
+		}
+		// bind the arguments by converting the filter of the original expression, then compare the texts
+		expected, err := expr.FromBSON(mustFilter(text))
+		if err != nil || expected != text {
+			fmt.Printf("FAIL %s: %s is not stable: %s %v\n", c.expr, text, expected, err)
+			failed++
+			continue
+		}
+		if c.args == nil && text != expr.Format(expr.MustCompile(c.expr)) {
+			fmt.Printf("FAIL %s\n  Format   %s\n  FromBSON %s\n", c.expr, expr.Format(expr.MustCompile(c.expr)), text)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s -> %s\n", c.expr, text)
+	}
+
+	// a saved search stored as text and one stored as a filter compare equal
+	saved := bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 10}}}},
+		bson.D{{Key: "Ext", Value: bson.D{{Key: "$in", Value: bson.A{"pdf", "doc"}}}}},
+	}}}
+	text, err := expr.FromBSON(saved)
+	if err != nil || text != expr.Format(expr.MustCompile("in(Ext, \"doc\", \"pdf\") && 10 < Size")) {
+		fmt.Printf("FAIL saved search: %s %v\n", text, err)
+		failed++
+	} else {
+		fmt.Printf("ok   saved search: %s\n", text)
+	}
+
+	for _, c := range rejected {
+		_, err := expr.FromBSON(c.filter)
+		var e *expr.Error
+		if !errors.As(err, &e) || e.Code != c.code {
+			fmt.Printf("FAIL %s: expected %s, got %v\n", c.name, c.code, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s: %v\n", c.name, err)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func mustFilter(text string) bson.D {
+	filter, err := expr.GetMongoQueryFromString(text)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
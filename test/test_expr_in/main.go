@@ -0,0 +1,73 @@
+// checks the In/NotIn membership functions and slice placeholder arguments, run with: go run ./test/test_expr_in
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func in(field string, op string, values ...interface{}) bson.D {
+	return bson.D{{Key: field, Value: bson.D{{Key: op, Value: append(bson.A{}, values...)}}}}
+}
+
+func main() {
+	failed := 0
+	for _, c := range []struct {
+		name     string
+		expr     string
+		args     []interface{}
+		expected bson.D
+	}{
+		{"strings", "In(Ext, ?)", []interface{}{[]string{"doc", "pdf"}}, in("Ext", "$in", "doc", "pdf")},
+		{"ints", "In(Size, ?)", []interface{}{[]int{1, 2, 3}}, in("Size", "$in", 1, 2, 3)},
+		{"interfaces", "In(Owner, ?)", []interface{}{[]interface{}{"bob", 7}}, in("Owner", "$in", "bob", 7)},
+		{"nil value", "In(Owner, ?)", []interface{}{[]interface{}{"bob", nil}}, in("Owner", "$in", "bob", nil)},
+		{"constants", "In(Ext, \"doc\", \"pdf\")", nil, in("Ext", "$in", "doc", "pdf")},
+		{"placeholders", "In(Ext, ?, ?)", []interface{}{"doc", "pdf"}, in("Ext", "$in", "doc", "pdf")},
+		{"nil constant", "In(Owner, nil)", nil, in("Owner", "$in", nil)},
+		{"empty", "In(Ext, ?)", []interface{}{[]string{}}, in("Ext", "$in")},
+		{"empty literal", "NotIn(Ext, [])", nil, in("Ext", "$nin")},
+		{"selector", "In(Owner.Name, ?)", []interface{}{[]string{"a"}}, in("Owner.Name", "$in", "a")},
+		{"notin", "NotIn(Ext, ?)", []interface{}{[]string{"tmp", "bak"}}, in("Ext", "$nin", "tmp", "bak")},
+		{"case insensitive name", "notin(Ext, ?)", []interface{}{[]string{"tmp"}}, in("Ext", "$nin", "tmp")},
+		{"with other conditions", "In(Ext, ?) && Size > ?", []interface{}{[]string{"doc"}, 1}, bson.D{{Key: "$and", Value: bson.A{
+			in("Ext", "$in", "doc"),
+			bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 1}}}},
+		}}}},
+	} {
+		actual, err := expr.GetMongoQueryFromString(c.expr, c.args...)
+		if err != nil || !reflect.DeepEqual(actual, c.expected) {
+			fmt.Printf("FAIL %s: %s\n  expected %v\n  actual   %v %v\n", c.name, c.expr, c.expected, actual, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+
+	for _, c := range []struct {
+		name string
+		expr string
+		args []interface{}
+	}{
+		{"no values", "In(Ext)", nil},
+		{"no field", "In(\"doc\", ?)", []interface{}{[]string{"doc"}}},
+		{"field value", "In(Ext, Name)", nil},
+		{"index", "In(Ext[], \"a\")", nil},
+		{"unclosed list", "In(Ext, [)", nil},
+		{"nested slice", "In(Ext, ?)", []interface{}{[]interface{}{[]string{"doc"}}}},
+	} {
+		if _, err := expr.GetMongoQueryFromString(c.expr, c.args...); err == nil {
+			fmt.Printf("FAIL %s: %s: no error\n", c.name, c.expr)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
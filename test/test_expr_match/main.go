@@ -0,0 +1,128 @@
+// checks MatchBSON, CompareValues and Query.NumPlaceholders, run with: go run ./test/test_expr_match
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var created = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+var id = primitive.NewObjectID()
+
+var doc = bson.D{
+	{Key: "_id", Value: id},
+	{Key: "name", Value: "report.pdf"},
+	{Key: "size", Value: int32(2048)},
+	{Key: "created_on", Value: primitive.NewDateTimeFromTime(created)},
+	{Key: "tags", Value: bson.A{"finance", "q1"}},
+	{Key: "owner", Value: bson.D{{Key: "name", Value: "alice"}, {Key: "$ref", Value: "users"}}},
+	{Key: "deleted", Value: nil},
+}
+
+func filter(field string, op string, value interface{}) bson.D {
+	if op == "" {
+		return bson.D{{Key: field, Value: value}}
+	}
+	return bson.D{{Key: field, Value: bson.D{{Key: op, Value: value}}}}
+}
+
+func main() {
+	failed := 0
+	for _, c := range []struct {
+		name     string
+		filter   bson.D
+		expected bool
+	}{
+		{"empty", bson.D{}, true},
+		{"eq", filter("name", "", "report.pdf"), true},
+		{"eq other", filter("name", "", "notes.txt"), false},
+		{"number types", filter("size", "$gte", int64(2048)), true},
+		{"double", filter("size", "$lt", 2048.5), true},
+		{"date", filter("created_on", "$gt", created.Add(-time.Hour)), true},
+		{"object id", filter("_id", "", id), true},
+		{"array element", filter("tags", "", "q1"), true},
+		{"in", filter("tags", "$in", bson.A{"q2", "finance"}), true},
+		{"size", filter("tags", "$size", int32(2)), true},
+		{"dotted path", filter("owner.name", "", "alice"), true},
+		{"key not written in an expression", filter("owner.$ref", "", "users"), true},
+		{"null", filter("deleted", "", nil), true},
+		{"missing", filter("missing", "", nil), true},
+		{"exists", filter("missing", "$exists", true), false},
+		{"regex", filter("name", "$regex", primitive.Regex{Pattern: "^REPORT", Options: "i"}), true},
+		{"or", bson.D{{Key: "$or", Value: bson.A{filter("size", "$lt", 10), filter("name", "", "report.pdf")}}}, true},
+		{"nor", bson.D{{Key: "$nor", Value: bson.A{filter("size", "$lt", 10)}}}, true},
+		{"not", filter("name", "$not", bson.D{{Key: "$regex", Value: "pdf$"}}), false},
+	} {
+		actual, err := expr.MatchBSON(c.filter, doc)
+		if err != nil || actual != c.expected {
+			fmt.Printf("FAIL %s: %v: expected %v, got %v %v\n", c.name, c.filter, c.expected, actual, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+
+	for _, c := range []struct {
+		name   string
+		filter bson.D
+	}{
+		{"expr", bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{"$size", 1}}}}}},
+		{"unknown operator", filter("size", "$bitsAllSet", 1)},
+		{"where", bson.D{{Key: "$where", Value: "this.size > 1"}}},
+	} {
+		if _, err := expr.MatchBSON(c.filter, doc); err == nil {
+			fmt.Printf("FAIL %s: no error\n", c.name)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+
+	// the bson sort order: null, numbers, strings, documents, arrays, ..., booleans, dates
+	for _, c := range []struct {
+		a, b     interface{}
+		expected int
+	}{
+		{int32(2), int64(2), 0},
+		{int32(2), 2.5, -1},
+		{"b", "a", 1},
+		{nil, int32(0), -1},
+		{int64(10), "1", -1},
+		{true, false, 1},
+		{false, "z", 1},
+		{primitive.NewDateTimeFromTime(created), created.Add(time.Second), -1},
+	} {
+		if actual := expr.CompareValues(c.a, c.b); actual != c.expected {
+			fmt.Printf("FAIL compare %#v %#v: expected %d, got %d\n", c.a, c.b, c.expected, actual)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   compare %v %v\n", c.a, c.b)
+	}
+
+	for _, c := range []struct {
+		expr     string
+		expected int
+	}{
+		{"Size > 1", 0},
+		{"Size > ? && Name == ?", 2},
+		{"In(Ext, ?) || Ext == ?", 2},
+	} {
+		q, err := expr.Compile(c.expr)
+		if err != nil || q.NumPlaceholders() != c.expected {
+			fmt.Printf("FAIL placeholders %s: expected %d, got %v\n", c.expr, c.expected, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   placeholders %s\n", c.expr)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
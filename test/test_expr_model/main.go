@@ -0,0 +1,116 @@
+// checks the struct tag aware field mapping of expr, run with: go run ./test/test_expr_model
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	"github.com/unvs/models/accounts"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type Audit struct {
+	CreatedOn time.Time `field:"created_on"`
+	Note      string    `field:"audit_note"`
+}
+
+type Privilege struct {
+	User  string `field:"user"`
+	Write bool   `bson:"write"`
+}
+
+type File struct {
+	Audit
+	Note       string            `field:"note"`
+	FileName   string            `field:"file_name"`
+	SizeInByte int64             `bson:"size_in_byte,omitempty"`
+	Privileges []Privilege       `field:"privileges"`
+	Owner      *Privilege        `field:"owner"`
+	Meta       map[string]string `field:"meta"`
+	Untagged   string
+}
+
+type testCase struct {
+	name     string
+	run      func() (bson.D, error)
+	expected bson.D
+}
+
+var cases = []testCase{
+	{"accounts username", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[accounts.Accounts]("Username == ?", "admin")
+	}, bson.D{{Key: "username", Value: "admin"}}},
+	{"accounts id", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[accounts.Accounts]("ID == ?", 1)
+	}, bson.D{{Key: "_id", Value: 1}}},
+	{"document key is accepted", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[*File]("file_name == ?", "a")
+	}, bson.D{{Key: "file_name", Value: "a"}}},
+	{"bson tag", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[File]("SizeInByte > ?", 10)
+	}, bson.D{{Key: "size_in_byte", Value: bson.D{{Key: "$gt", Value: 10}}}}},
+	{"embedded struct", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[File]("CreatedOn != nil && Note == ?", "x")
+	}, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "created_on", Value: bson.D{{Key: "$ne", Value: nil}}}},
+		bson.D{{Key: "note", Value: "x"}},
+	}}}},
+	{"dotted path through array", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[File]("Privileges.User == ? && Privileges.Write", "bob")
+	}, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "privileges.user", Value: "bob"}},
+		bson.D{{Key: "privileges.write", Value: true}},
+	}}}},
+	{"dotted path through pointer", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[File]("Contains(Owner.User, ?)", "b")
+	}, bson.D{{Key: "owner.user", Value: bson.D{{Key: "$regex", Value: "b"}}}}},
+	{"any with element fields", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[File]("Any(Privileges, func(p) bool { return p.User == ? && p.Write })", "bob")
+	}, bson.D{{Key: "privileges", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "$and", Value: bson.A{bson.D{{Key: "user", Value: "bob"}}, bson.D{{Key: "write", Value: true}}}},
+	}}}}}},
+	{"map field", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[File]("Meta.Color == ?", "red")
+	}, bson.D{{Key: "meta.Color", Value: "red"}}},
+	{"untagged field", func() (bson.D, error) {
+		return expr.GetMongoQueryFor[File]("Untagged == ?", "u")
+	}, bson.D{{Key: "Untagged", Value: "u"}}},
+}
+
+var unknown = []string{
+	"Password == ?",
+	"Owner.Password == ?",
+	"CreatedOn.Year == ?",
+	"FileName.Length == ?",
+	"Any(Privileges, func(p) bool { return p.Password == ? })",
+}
+
+func main() {
+	failed := 0
+	for _, c := range cases {
+		actual, err := c.run()
+		if err != nil || !reflect.DeepEqual(actual, c.expected) {
+			fmt.Printf("FAIL %s\n  expected %v\n  actual   %v %v\n", c.name, c.expected, actual, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	for _, q := range unknown {
+		_, err := expr.CompileFor[File](q)
+		var e *expr.Error
+		if !errors.As(err, &e) || e.Code != expr.ErrUnknownField {
+			fmt.Printf("FAIL unknown field %q: %v\n", q, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %v\n", err)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,85 @@
+// checks for named (@name) parameters in expr, run with: go run ./test/test_expr_named
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type searchParams struct {
+	Tenant string
+	From   time.Time
+	To     time.Time
+}
+
+func main() {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	expected := bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "TenantId", Value: "t1"}},
+		bson.D{{Key: "CreatedOn", Value: bson.D{{Key: "$gte", Value: from}}}},
+		bson.D{{Key: "CreatedOn", Value: bson.D{{Key: "$lt", Value: to}}}},
+		bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "Owner", Value: "t1"}},
+			bson.D{{Key: "SharedWith", Value: "t1"}},
+		}}},
+	}}}
+	failed := 0
+	check := func(name string, actual bson.D, err error, wantErr bool) {
+		switch {
+		case wantErr && err == nil:
+			fmt.Printf("FAIL %s: expected an error, got %v\n", name, actual)
+			failed++
+		case wantErr:
+			fmt.Printf("ok   %s (%v)\n", name, err)
+		case err != nil:
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			failed++
+		case !reflect.DeepEqual(actual, expected):
+			fmt.Printf("FAIL %s\n  expected %v\n  actual   %v\n", name, expected, actual)
+			failed++
+		default:
+			fmt.Printf("ok   %s\n", name)
+		}
+	}
+	query := "TenantId == @tenant && CreatedOn >= @from && CreatedOn < @to && (Owner == @tenant || SharedWith == @tenant)"
+
+	actual, err := expr.GetMongoQueryFromNamed(query, map[string]interface{}{"tenant": "t1", "from": from, "to": to})
+	check("map", actual, err, false)
+
+	structQuery := "TenantId == @Tenant && CreatedOn >= @From && CreatedOn < @To && (Owner == @Tenant || SharedWith == @Tenant)"
+	actual, err = expr.GetMongoQueryFromStruct(structQuery, &searchParams{Tenant: "t1", From: from, To: to})
+	check("struct", actual, err, false)
+
+	actual, err = expr.GetMongoQueryFromNamed(query, map[string]interface{}{"tenant": "t1", "from": from})
+	check("missing parameter", actual, err, true)
+
+	actual, err = expr.GetMongoQueryFromNamed(query, map[string]interface{}{"tenant": "t1", "from": from, "to": to, "other": 1})
+	check("unused parameter", actual, err, true)
+
+	actual, err = expr.GetMongoQueryFromNamed("Name == @name && Size > ?", map[string]interface{}{"name": "x"})
+	check("mixed placeholders", actual, err, true)
+
+	actual, err = expr.GetMongoQueryFromString("Name == @name")
+	check("named in positional query", actual, err, true)
+
+	// identifiers looking like rewritten placeholders are fields
+	reserved, err := expr.GetMongoQueryFromNamed("__n_tenant == @tenant", map[string]interface{}{"tenant": "t1"})
+	if want := (bson.D{{Key: "__n_tenant", Value: "t1"}}); err != nil || !reflect.DeepEqual(reserved, want) {
+		fmt.Printf("FAIL reserved prefix\n  expected %v\n  actual   %v %v\n", want, reserved, err)
+		failed++
+	} else {
+		fmt.Printf("ok   reserved prefix\n")
+	}
+	_, err = expr.GetMongoQueryFromNamed("__n_tenant == 1", map[string]interface{}{"tenant": "t1"})
+	check("reserved prefix is not a parameter", nil, err, true)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
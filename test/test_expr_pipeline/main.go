@@ -0,0 +1,112 @@
+// checks the aggregation pipeline builder of expr, run with: go run ./test/test_expr_pipeline
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type testCase struct {
+	name     string
+	pipeline *expr.Pipeline
+	expected mongo.Pipeline
+}
+
+var cases = []testCase{
+	{"files and size per tenant", expr.NewPipeline().
+		Match("!Deleted").
+		Group("TenantId", map[string]string{"files": "count()", "size": "sum(Size)", "last": "max(CreatedOn)"}).
+		Sort("size desc"),
+		mongo.Pipeline{
+			{{Key: "$match", Value: bson.D{{Key: "Deleted", Value: bson.D{{Key: "$ne", Value: true}}}}}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$TenantId"},
+				{Key: "files", Value: bson.D{{Key: "$sum", Value: 1}}},
+				{Key: "last", Value: bson.D{{Key: "$max", Value: "$CreatedOn"}}},
+				{Key: "size", Value: bson.D{{Key: "$sum", Value: "$Size"}}},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "size", Value: -1}}}},
+		}},
+	{"per tenant and extension", expr.NewPipeline().
+		Group("TenantId, Info.Ext", map[string]string{"files": "count()"}),
+		mongo.Pipeline{
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{{Key: "TenantId", Value: "$TenantId"}, {Key: "Ext", Value: "$Info.Ext"}}},
+				{Key: "files", Value: bson.D{{Key: "$sum", Value: 1}}},
+			}}},
+		}},
+	{"per month", expr.NewPipeline().
+		Match("TenantId == ?", "t1").
+		Group("year: year(CreatedOn), month: month(CreatedOn)", map[string]string{"size": "sum(Size)"}).
+		Sort("_id.year, _id.month"),
+		mongo.Pipeline{
+			{{Key: "$match", Value: bson.D{{Key: "TenantId", Value: "t1"}}}},
+			{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{{Key: "year", Value: bson.D{{Key: "$year", Value: "$CreatedOn"}}}, {Key: "month", Value: bson.D{{Key: "$month", Value: "$CreatedOn"}}}}},
+				{Key: "size", Value: bson.D{{Key: "$sum", Value: "$Size"}}},
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "_id.year", Value: 1}, {Key: "_id.month", Value: 1}}}},
+		}},
+	{"all documents", expr.NewPipeline().
+		Group("", map[string]string{"tags": "addToSet(Tags)"}),
+		mongo.Pipeline{
+			{{Key: "$group", Value: bson.D{{Key: "_id", Value: nil}, {Key: "tags", Value: bson.D{{Key: "$addToSet", Value: "$Tags"}}}}}},
+		}},
+	{"unwind, lookup, project", expr.NewPipeline().
+		Unwind("Privileges").
+		Lookup("accounts", "Privileges.User", "username", "users").
+		Project("Name, -Content, day: day(CreatedOn)"),
+		mongo.Pipeline{
+			{{Key: "$unwind", Value: "$Privileges"}},
+			{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "accounts"}, {Key: "localField", Value: "Privileges.User"}, {Key: "foreignField", Value: "username"}, {Key: "as", Value: "users"}}}},
+			{{Key: "$project", Value: bson.D{{Key: "Name", Value: 1}, {Key: "Content", Value: 0}, {Key: "day", Value: bson.D{{Key: "$dayOfMonth", Value: "$CreatedOn"}}}}}},
+		}},
+	{"facet", expr.NewPipeline().
+		Facet(map[string]*expr.Pipeline{
+			"byExt":  expr.NewPipeline().Group("Ext", map[string]string{"n": "count()"}),
+			"recent": expr.NewPipeline().Sort("CreatedOn desc"),
+		}),
+		mongo.Pipeline{
+			{{Key: "$facet", Value: bson.D{
+				{Key: "byExt", Value: mongo.Pipeline{{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$Ext"}, {Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}}}}}}},
+				{Key: "recent", Value: mongo.Pipeline{{{Key: "$sort", Value: bson.D{{Key: "CreatedOn", Value: -1}}}}}},
+			}}},
+		}},
+}
+
+var invalid = map[string]*expr.Pipeline{
+	"unknown accumulator":  expr.NewPipeline().Group("Ext", map[string]string{"n": "median(Size)"}),
+	"unnamed computed key": expr.NewPipeline().Group("Ext, year(CreatedOn)", map[string]string{"n": "count()"}),
+	"invalid match":        expr.NewPipeline().Match("Size >").Sort("Name"),
+	"invalid facet":        expr.NewPipeline().Facet(map[string]*expr.Pipeline{"x": expr.NewPipeline().Sort("Name up")}),
+	"nil facet":            expr.NewPipeline().Facet(map[string]*expr.Pipeline{"x": expr.NewPipeline(), "y": nil}),
+}
+
+func main() {
+	failed := 0
+	for _, c := range cases {
+		actual, err := c.pipeline.Build()
+		if err != nil || !reflect.DeepEqual(actual, c.expected) {
+			fmt.Printf("FAIL %s\n  expected %v\n  actual   %v %v\n", c.name, c.expected, actual, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	for name, p := range invalid {
+		if _, err := p.Build(); err == nil {
+			fmt.Printf("FAIL %s: expected an error\n", name)
+			failed++
+		} else {
+			fmt.Printf("ok   %s: %v\n", name, err)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,121 @@
+// checks the translation of Search to $text and with a SearchHandler, run with: go run ./test/test_expr_search
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// searchByIds is a SearchHandler standing for another search backend which returns the ids of the matches.
+func searchByIds(field string, text string) (bson.D, error) {
+	if field == "" {
+		field = "content"
+	}
+	return bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{field, text}}}}}, nil
+}
+
+var accepted = []struct {
+	name     string
+	expr     string
+	args     []interface{}
+	handler  expr.SearchHandler
+	expected bson.D
+}{
+	{"text", "Search(?) && Ext == ?", []interface{}{"invoice", "pdf"}, nil, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "invoice"}}}},
+		bson.D{{Key: "Ext", Value: "pdf"}},
+	}}}},
+	{"text in or", "Search(?) || Size > 10", []interface{}{"invoice"}, nil, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "invoice"}}}},
+		bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 10}}}},
+	}}}},
+	{"handler field", "Search(Title, ?)", []interface{}{"report"}, searchByIds,
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{"Title", "report"}}}}}},
+	{"handler twice", "Search(?) && Search(Title, ?)", []interface{}{"invoice", "2024"}, searchByIds, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{"content", "invoice"}}}}},
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{"Title", "2024"}}}}},
+	}}}},
+}
+
+var rejected = []struct {
+	name    string
+	expr    string
+	args    []interface{}
+	handler expr.SearchHandler
+	code    expr.ErrorCode
+	text    string // the sub-expression the error must point at
+}{
+	{"two searches", "Search(?) && Search(?)", []interface{}{"a", "b"}, nil, expr.ErrInvalidOperand, "Search(?)"},
+	{"two searches in or", "Size > 1 || (Deleted && Search(\"a\")) || Search(\"b\")", nil, nil, expr.ErrInvalidOperand, "Search(\"b\")"},
+	{"search in lambda", "Any(Tags, func(t string) bool { return Search(t, \"x\") })", nil, nil, expr.ErrInvalidOperand, "Search(t, \"x\")"},
+	{"search in lambda and", "Any(Versions, func(v) bool { return v.Size > 1 && Search(?) })", []interface{}{"x"}, nil, expr.ErrInvalidOperand, "Search(?)"},
+	{"handler search in lambda", "Any(Tags, func(t) bool { return Search(t, ?) })", []interface{}{"x"}, searchByIds, expr.ErrInvalidOperand, "Search(t, ?)"},
+	{"field", "Search(Content, ?)", []interface{}{"a"}, nil, expr.ErrInvalidArgument, "Content"},
+	{"field and", "Ext == \"pdf\" && Search(Content, ?)", []interface{}{"a"}, nil, expr.ErrInvalidArgument, "Content"},
+}
+
+func filter(text string, args []interface{}, handler expr.SearchHandler) (bson.D, error) {
+	if handler == nil {
+		return expr.GetMongoQueryFromString(text, args...)
+	}
+	q, err := expr.Compile(text, expr.WithSearchHandler(handler))
+	if err != nil {
+		return nil, err
+	}
+	return q.Bind(args...)
+}
+
+func main() {
+	failed := 0
+	for _, c := range accepted {
+		actual, err := filter(c.expr, c.args, c.handler)
+		if err != nil || !reflect.DeepEqual(actual, c.expected) {
+			fmt.Printf("FAIL %s: %s\n  expected %v\n  actual   %v %v\n", c.name, c.expr, c.expected, actual, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+
+	for _, c := range rejected {
+		_, err := filter(c.expr, c.args, c.handler)
+		var e *expr.Error
+		switch {
+		case err == nil:
+			fmt.Printf("FAIL %s: expected an error\n", c.name)
+			failed++
+		case !errors.As(err, &e):
+			fmt.Printf("FAIL %s: expected *expr.Error, got %T: %v\n", c.name, err, err)
+			failed++
+		case e.Code != c.code || e.Expr != c.text:
+			fmt.Printf("FAIL %s: expected %s at %q, got %s at %q (%v)\n", c.name, c.code, c.text, e.Code, e.Expr, err)
+			failed++
+		default:
+			fmt.Printf("ok   %s: %v\n", c.name, err)
+		}
+	}
+
+	// the named parameters and the compiled queries check the searches the same way
+	_, err := expr.GetMongoQueryFromNamed("Search(@a) && Search(@b)", map[string]interface{}{"a": "x", "b": "y"})
+	if err == nil {
+		fmt.Printf("FAIL named two searches: expected an error\n")
+		failed++
+	} else {
+		fmt.Printf("ok   named two searches: %v\n", err)
+	}
+	q := expr.MustCompile("Search(?) || Search(?)")
+	if _, err := q.Bind("x", "y"); err == nil {
+		fmt.Printf("FAIL compiled two searches: expected an error\n")
+		failed++
+	} else {
+		fmt.Printf("ok   compiled two searches: %v\n", err)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
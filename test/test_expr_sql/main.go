@@ -0,0 +1,231 @@
+// checks the SQL translator of expr against an in-memory sqlite database, run with: go run ./test/test_expr_sql
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	"modernc.org/sqlite"
+)
+
+type testCase struct {
+	name     string
+	expr     string
+	args     []interface{}
+	expected []string // the names of the matched files
+}
+
+var cases = []testCase{
+	{"eq", "Ext == ?", []interface{}{"pdf"}, []string{"report.pdf"}},
+	{"ne", "Ext != \"tmp\"", nil, []string{"report.pdf", "notes_v2.txt", "Report-7.PDF"}},
+	{"ne matches null", "Owner != \"alice\"", nil, []string{"notes_v2.txt", "a*b.tmp"}},
+	{"range", "Size > ? && Size < ?", []interface{}{5, 3000}, []string{"report.pdf", "notes_v2.txt"}},
+	{"reversed", "10 < Size", nil, []string{"report.pdf", "Report-7.PDF"}},
+	{"field vs field", "Size > Used", nil, []string{"report.pdf"}},
+	{"float division", "Size / 4096 > 0.5", nil, []string{"Report-7.PDF"}},
+	{"modulo", "Size % 1000 == 48", nil, []string{"report.pdf"}},
+	{"nested negation", "-(-Size) > 3000 || -Size == -10", nil, []string{"notes_v2.txt", "Report-7.PDF"}},
+	{"or", "Ext == \"pdf\" || Size == 0", nil, []string{"report.pdf", "a*b.tmp"}},
+	{"bare field", "Deleted", nil, []string{"a*b.tmp"}},
+	{"not", "!Deleted", nil, []string{"report.pdf", "notes_v2.txt", "Report-7.PDF"}},
+	{"not matches null", "!(Owner == \"alice\")", nil, []string{"notes_v2.txt", "a*b.tmp"}},
+	{"nil", "Owner == nil", nil, []string{"notes_v2.txt"}},
+	{"not nil", "Owner != nil", nil, []string{"report.pdf", "a*b.tmp", "Report-7.PDF"}},
+	{"exists", "Exists(Owner) && NotExists(Used)", nil, []string{"a*b.tmp"}},
+	{"contains escaped", "Contains(Name, ?)", []interface{}{"*"}, []string{"a*b.tmp"}},
+	{"icontains escaped", "IContains(Name, ?)", []interface{}{"_"}, []string{"notes_v2.txt"}},
+	{"starts with", "StartsWith(Name, ?)", []interface{}{"report"}, []string{"report.pdf"}},
+	{"istarts with", "IStartsWith(Name, ?)", []interface{}{"report"}, []string{"report.pdf", "Report-7.PDF"}},
+	{"iends with", "IEndsWith(Name, ?)", []interface{}{".pdf"}, []string{"report.pdf", "Report-7.PDF"}},
+	{"regex", "Regex(Name, ?)", []interface{}{`\.tmp$`}, []string{"a*b.tmp"}},
+	{"regex flags", "Regex(Name, ?, \"i\")", []interface{}{`^report-\d+`}, []string{"Report-7.PDF"}},
+	{"in", "In(Ext, ?)", []interface{}{[]string{"pdf", "txt"}}, []string{"report.pdf", "notes_v2.txt"}},
+	{"in nil", "In(Owner, ?)", []interface{}{[]interface{}{"bob", nil}}, []string{"notes_v2.txt", "a*b.tmp"}},
+	{"in empty", "In(Ext, ?)", []interface{}{[]string{}}, nil},
+	{"not in", "NotIn(Owner, ?)", []interface{}{[]string{"alice"}}, []string{"notes_v2.txt", "a*b.tmp"}},
+	{"lower", "Lower(Ext) == ?", []interface{}{"pdf"}, []string{"report.pdf", "Report-7.PDF"}},
+	{"year", "Year(Created) == 2024", nil, []string{"report.pdf", "a*b.tmp", "Report-7.PDF"}},
+	{"month", "Month(Created) == 6", nil, []string{"Report-7.PDF"}},
+	{"date", "Created >= ?", []interface{}{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, []string{"report.pdf", "Report-7.PDF"}},
+}
+
+// the generated SQL of both dialects
+var clauses = []struct {
+	expr     string
+	args     []interface{}
+	dialect  expr.SQLDialect
+	clause   string
+	expected []interface{}
+}{
+	{"Ext == ? && Size > ?", []interface{}{"pdf", 10}, expr.SQLite, `("Ext" = ? AND "Size" > ?)`, []interface{}{"pdf", 10}},
+	{"Ext == ? && Size > ?", []interface{}{"pdf", 10}, expr.PostgreSQL, `("Ext" = $1 AND "Size" > $2)`, []interface{}{"pdf", 10}},
+	{"_id == ? && Owner.Name != ?", []interface{}{1, "bob"}, expr.PostgreSQL,
+		`("id" = $1 AND ("Owner"."Name" <> $2 OR "Owner"."Name" IS NULL))`, []interface{}{1, "bob"}},
+	{"Contains(Name, ?)", []interface{}{"50%"}, expr.PostgreSQL, `"Name" LIKE $1 ESCAPE '\'`, []interface{}{`%50\%%`}},
+	{"Contains(Name, ?)", []interface{}{"a[1]"}, expr.SQLite, `"Name" GLOB ?`, []interface{}{"*a[[]1]*"}},
+	{"IStartsWith(Name, ?)", []interface{}{"a_"}, expr.PostgreSQL, `"Name" ILIKE $1 ESCAPE '\'`, []interface{}{`a\_%`}},
+	{"Regex(Name, ?, \"i\")", []interface{}{"^a"}, expr.PostgreSQL, `"Name" ~* $1`, []interface{}{"^a"}},
+	{"In(Ext, ?) || Size > ?", []interface{}{[]string{"a", "b"}, 3}, expr.PostgreSQL, `("Ext" IN ($1, $2) OR "Size" > $3)`, []interface{}{"a", "b", 3}},
+	{"Year(Created) == ?", []interface{}{2024}, expr.PostgreSQL, `EXTRACT(YEAR FROM "Created") = $1`, []interface{}{2024}},
+	{"Size * 2 > Used + 1", nil, expr.SQLite, `("Size" * ?) > ("Used" + ?)`, []interface{}{2, 1}},
+	{"-(-Size) > ?", []interface{}{1}, expr.PostgreSQL, `(-(-"Size")) > $1`, []interface{}{1}},
+}
+
+var unsupported = []struct {
+	expr    string
+	dialect expr.SQLDialect
+	code    expr.ErrorCode
+	at      string
+}{
+	{"Len(Tags) == 2", expr.SQLite, expr.ErrUnsupportedNode, "Len(Tags)"},
+	{"Any(Tags, func(t) bool { return t == \"a\" })", expr.SQLite, expr.ErrUnsupportedFunction, "Any(Tags, func(t) bool { return t == \"a\" })"},
+	{"Search(\"report\")", expr.SQLite, expr.ErrUnsupportedFunction, "Search(\"report\")"},
+	{"Regex(Name, \"a\", \"m\")", expr.PostgreSQL, expr.ErrInvalidArgument, "\"m\""},
+}
+
+var files = []struct {
+	name, ext string
+	size      int
+	used      interface{}
+	deleted   bool
+	owner     interface{}
+	created   time.Time
+}{
+	{"report.pdf", "pdf", 2048, 100, false, "alice", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	{"notes_v2.txt", "txt", 10, 10, false, nil, time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)},
+	{"a*b.tmp", "tmp", 0, nil, true, "bob", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+	{"Report-7.PDF", "PDF", 4096, 5000, false, "alice", time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)},
+}
+
+func open() (*sql.DB, error) {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, _ := args[0].(string)
+		value, ok := args[1].(string)
+		if !ok {
+			return nil, nil
+		}
+		return regexp.MatchString(pattern, value)
+	})
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	// every connection gets its own in-memory database
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE files (id INTEGER PRIMARY KEY, Name TEXT, Ext TEXT, Size INTEGER, Used INTEGER,
+		Deleted BOOLEAN, Owner TEXT, Created TEXT)`)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		_, err := db.Exec(`INSERT INTO files (Name, Ext, Size, Used, Deleted, Owner, Created) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			f.name, f.ext, f.size, f.used, f.deleted, f.owner, f.created.Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func query(db *sql.DB, where *expr.SQLWhere) ([]string, error) {
+	rows, err := db.Query("SELECT Name FROM files WHERE "+where.Clause+" ORDER BY id", where.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func main() {
+	db, err := open()
+	if err != nil {
+		fmt.Printf("FAIL opening sqlite: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	failed := 0
+	for _, c := range cases {
+		// the dates are stored as text, bind them the same way
+		args := make([]interface{}, len(c.args))
+		for i, arg := range c.args {
+			if t, ok := arg.(time.Time); ok {
+				arg = t.Format("2006-01-02 15:04:05")
+			}
+			args[i] = arg
+		}
+		e, err := expr.Parse(c.expr, args...)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s: %v\n", c.name, c.expr, err)
+			failed++
+			continue
+		}
+		where, err := expr.SQLTranslator{Dialect: expr.SQLite}.Where(e)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s: %v\n", c.name, c.expr, err)
+			failed++
+			continue
+		}
+		names, err := query(db, where)
+		if err != nil || !reflect.DeepEqual(names, c.expected) {
+			fmt.Printf("FAIL %s: %s\n  sql      %s %v\n  expected %v\n  actual   %v %v\n", c.name, c.expr, where.Clause, where.Args, c.expected, names, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	for _, c := range clauses {
+		out, err := expr.Translate(expr.SQLTranslator{Dialect: c.dialect}, c.expr, c.args...)
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", c.expr, err)
+			failed++
+			continue
+		}
+		where := out.(*expr.SQLWhere)
+		if where.Clause != c.clause || !reflect.DeepEqual(where.Args, c.expected) {
+			fmt.Printf("FAIL %s\n  expected %s %v\n  actual   %s %v\n", c.expr, c.clause, c.expected, where.Clause, where.Args)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", where.Clause)
+	}
+	for _, c := range unsupported {
+		_, err := expr.Translate(expr.SQLTranslator{Dialect: c.dialect}, c.expr)
+		var e *expr.Error
+		if !errors.As(err, &e) || e.Code != c.code || e.Expr != c.at {
+			fmt.Printf("FAIL %q: expected %s at %q, got %v\n", c.expr, c.code, c.at, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %v\n", err)
+	}
+
+	// a custom column mapping
+	lower := expr.SQLTranslator{Column: func(field string) string { return strings.ToLower(field) }}
+	out, err := expr.Translate(lower, "Size > ?", 1)
+	if err != nil || out.(*expr.SQLWhere).Clause != "size > ?" {
+		fmt.Printf("FAIL column mapping: %v %v\n", out, err)
+		failed++
+	} else {
+		fmt.Printf("ok   column mapping\n")
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,239 @@
+// table driven checks for the expr to mongo filter translation, run with: go run ./test/test_expr_translate
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type status int
+
+func (s status) String() string {
+	return [...]string{"draft", "published"}[s]
+}
+
+type owner struct {
+	Name string
+}
+
+var (
+	uploadedFrom = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	uploadedTo   = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	fileID       = primitive.NewObjectID()
+)
+
+type testCase struct {
+	name     string
+	expr     string
+	args     []interface{}
+	expected bson.D
+}
+
+var cases = []testCase{
+	{"gt", "Size > ?", []interface{}{10}, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 10}}}}},
+	{"gte", "Size >= ?", []interface{}{10}, bson.D{{Key: "Size", Value: bson.D{{Key: "$gte", Value: 10}}}}},
+	{"lt", "Size < ?", []interface{}{10}, bson.D{{Key: "Size", Value: bson.D{{Key: "$lt", Value: 10}}}}},
+	{"lte", "Size <= ?", []interface{}{10}, bson.D{{Key: "Size", Value: bson.D{{Key: "$lte", Value: 10}}}}},
+	{"eq", "Name == ?", []interface{}{"a.txt"}, bson.D{{Key: "Name", Value: "a.txt"}}},
+	{"ne", "Name != ?", []interface{}{"a.txt"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$ne", Value: "a.txt"}}}}},
+	{"and", "Size > 1 && Size < 10", nil, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 1}}}},
+		bson.D{{Key: "Size", Value: bson.D{{Key: "$lt", Value: 10}}}},
+	}}}},
+	{"or", "Ext == \"doc\" || Ext == \"pdf\"", nil, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "Ext", Value: "doc"}},
+		bson.D{{Key: "Ext", Value: "pdf"}},
+	}}}},
+	{"and flattened", "A == 1 && B == 2 && C == 3", nil, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "A", Value: 1}},
+		bson.D{{Key: "B", Value: 2}},
+		bson.D{{Key: "C", Value: 3}},
+	}}}},
+	{"or inside and", "A == 1 && (B == 2 || C == 3)", nil, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "A", Value: 1}},
+		bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "B", Value: 2}}, bson.D{{Key: "C", Value: 3}}}}},
+	}}}},
+	{"constant on the left", "10 < Size", nil, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 10}}}}},
+	{"selector", "Owner.Name == ?", []interface{}{"x"}, bson.D{{Key: "Owner.Name", Value: "x"}}},
+	{"id", "id == ?", []interface{}{1}, bson.D{{Key: "_id", Value: 1}}},
+	{"field vs field", "ModifiedOn > CreatedOn", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{"$ModifiedOn", "$CreatedOn"}}}}}},
+	{"field vs field eq", "A == B", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$A", "$B"}}}}}},
+	{"any", "Any(Privileges, func(p) bool { return p.User == ? && p.Write })", []interface{}{"bob"}, bson.D{{Key: "Privileges", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "$and", Value: bson.A{bson.D{{Key: "User", Value: "bob"}}, bson.D{{Key: "Write", Value: true}}}},
+	}}}}}},
+	{"any typed parameter", "Any(Versions, func(v Version) bool { return v.Size > 10 })", nil, bson.D{{Key: "Versions", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "Size", Value: bson.D{{Key: "$gt", Value: 10}}},
+	}}}}}},
+	{"any element itself", "Any(Scores, func(s) bool { return s >= 80 && s < 85 })", nil, bson.D{{Key: "Scores", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "$gte", Value: 80}, {Key: "$lt", Value: 85},
+	}}}}}},
+	{"any element equal", "Any(Tags, func(t) bool { return t == ? })", []interface{}{"q1"}, bson.D{{Key: "Tags", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "$eq", Value: "q1"}}}}}}},
+	{"any nested", "Any(Versions, func(v) bool { return Any(v.Files, func(f) bool { return f.Ext == \"pdf\" }) })", nil, bson.D{{Key: "Versions", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+		{Key: "Files", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "Ext", Value: "pdf"}}}}},
+	}}}}}},
+	{"not any", "!Any(Tags, func(t) bool { return t == \"x\" })", nil, bson.D{{Key: "Tags", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$elemMatch", Value: bson.D{{Key: "$eq", Value: "x"}}}}}}}}},
+	{"all", "All(Tags, ?)", []interface{}{[]string{"a", "b"}}, bson.D{{Key: "Tags", Value: bson.D{{Key: "$all", Value: bson.A{"a", "b"}}}}}},
+	{"len eq", "Len(Tags) == ?", []interface{}{2}, bson.D{{Key: "Tags", Value: bson.D{{Key: "$size", Value: 2}}}}},
+	{"len eq reversed", "3 == Len(Tags)", nil, bson.D{{Key: "Tags", Value: bson.D{{Key: "$size", Value: 3}}}}},
+	{"len ne", "Len(Tags) != 0", nil, bson.D{{Key: "Tags", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$size", Value: 0}}}}}}},
+	{"len gt", "Len(Tags) > 2", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{bson.D{{Key: "$size", Value: "$Tags"}}, 2}}}}}},
+	{"constant arithmetic folded", "Size > 10 * 1024", nil, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: int64(10240)}}}}},
+	{"large arithmetic folded", "Size > ? * 2", []interface{}{int64(1 << 61)}, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: int64(1 << 62)}}}}},
+	{"placeholder arithmetic folded", "Size > ? * 1024", []interface{}{2}, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: int64(2048)}}}}},
+	{"arithmetic on field", "Size / 1024 > ?", []interface{}{4}, bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{
+		bson.D{{Key: "$divide", Value: bson.A{"$Size", 1024}}}, 4,
+	}}}}}},
+	{"add flattened", "A + B + C > 10", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{
+		bson.D{{Key: "$add", Value: bson.A{"$A", "$B", "$C"}}}, 10,
+	}}}}}},
+	{"mod", "Size % 2 == 0", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$mod", Value: bson.A{"$Size", 2}}}, 0}}}}}},
+	{"negated field", "-A < B", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{bson.D{{Key: "$multiply", Value: bson.A{-1, "$A"}}}, "$B"}}}}}},
+	{"lower", "lower(Name) == ?", []interface{}{"a.txt"}, bson.D{{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$toLower", Value: "$Name"}}, "a.txt"}}}}}},
+	{"len", "len(Tags) > 2", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$gt", Value: bson.A{bson.D{{Key: "$size", Value: "$Tags"}}, 2}}}}}},
+	{"year", "year(CreatedOn) == 2024", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{bson.D{{Key: "$year", Value: "$CreatedOn"}}, 2024}}}}}},
+	{"eq nil", "Deleted == nil", nil, bson.D{{Key: "Deleted", Value: nil}}},
+	{"nil on the left", "nil == Deleted", nil, bson.D{{Key: "Deleted", Value: nil}}},
+	{"ne nil", "Deleted != nil", nil, bson.D{{Key: "Deleted", Value: bson.D{{Key: "$ne", Value: nil}}}}},
+	{"nil argument", "Deleted == ?", []interface{}{nil}, bson.D{{Key: "Deleted", Value: nil}}},
+	{"not eq nil", "!(Deleted == nil)", nil, bson.D{{Key: "Deleted", Value: bson.D{{Key: "$ne", Value: nil}}}}},
+	{"is null", "IsNull(Deleted)", nil, bson.D{{Key: "Deleted", Value: nil}}},
+	{"exists", "Exists(Deleted)", nil, bson.D{{Key: "Deleted", Value: bson.D{{Key: "$exists", Value: true}}}}},
+	{"not exists", "NotExists(Deleted)", nil, bson.D{{Key: "Deleted", Value: bson.D{{Key: "$exists", Value: false}}}}},
+	{"not not exists", "!NotExists(Deleted)", nil, bson.D{{Key: "Deleted", Value: bson.D{{Key: "$exists", Value: true}}}}},
+	{"bare field", "IsPublic", nil, bson.D{{Key: "IsPublic", Value: true}}},
+	{"in", "In(Ext, ?)", []interface{}{[]string{"doc", "pdf"}}, bson.D{{Key: "Ext", Value: bson.D{{Key: "$in", Value: bson.A{"doc", "pdf"}}}}}},
+	{"notin", "NotIn(Ext, ?)", []interface{}{[]string{"tmp"}}, bson.D{{Key: "Ext", Value: bson.D{{Key: "$nin", Value: bson.A{"tmp"}}}}}},
+	{"not eq", "!(Name == ?)", []interface{}{"a"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$ne", Value: "a"}}}}},
+	{"not ne", "!(Name != ?)", []interface{}{"a"}, bson.D{{Key: "Name", Value: "a"}}},
+	{"not gt", "!(Size > 10)", nil, bson.D{{Key: "Size", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: 10}}}}}}},
+	{"not bare field", "!Deleted", nil, bson.D{{Key: "Deleted", Value: bson.D{{Key: "$ne", Value: true}}}}},
+	{"double not", "!!Deleted", nil, bson.D{{Key: "Deleted", Value: true}}},
+	{"not in", "!In(Ext, ?)", []interface{}{[]string{"tmp"}}, bson.D{{Key: "Ext", Value: bson.D{{Key: "$nin", Value: bson.A{"tmp"}}}}}},
+	{"contains escaped", "Contains(Name, ?)", []interface{}{"a.b(1)"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: `a\.b\(1\)`}}}}},
+	{"starts with escaped", "StartsWith(Name, ?)", []interface{}{"[draft]"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: `^\[draft\]`}}}}},
+	{"ends with escaped", "EndsWith(Name, ?)", []interface{}{".tar.gz"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: `\.tar\.gz$`}}}}},
+	{"icontains", "IContains(Name, ?)", []interface{}{"Report"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: "Report"}, {Key: "$options", Value: "i"}}}}},
+	{"istartswith", "IStartsWith(Name, ?)", []interface{}{"a+"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: `^a\+`}, {Key: "$options", Value: "i"}}}}},
+	{"iendswith", "IEndsWith(Name, ?)", []interface{}{".PDF"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: `\.PDF$`}, {Key: "$options", Value: "i"}}}}},
+	{"regex", "Regex(Name, ?)", []interface{}{`^report-\d+$`}, bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: `^report-\d+$`}}}}},
+	{"regex flags", "Regex(Name, ?, \"im\")", []interface{}{`^report`}, bson.D{{Key: "Name", Value: bson.D{{Key: "$regex", Value: `^report`}, {Key: "$options", Value: "im"}}}}},
+	{"not icontains", "!IContains(Name, ?)", []interface{}{"tmp"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$regex", Value: "tmp"}, {Key: "$options", Value: "i"}}}}}}},
+	{"not contains", "!Contains(Name, ?)", []interface{}{"tmp"}, bson.D{{Key: "Name", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$regex", Value: "tmp"}}}}}}},
+	{"not and", "!(Deleted && Contains(Name, ?))", []interface{}{"tmp"}, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "Deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
+		bson.D{{Key: "Name", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$regex", Value: "tmp"}}}}}},
+	}}}},
+	{"not or", "!(A == 1 || B == 2)", nil, bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "A", Value: 1}}, bson.D{{Key: "B", Value: 2}}}}}},
+	{"and of nots", "!Deleted && !EndsWith(Name, ?)", []interface{}{".tmp"}, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "Deleted", Value: bson.D{{Key: "$ne", Value: true}}}},
+		bson.D{{Key: "Name", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$regex", Value: `\.tmp$`}}}}}},
+	}}}},
+	{"not field vs field", "!(A > B)", nil, bson.D{{Key: "$expr", Value: bson.D{{Key: "$not", Value: bson.A{bson.D{{Key: "$gt", Value: bson.A{"$A", "$B"}}}}}}}}},
+	{"string with quotes", "Name == ?", []interface{}{`a "b" \\ c?`}, bson.D{{Key: "Name", Value: `a "b" \\ c?`}}},
+	{"question mark in literal", "Name == \"why?\" && Size > ?", []interface{}{1}, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "Name", Value: "why?"}},
+		bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 1}}}},
+	}}}},
+	{"bool", "Deleted == ?", []interface{}{false}, bson.D{{Key: "Deleted", Value: false}}},
+	{"bool literal", "Deleted == true", nil, bson.D{{Key: "Deleted", Value: true}}},
+	{"int64", "Size > ?", []interface{}{int64(1 << 40)}, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: int64(1 << 40)}}}}},
+	{"uint", "Size > ?", []interface{}{uint(7)}, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: int64(7)}}}}},
+	{"negative literal", "Size > -1", nil, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: -1}}}}},
+	{"placeholder prefix is a field", "__p0 == ?", []interface{}{1}, bson.D{{Key: "__p0", Value: 1}}},
+	{"hex literal", "Size > 0x10", nil, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 16}}}}},
+	{"octal and binary literals", "Size == 0o17 || Size == 0b101", nil, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "Size", Value: 15}}, bson.D{{Key: "Size", Value: 5}},
+	}}}},
+	{"separated literal", "Size > 1_000", nil, bson.D{{Key: "Size", Value: bson.D{{Key: "$gt", Value: 1000}}}}},
+	{"time range", "UploadedOn >= ? && UploadedOn < ?", []interface{}{uploadedFrom, uploadedTo}, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "UploadedOn", Value: bson.D{{Key: "$gte", Value: uploadedFrom}}}},
+		bson.D{{Key: "UploadedOn", Value: bson.D{{Key: "$lt", Value: uploadedTo}}}},
+	}}}},
+	{"object id", "id == ?", []interface{}{fileID}, bson.D{{Key: "_id", Value: fileID}}},
+	{"object id pointer", "id == ?", []interface{}{&fileID}, bson.D{{Key: "_id", Value: fileID}}},
+	{"bytes", "Hash == ?", []interface{}{[]byte{1, 2}}, bson.D{{Key: "Hash", Value: []byte{1, 2}}}},
+	{"stringer", "Status == ?", []interface{}{status(1)}, bson.D{{Key: "Status", Value: "published"}}},
+	{"nested struct", "Owner == ?", []interface{}{owner{Name: "x"}}, bson.D{{Key: "Owner", Value: owner{Name: "x"}}}},
+	{"document", "Owner == ?", []interface{}{bson.D{{Key: "Name", Value: "x"}}}, bson.D{{Key: "Owner", Value: bson.D{{Key: "Name", Value: "x"}}}}},
+	{"document map", "Owner == ?", []interface{}{bson.M{"Name": "x"}}, bson.D{{Key: "Owner", Value: bson.M{"Name": "x"}}}},
+	{"in documents", "In(Owner, ?)", []interface{}{[]bson.D{{{Key: "Name", Value: "x"}}, {{Key: "Name", Value: "y"}}}},
+		bson.D{{Key: "Owner", Value: bson.D{{Key: "$in", Value: bson.A{bson.D{{Key: "Name", Value: "x"}}, bson.D{{Key: "Name", Value: "y"}}}}}}}},
+	{"nil argument", "Deleted != ?", []interface{}{nil}, bson.D{{Key: "Deleted", Value: bson.D{{Key: "$ne", Value: nil}}}}},
+	{"search", "Search(?)", []interface{}{"annual report"}, bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "annual report"}}}}},
+	{"search and", "Search(?) && Ext == \"pdf\"", []interface{}{"invoice"}, bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "invoice"}}}},
+		bson.D{{Key: "Ext", Value: "pdf"}},
+	}}}},
+}
+
+// searchByIds is a SearchHandler standing for another search backend which returns the ids of the matches.
+func searchByIds(field string, text string) (bson.D, error) {
+	if field == "" {
+		field = "content_bm25"
+	}
+	return bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{field, text}}}}}, nil
+}
+
+func main() {
+	failed := 0
+	for _, c := range cases {
+		actual, err := expr.GetMongoQueryFromString(c.expr, c.args...)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s: %v\n", c.name, c.expr, err)
+			failed++
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			fmt.Printf("FAIL %s: %s\n  expected %v\n  actual   %v\n", c.name, c.expr, c.expected, actual)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	handled := expr.MustCompile("Search(?) && Ext == ?")
+	q, err := expr.Compile("Search(?) && Ext == ?", expr.WithSearchHandler(searchByIds))
+	var actual bson.D
+	if err == nil {
+		actual, err = q.Bind("invoice", "pdf")
+	}
+	if expected := (bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{"content_bm25", "invoice"}}}}},
+		bson.D{{Key: "Ext", Value: "pdf"}},
+	}}}); err != nil || !reflect.DeepEqual(actual, expected) {
+		fmt.Printf("FAIL search handler\n  expected %v\n  actual   %v %v\n", expected, actual, err)
+		failed++
+	} else {
+		fmt.Printf("ok   search handler\n")
+	}
+	// the handler only applies to the query compiled with it
+	actual, err = handled.Bind("invoice", "pdf")
+	if expected := (bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "invoice"}}}},
+		bson.D{{Key: "Ext", Value: "pdf"}},
+	}}}); err != nil || !reflect.DeepEqual(actual, expected) {
+		fmt.Printf("FAIL search handler scope\n  expected %v\n  actual   %v %v\n", expected, actual, err)
+		failed++
+	} else {
+		fmt.Printf("ok   search handler scope\n")
+	}
+	e, err := expr.Parse("Search(Content, ?)", "invoice")
+	if err == nil {
+		actual, err = expr.MongoTranslator{SearchHandler: searchByIds}.Filter(e)
+	}
+	if expected := (bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{"Content", "invoice"}}}}}); err != nil || !reflect.DeepEqual(actual, expected) {
+		fmt.Printf("FAIL translator search handler\n  expected %v\n  actual   %v %v\n", expected, actual, err)
+		failed++
+	} else {
+		fmt.Printf("ok   translator search handler\n")
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d cases failed\n", failed, len(cases))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,152 @@
+// checks the update expressions of expr, run with: go run ./test/test_expr_update
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type Owner struct {
+	Name  string `field:"name"`
+	Email string `field:"email"`
+}
+
+type File struct {
+	Name          string    `field:"file_name"`
+	DownloadCount int64     `bson:"download_count"`
+	Tags          []string  `field:"tags"`
+	TempPath      *string   `field:"temp_path"`
+	ModifiedOn    time.Time `field:"modified_on"`
+	Owner         Owner     `field:"owner"`
+}
+
+type testCase struct {
+	name     string
+	run      func() (bson.D, error)
+	expected bson.D
+}
+
+var cases = []testCase{
+	{"request example", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(Name, ?), Inc(DownloadCount, 1), Push(Tags, ?), Unset(TempPath), CurrentDate(ModifiedOn)", "a.txt", "q1")
+	}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "Name", Value: "a.txt"}}},
+		{Key: "$inc", Value: bson.D{{Key: "DownloadCount", Value: 1}}},
+		{Key: "$push", Value: bson.D{{Key: "Tags", Value: "q1"}}},
+		{Key: "$unset", Value: bson.D{{Key: "TempPath", Value: ""}}},
+		{Key: "$currentDate", Value: bson.D{{Key: "ModifiedOn", Value: true}}},
+	}},
+	{"operators are grouped", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(A, 1), Inc(B, -1), Set(C, ?)", "c")
+	}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "A", Value: 1}, {Key: "C", Value: "c"}}},
+		{Key: "$inc", Value: bson.D{{Key: "B", Value: -1}}},
+	}},
+	{"set nil", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(A, nil), Set(B, ?)", nil)
+	}, bson.D{{Key: "$set", Value: bson.D{{Key: "A", Value: nil}, {Key: "B", Value: nil}}}}},
+	{"constant arithmetic", func() (bson.D, error) {
+		return expr.ParseUpdate("Mul(Size, 1024 * ?)", 2)
+	}, bson.D{{Key: "$mul", Value: bson.D{{Key: "Size", Value: int64(2048)}}}}},
+	{"each", func() (bson.D, error) {
+		return expr.ParseUpdate("PushAll(Tags, ?), AddAllToSet(Labels, ?), PullAll(Old, ?)", []string{"a", "b"}, []string{"c"}, []int{1})
+	}, bson.D{
+		{Key: "$push", Value: bson.D{{Key: "Tags", Value: bson.D{{Key: "$each", Value: bson.A{"a", "b"}}}}}},
+		{Key: "$addToSet", Value: bson.D{{Key: "Labels", Value: bson.D{{Key: "$each", Value: bson.A{"c"}}}}}},
+		{Key: "$pullAll", Value: bson.D{{Key: "Old", Value: bson.A{1}}}},
+	}},
+	{"pop and rename", func() (bson.D, error) {
+		return expr.ParseUpdate("Pop(Queue), PopFirst(Stack), Rename(Nick, Name), Min(Low, 1), Max(High, 9)")
+	}, bson.D{
+		{Key: "$pop", Value: bson.D{{Key: "Queue", Value: 1}, {Key: "Stack", Value: -1}}},
+		{Key: "$rename", Value: bson.D{{Key: "Nick", Value: "Name"}}},
+		{Key: "$min", Value: bson.D{{Key: "Low", Value: 1}}},
+		{Key: "$max", Value: bson.D{{Key: "High", Value: 9}}},
+	}},
+	{"string containing a comma", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(Name, \"a, b\"), SetOnInsert(CreatedBy, ?)", "bob")
+	}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "Name", Value: "a, b"}}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "CreatedBy", Value: "bob"}}},
+	}},
+	{"model tags", func() (bson.D, error) {
+		return expr.ParseUpdateFor[File]("Set(Name, ?), Inc(DownloadCount, 1), Set(Owner.Email, ?), Unset(TempPath)", "a.txt", "b@x")
+	}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "file_name", Value: "a.txt"}, {Key: "owner.email", Value: "b@x"}}},
+		{Key: "$inc", Value: bson.D{{Key: "download_count", Value: 1}}},
+		{Key: "$unset", Value: bson.D{{Key: "temp_path", Value: ""}}},
+	}},
+}
+
+var failures = []struct {
+	name string
+	run  func() (bson.D, error)
+	code expr.ErrorCode
+	text string
+}{
+	{"same path", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(Count, 1), Inc(Count, 1)")
+	}, expr.ErrInvalidArgument, "Count"},
+	{"parent path", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(Owner, ?), Set(Owner.Name, ?)", nil, "x")
+	}, expr.ErrInvalidArgument, "Owner.Name"},
+	{"rename target", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(Name, 1), Rename(Nick, Name)")
+	}, expr.ErrInvalidArgument, "Name"},
+	{"inc string", func() (bson.D, error) {
+		return expr.ParseUpdate("Inc(Count, ?)", "1")
+	}, expr.ErrInvalidArgument, "?"},
+	{"push all scalar", func() (bson.D, error) {
+		return expr.ParseUpdate("PushAll(Tags, ?)", "a")
+	}, expr.ErrInvalidArgument, "?"},
+	{"not a function", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(A, 1), B == 1")
+	}, expr.ErrInvalidOperand, "B == 1"},
+	{"unknown function", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(A, 1), Drop(B)")
+	}, expr.ErrUnsupportedFunction, "Drop(B)"},
+	{"argument count", func() (bson.D, error) {
+		return expr.ParseUpdate("Unset(A, 1)")
+	}, expr.ErrInvalidArgument, "Unset(A, 1)"},
+	{"value is not constant", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(A, B)")
+	}, expr.ErrInvalidArgument, "B"},
+	{"placeholder count", func() (bson.D, error) {
+		return expr.ParseUpdate("Set(A, ?), Set(B, ?)", 1)
+	}, expr.ErrParameter, ""},
+	{"unknown field", func() (bson.D, error) {
+		return expr.ParseUpdateFor[File]("Set(Name, ?), Set(Owner.Phone, ?)", "a", "b")
+	}, expr.ErrUnknownField, "Owner.Phone"},
+}
+
+func main() {
+	failed := 0
+	for _, c := range cases {
+		actual, err := c.run()
+		if err != nil || !reflect.DeepEqual(actual, c.expected) {
+			fmt.Printf("FAIL %s\n  expected %v\n  actual   %v %v\n", c.name, c.expected, actual, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	for _, c := range failures {
+		_, err := c.run()
+		var e *expr.Error
+		if !errors.As(err, &e) || e.Code != c.code || e.Expr != c.text {
+			fmt.Printf("FAIL %s: expected %s at %q, got %v\n", c.name, c.code, c.text, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s: %v\n", c.name, err)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,188 @@
+// checks the index declarations of models and dbcontext.EnsureIndexes against the in-memory fake,
+// run with: go run ./test/test_indexes
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	dbcontext "github.com/unvs/libs/db/ctx"
+	"github.com/unvs/libs/db/ctx/dbtest"
+	dbmodels "github.com/unvs/libs/db/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Owner struct {
+	Name string `field:"name"`
+}
+
+type File struct {
+	tableName struct{}  `table:"files"`
+	ID        int       `field:"id"`
+	Path      string    `field:"path" index:"unique"`
+	Owner     Owner     `field:"owner"`
+	CreatedOn time.Time `field:"created_on" index:"desc"`
+	ExpiresOn time.Time `field:"expires_on" index:"ttl=24h,sparse"`
+	Body      string    `field:"body" index:"text,name=body_search"`
+}
+
+func (File) Indexes() []dbmodels.Index {
+	return []dbmodels.Index{{Keys: bson.D{{Key: "Owner.Name", Value: 1}, {Key: "CreatedOn", Value: -1}}}}
+}
+
+// FileV2 is the next version of File: the path index is no longer unique and the TTL index is gone
+type FileV2 struct {
+	tableName struct{}  `table:"files"`
+	ID        int       `field:"id"`
+	Path      string    `field:"path" index:"asc"`
+	Owner     Owner     `field:"owner"`
+	CreatedOn time.Time `field:"created_on" index:"desc"`
+	Body      string    `field:"body" index:"text,name=body_search"`
+}
+
+func (*FileV2) Indexes() []dbmodels.Index {
+	return []dbmodels.Index{{Keys: bson.D{{Key: "owner.name", Value: 1}, {Key: "created_on", Value: -1}}}}
+}
+
+type BadOption struct {
+	tableName struct{} `table:"bad"`
+	A         string   `field:"a" index:"uniq"`
+}
+
+type BadTTL struct {
+	tableName struct{} `table:"bad"`
+	A         string   `field:"a"`
+	B         string   `field:"b"`
+}
+
+func (BadTTL) Indexes() []dbmodels.Index {
+	return []dbmodels.Index{{Keys: bson.D{{Key: "A", Value: 1}, {Key: "B", Value: 1}}, TTL: true}}
+}
+
+type UnknownField struct {
+	tableName struct{} `table:"bad"`
+}
+
+func (UnknownField) Indexes() []dbmodels.Index {
+	return []dbmodels.Index{{Keys: bson.D{{Key: "Missing", Value: 1}}}}
+}
+
+type Tag struct {
+	tableName struct{} `table:"tags"`
+	Name      string   `field:"name"`
+}
+
+func indexNames(db *dbcontext.DB, collection string) []string {
+	indexes, err := dbcontext.ListIndexes(context.Background(), db, collection)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	names := []string{}
+	for _, idx := range indexes {
+		names = append(names, idx.Name)
+	}
+	return names
+}
+
+func main() {
+	failed := 0
+	check := func(name string, actual, expected interface{}) {
+		if !reflect.DeepEqual(actual, expected) {
+			fmt.Printf("FAIL %s\n  expected %#v\n  actual   %#v\n", name, expected, actual)
+			failed++
+			return
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+
+	m, err := dbmodels.For[File]()
+	indexes, err2 := m.Indexes()
+	if err != nil || err2 != nil {
+		fmt.Printf("FAIL metadata: %v %v\n", err, err2)
+		os.Exit(1)
+	}
+	check("declared indexes", indexes, []dbmodels.Index{
+		{Name: "path_1", Keys: bson.D{{Key: "path", Value: int32(1)}}, Unique: true},
+		{Name: "created_on_-1", Keys: bson.D{{Key: "created_on", Value: int32(-1)}}},
+		{Name: "expires_on_1", Keys: bson.D{{Key: "expires_on", Value: int32(1)}}, Sparse: true, TTL: true, ExpireAfter: 24 * time.Hour},
+		{Name: "body_search", Keys: bson.D{{Key: "body", Value: "text"}}},
+		{Name: "owner.name_1_created_on_-1", Keys: bson.D{{Key: "owner.name", Value: int32(1)}, {Key: "created_on", Value: int32(-1)}}},
+	})
+
+	db := dbtest.NewFake("tenant")
+	reports, err := dbcontext.EnsureIndexes(context.Background(), db, File{}, Tag{})
+	check("create", []interface{}{reports, err}, []interface{}{[]dbcontext.IndexReport{
+		{Collection: "files", Created: []string{"path_1", "created_on_-1", "expires_on_1", "body_search", "owner.name_1_created_on_-1"}},
+		{Collection: "tags"},
+	}, nil})
+	check("list", indexNames(db, "files"),
+		[]string{"_id_", "path_1", "created_on_-1", "expires_on_1", "body_search", "owner.name_1_created_on_-1"})
+
+	reports, err = dbcontext.EnsureIndexes(context.Background(), db, &File{})
+	check("existing", []interface{}{reports, err}, []interface{}{[]dbcontext.IndexReport{
+		{Collection: "files", Existing: []string{"path_1", "created_on_-1", "expires_on_1", "body_search", "owner.name_1_created_on_-1"}},
+	}, nil})
+
+	// unique indexes are enforced by the fake
+	_, err = dbcontext.InsertMany(context.Background(), db, []File{{ID: 1, Path: "/a"}, {ID: 2, Path: "/b"}})
+	check("unique insert", err, nil)
+	_, err = dbcontext.InsertOne(context.Background(), db, File{ID: 3, Path: "/a"})
+	check("duplicate insert", mongo.IsDuplicateKeyError(err), true)
+	_, err = dbcontext.UpdateOne[File](context.Background(), db, "ID == 2", "Set(Path, ?)", "/a")
+	check("duplicate update", mongo.IsDuplicateKeyError(err), true)
+
+	// a dry run changes nothing
+	reports, err = dbcontext.EnsureIndexesWith(context.Background(), db, []dbcontext.IndexOption{dbcontext.WithDryRun(), dbcontext.WithDropExtra()}, FileV2{})
+	check("dry run", []interface{}{reports, err}, []interface{}{[]dbcontext.IndexReport{{
+		Collection: "files",
+		Existing:   []string{"created_on_-1", "body_search", "owner.name_1_created_on_-1"},
+		Changed:    []string{"path_1"},
+		Extra:      []string{"expires_on_1"},
+		Dropped:    []string{"path_1", "expires_on_1"},
+	}}, nil})
+	check("dry run list", indexNames(db, "files"),
+		[]string{"_id_", "path_1", "created_on_-1", "expires_on_1", "body_search", "owner.name_1_created_on_-1"})
+
+	// without dropping, the changes are only reported
+	reports, err = dbcontext.EnsureIndexes(context.Background(), db, FileV2{})
+	check("report only", []interface{}{reports[0].Changed, reports[0].Extra, reports[0].Dropped, err},
+		[]interface{}{[]string{"path_1"}, []string{"expires_on_1"}, []string(nil), nil})
+
+	reports, err = dbcontext.EnsureIndexesWith(context.Background(), db, []dbcontext.IndexOption{dbcontext.WithDropExtra()}, FileV2{})
+	check("drop extra", []interface{}{reports[0].Dropped, err}, []interface{}{[]string{"path_1", "expires_on_1"}, nil})
+	check("drop extra list", indexNames(db, "files"),
+		[]string{"_id_", "created_on_-1", "body_search", "owner.name_1_created_on_-1", "path_1"})
+	_, err = dbcontext.InsertOne(context.Background(), db, File{ID: 3, Path: "/a"})
+	check("not unique anymore", err, nil)
+
+	// a unique index cannot be created over duplicates
+	_, err = dbcontext.EnsureIndexesWith(context.Background(), db, []dbcontext.IndexOption{dbcontext.WithDropExtra()}, File{})
+	check("create over duplicates", mongo.IsDuplicateKeyError(err), true)
+
+	for _, c := range []struct {
+		name  string
+		model interface{}
+		err   string
+	}{
+		{"bad option", BadOption{}, `unknown index option "uniq"`},
+		{"bad ttl", BadTTL{}, "a TTL index has a single key"},
+		{"unknown field", UnknownField{}, "unknown field Missing"},
+		{"no table", Owner{}, "has no table tag"},
+	} {
+		_, err := dbcontext.EnsureIndexes(context.Background(), db, c.model)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			fmt.Printf("FAIL %s: unexpected error %v\n", c.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s: %v\n", c.name, err)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+// checks dbcontext.Iter against the in-memory fake, run with: go run ./test/test_iter
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	dbcontext "github.com/unvs/libs/db/ctx"
+	"github.com/unvs/libs/db/ctx/dbtest"
+	expr "github.com/unvs/libs/db/expr"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type File struct {
+	tableName struct{} `table:"files"`
+	ID        int      `field:"id"`
+	Name      string   `field:"name"`
+	Size      int64    `field:"size"`
+}
+
+type result struct {
+	ids  []int
+	errs []error
+}
+
+func collect(seq func(yield func(File, error) bool), stop int) result {
+	r := result{ids: []int{}}
+	for file, err := range seq {
+		if err != nil {
+			r.errs = append(r.errs, err)
+			continue
+		}
+		r.ids = append(r.ids, file.ID)
+		if len(r.ids) == stop {
+			break
+		}
+	}
+	return r
+}
+
+func main() {
+	db := dbtest.NewFake("tenant")
+	files := make([]File, 0, 2500)
+	for i := 1; i <= 2500; i++ {
+		files = append(files, File{ID: i, Name: fmt.Sprintf("f%04d", i), Size: int64(i % 10)})
+	}
+	if _, err := dbcontext.InsertMany(context.Background(), db, files); err != nil {
+		fmt.Printf("FAIL insert: %v\n", err)
+		os.Exit(1)
+	}
+	// a document which cannot be decoded into File
+	if err := dbcontext.InsertOneByDictIn(context.Background(), db, "files", map[string]interface{}{"_id": 9999, "name": bson.A{"x"}}); err != nil {
+		fmt.Printf("FAIL insert: %v\n", err)
+		os.Exit(1)
+	}
+
+	failed := 0
+	check := func(name string, ok bool, detail interface{}) {
+		if !ok {
+			fmt.Printf("FAIL %s: %v\n", name, detail)
+			failed++
+			return
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+
+	all := collect(dbcontext.Iter[File](context.Background(), db, "ID < 9999"), 0)
+	check("all", len(all.ids) == 2500 && all.errs == nil && all.ids[2499] == 2500, len(all.ids))
+
+	filtered := collect(dbcontext.IterBatch[File](context.Background(), db, 10, "Size == ? order by ID desc limit 3", 7), 0)
+	check("filtered", reflect.DeepEqual(filtered.ids, []int{2497, 2487, 2477}) && filtered.errs == nil, filtered)
+
+	early := collect(dbcontext.Iter[File](context.Background(), db, "order by ID"), 5)
+	check("break", reflect.DeepEqual(early.ids, []int{1, 2, 3, 4, 5}), early)
+
+	// the iteration goes on after a document which cannot be decoded
+	decode := collect(dbcontext.Iter[File](context.Background(), db, "ID > 2498"), 0)
+	check("decode error", reflect.DeepEqual(decode.ids, []int{2499, 2500}) && len(decode.errs) == 1, decode)
+
+	var e *expr.Error
+	bad := collect(dbcontext.Iter[File](context.Background(), db, "Size >"), 0)
+	check("bad query", len(bad.ids) == 0 && len(bad.errs) == 1 && errors.As(bad.errs[0], &e), bad)
+
+	// the iteration ends with the error of ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := result{}
+	for file, err := range dbcontext.Iter[File](ctx, db, "order by ID") {
+		if err != nil {
+			cancelled.errs = append(cancelled.errs, err)
+			continue
+		}
+		cancelled.ids = append(cancelled.ids, file.ID)
+		if file.ID == 3 {
+			cancel()
+		}
+	}
+	check("cancel", len(cancelled.ids) == 3 && len(cancelled.errs) == 1 && errors.Is(cancelled.errs[0], context.Canceled), cancelled)
+
+	// each iteration runs the query again
+	seq := dbcontext.Iter[File](context.Background(), db, "ID <= 2")
+	first, second := collect(seq, 0), collect(seq, 0)
+	check("reusable", reflect.DeepEqual(first, second) && len(first.ids) == 2, second)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,227 @@
+// checks the models metadata registry and its bson codec, run with: go run ./test/test_models
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	expr "github.com/unvs/libs/db/expr"
+	dbmodels "github.com/unvs/libs/db/models"
+	"github.com/unvs/models"
+	"github.com/unvs/models/accounts"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Audit struct {
+	CreatedBy string    `field:"created_by"`
+	CreatedOn time.Time `field:"created_on"`
+	Note      string    `field:"note"`
+}
+
+type Owner struct {
+	Name  string `field:"name"`
+	Email string `field:"email,omitempty"`
+}
+
+type File struct {
+	tableName struct{} `table:"files"`
+	Audit
+	ID       primitive.ObjectID     `field:"id"`
+	Name     string                 `field:"file_name" index:"unique"`
+	Size     int64                  `field:"size"`
+	Tags     []string               `field:"tags,omitempty"`
+	Owner    *Owner                 `field:"owner"`
+	Extra    interface{}            `field:"extra"`
+	Meta     map[string]interface{} `field:"meta,omitempty"`
+	Legacy   string                 `bson:"legacy_name,omitempty"`
+	Note     string                 `field:"file_note"` // shadows Audit.Note
+	Ignored  string                 `field:"-"`
+	Untagged int
+}
+
+type Duplicated struct {
+	A string `field:"a"`
+	B string `field:"a"`
+}
+
+type BadOption struct {
+	A string `field:"a,minsize"`
+}
+
+func metadataCases() []error {
+	var errs []error
+	m, err := dbmodels.For[File]()
+	if err != nil {
+		return []error{err}
+	}
+	if m.Collection != "files" {
+		errs = append(errs, fmt.Errorf("collection %q", m.Collection))
+	}
+	if m.ID == nil || m.ID.Name != "ID" {
+		errs = append(errs, fmt.Errorf("id field %v", m.ID))
+	}
+	keys := []string{}
+	for _, f := range m.Fields {
+		keys = append(keys, f.Name+":"+f.Key)
+	}
+	expected := []string{"ID:_id", "Name:file_name", "Size:size", "Tags:tags", "Owner:owner", "Extra:extra", "Meta:meta",
+		"Legacy:legacy_name", "Note:file_note", "Untagged:Untagged", "CreatedBy:created_by", "CreatedOn:created_on"}
+	if !reflect.DeepEqual(keys, expected) {
+		errs = append(errs, fmt.Errorf("fields\n  expected %v\n  actual   %v", expected, keys))
+	}
+	if f, ok := m.FieldByKey("file_name"); !ok || f.IndexHint != "unique" {
+		errs = append(errs, fmt.Errorf("index hint of file_name: %v", f))
+	}
+	if f, ok := m.FieldByName("Tags"); !ok || !f.OmitEmpty {
+		errs = append(errs, fmt.Errorf("omitempty of Tags: %v", f))
+	}
+	if f, ok := m.FieldByName("CreatedOn"); !ok || !reflect.DeepEqual(f.Index, []int{1, 1}) {
+		errs = append(errs, fmt.Errorf("index of CreatedOn: %v", f))
+	}
+	if again, _ := dbmodels.Of(reflect.TypeOf(&File{})); again != m {
+		errs = append(errs, fmt.Errorf("the metadata is not cached"))
+	}
+	return errs
+}
+
+var rejected = []struct {
+	name  string
+	value interface{}
+	err   string
+}{
+	{"duplicate key", Duplicated{}, "fields A and B are both stored as a"},
+	{"unknown option", BadOption{}, `unknown option "minsize"`},
+	{"not a struct", 5, "model must be a struct"},
+	{"no table tag", Owner{}, "has no table tag"},
+}
+
+func main() {
+	failed := 0
+	if errs := metadataCases(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Printf("FAIL metadata: %v\n", err)
+		}
+		failed++
+	} else {
+		fmt.Printf("ok   metadata\n")
+	}
+
+	for _, c := range rejected {
+		err := dbmodels.Register(c.value)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			fmt.Printf("FAIL %s: unexpected error %v\n", c.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s: %v\n", c.name, err)
+	}
+
+	// encoding uses the keys of the metadata, omitempty fields are left out
+	id := primitive.NewObjectID()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	file := File{
+		Audit: Audit{CreatedBy: "bob", CreatedOn: created, Note: "hidden"},
+		ID:    id, Name: "report.pdf", Size: 2048, Owner: &Owner{Name: "alice"}, Note: "visible",
+		Ignored: "x", Untagged: 3,
+	}
+	data, err := dbmodels.Marshal(file)
+	var doc bson.D
+	if err == nil {
+		err = bson.Unmarshal(data, &doc)
+	}
+	expected := bson.D{
+		{Key: "_id", Value: id}, {Key: "file_name", Value: "report.pdf"}, {Key: "size", Value: int64(2048)}, {Key: "owner", Value: bson.D{{Key: "name", Value: "alice"}}},
+		{Key: "extra", Value: nil}, {Key: "file_note", Value: "visible"}, {Key: "Untagged", Value: int32(3)},
+		{Key: "created_by", Value: "bob"}, {Key: "created_on", Value: primitive.NewDateTimeFromTime(created)},
+	}
+	if err != nil || !reflect.DeepEqual(doc, expected) {
+		fmt.Printf("FAIL encode: %v\n  expected %v\n  actual   %v\n", err, expected, doc)
+		failed++
+	} else {
+		fmt.Printf("ok   encode\n")
+	}
+
+	// decoding skips unknown keys and converts the numbers
+	data, _ = bson.Marshal(bson.D{
+		{Key: "_id", Value: id}, {Key: "file_name", Value: "a.txt"}, {Key: "size", Value: int32(12)}, {Key: "unknown", Value: "x"}, {Key: "tags", Value: bson.A{"q1"}},
+		{Key: "extra", Value: bson.D{{Key: "k", Value: 1}}}, {Key: "legacy_name", Value: "old"}, {Key: "owner", Value: nil}, {Key: "created_by", Value: "carol"},
+	})
+	var decoded File
+	err = dbmodels.Unmarshal(data, &decoded)
+	want := File{Audit: Audit{CreatedBy: "carol"}, ID: id, Name: "a.txt", Size: 12, Tags: []string{"q1"},
+		Extra: bson.D{{Key: "k", Value: int32(1)}}, Legacy: "old"}
+	if err != nil || !reflect.DeepEqual(decoded, want) {
+		fmt.Printf("FAIL decode: %v\n  expected %+v\n  actual   %+v\n", err, want, decoded)
+		failed++
+	} else {
+		fmt.Printf("ok   decode\n")
+	}
+
+	// the round trip of a slice of models and a model in a bson.D
+	data, err = dbmodels.Marshal(bson.D{{Key: "files", Value: []File{file}}})
+	var wrapper struct {
+		Files []File `field:"files"`
+	}
+	if err == nil {
+		err = dbmodels.Unmarshal(data, &wrapper)
+	}
+	// the ignored and shadowed fields are not stored, the time comes back in the local zone
+	file.Ignored, file.Audit.Note = "", ""
+	if len(wrapper.Files) == 1 && wrapper.Files[0].CreatedOn.Equal(created) {
+		file.CreatedOn = wrapper.Files[0].CreatedOn
+	}
+	if err != nil || len(wrapper.Files) != 1 || !reflect.DeepEqual(wrapper.Files[0], file) {
+		fmt.Printf("FAIL round trip: %v\n  expected %+v\n  actual   %+v\n", err, file, wrapper.Files)
+		failed++
+	} else {
+		fmt.Printf("ok   round trip\n")
+	}
+
+	// a zero _id is not stored, the driver generates one
+	data, err = dbmodels.Marshal(File{Name: "new.txt"})
+	if err == nil && bson.Raw(data).Lookup("_id").Type != 0 {
+		err = fmt.Errorf("_id is stored: %v", bson.Raw(data))
+	}
+	if err != nil {
+		fmt.Printf("FAIL zero id: %v\n", err)
+		failed++
+	} else {
+		fmt.Printf("ok   zero id\n")
+	}
+
+	// filters compiled for a model use the keys the codec stores
+	filter, err := expr.GetMongoQueryFor[File]("Name == ? && CreatedBy == ? && Owner.Name == ?", "a", "b", "c")
+	expectedFilter := bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "file_name", Value: "a"}}, bson.D{{Key: "created_by", Value: "b"}}, bson.D{{Key: "owner.name", Value: "c"}}}}}
+	if err != nil || !reflect.DeepEqual(filter, expectedFilter) {
+		fmt.Printf("FAIL filter keys: %v %v\n", filter, err)
+		failed++
+	} else {
+		fmt.Printf("ok   filter keys\n")
+	}
+
+	// the models of models.Model are registered
+	if err := models.Register(); err != nil {
+		fmt.Printf("FAIL register: %v\n", err)
+		failed++
+	} else {
+		found := false
+		for _, m := range dbmodels.Registered() {
+			if m.Type == reflect.TypeOf(accounts.Accounts{}) && m.Collection == "accounts" && m.ID != nil {
+				found = true
+			}
+		}
+		if !found {
+			fmt.Printf("FAIL register: accounts.Accounts is not registered\n")
+			failed++
+		} else {
+			fmt.Printf("ok   register\n")
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
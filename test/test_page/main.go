@@ -0,0 +1,177 @@
+// checks the keyset pagination of dbcontext.Page against the in-memory fake, run with: go run ./test/test_page
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	dbcontext "github.com/unvs/libs/db/ctx"
+	"github.com/unvs/libs/db/ctx/dbtest"
+)
+
+type File struct {
+	tableName struct{}  `table:"files"`
+	ID        int       `field:"id"`
+	Name      string    `field:"name"`
+	Owner     *string   `field:"owner"`
+	Size      int64     `field:"size"`
+	CreatedOn time.Time `field:"created_on"`
+}
+
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func files() []File {
+	owners := []string{"alice", "bob"}
+	result := make([]File, 0, 100)
+	for i := 1; i <= 100; i++ {
+		f := File{ID: i, Name: fmt.Sprintf("f%03d", (i*37)%100), Size: int64(i % 7), CreatedOn: base.Add(time.Duration(i%13) * time.Hour)}
+		if i%5 != 0 {
+			// every fifth file has no owner
+			f.Owner = &owners[i%2]
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+func ids(list []File) []int {
+	result := []int{}
+	for _, f := range list {
+		result = append(result, f.ID)
+	}
+	return result
+}
+
+// pages collects the ids of every page of a request and the number of pages
+func pages(db *dbcontext.DB, req dbcontext.PageRequest) ([]int, int, error) {
+	all := []int{}
+	count := 0
+	for {
+		page, err := dbcontext.Page[File](context.Background(), db, req)
+		if err != nil {
+			return all, count, err
+		}
+		count++
+		all = append(all, ids(page.Items)...)
+		if page.NextToken == "" {
+			return all, count, nil
+		}
+		req.Token = page.NextToken
+	}
+}
+
+func main() {
+	db := dbtest.NewFake("tenant")
+	if _, err := dbcontext.InsertMany(context.Background(), db, files()); err != nil {
+		fmt.Printf("FAIL insert: %v\n", err)
+		os.Exit(1)
+	}
+	failed := 0
+
+	// the pages together are the documents in the order of a find with the _id tiebreaker
+	for _, c := range []struct {
+		name   string
+		filter string
+		args   []interface{}
+		sort   string
+		order  string // the order by clause of the reference find
+		size   int
+	}{
+		{"by id", "", nil, "", "_id", 10},
+		{"by date", "", nil, "CreatedOn", "CreatedOn, _id", 7},
+		{"by date desc", "", nil, "CreatedOn desc", "CreatedOn desc, _id", 9},
+		{"two keys", "", nil, "Size desc, Name", "Size desc, Name, _id", 11},
+		{"nullable asc", "", nil, "Owner, CreatedOn desc", "Owner, CreatedOn desc, _id", 6},
+		{"nullable desc", "", nil, "Owner desc, Size", "Owner desc, Size, _id", 8},
+		{"filtered", "Size > ? && Owner != nil", []interface{}{2}, "CreatedOn desc", "CreatedOn desc, _id", 5},
+		{"id desc", "", nil, "_id desc", "_id desc", 30},
+		{"one page", "Size == 0", nil, "Name", "Name, _id", 50},
+	} {
+		expected, err := dbcontext.Find[File](context.Background(), db, c.filter+" order by "+c.order, c.args...)
+		if err != nil {
+			fmt.Printf("FAIL %s: find: %v\n", c.name, err)
+			failed++
+			continue
+		}
+		actual, count, err := pages(db, dbcontext.PageRequest{Filter: c.filter, Args: c.args, Sort: c.sort, Size: c.size})
+		want := (len(expected) + c.size - 1) / c.size
+		if err != nil || !reflect.DeepEqual(actual, ids(expected)) || count != max(want, 1) {
+			fmt.Printf("FAIL %s: %v, %d pages\n  expected %v\n  actual   %v\n", c.name, err, count, ids(expected), actual)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s: %d pages\n", c.name, count)
+	}
+
+	first, err := dbcontext.Page[File](context.Background(), db, dbcontext.PageRequest{Sort: "CreatedOn", Size: 10})
+	if err != nil {
+		fmt.Printf("FAIL first page: %v\n", err)
+		os.Exit(1)
+	}
+
+	// documents inserted before the position of a token do not shift the next pages
+	if _, err := dbcontext.InsertOne(context.Background(), db, File{ID: 101, Name: "new", CreatedOn: base.Add(-time.Hour)}); err != nil {
+		fmt.Printf("FAIL insert: %v\n", err)
+		os.Exit(1)
+	}
+	second, err := dbcontext.Page[File](context.Background(), db, dbcontext.PageRequest{Sort: "CreatedOn", Size: 10, Token: first.NextToken})
+	reference, _ := dbcontext.Find[File](context.Background(), db, "_id != 101 order by CreatedOn, _id skip 10 limit 10")
+	if err != nil || !reflect.DeepEqual(ids(second.Items), ids(reference)) {
+		fmt.Printf("FAIL stable: %v %v %v\n", err, ids(second.Items), ids(reference))
+		failed++
+	} else {
+		fmt.Printf("ok   stable\n")
+	}
+
+	tampered := []byte(first.NextToken)
+	tampered[len(tampered)/2] ^= 1
+	for _, c := range []struct {
+		name string
+		req  dbcontext.PageRequest
+	}{
+		{"tampered", dbcontext.PageRequest{Sort: "CreatedOn", Token: string(tampered)}},
+		{"garbage", dbcontext.PageRequest{Sort: "CreatedOn", Token: "not a token!"}},
+		{"other sort", dbcontext.PageRequest{Sort: "CreatedOn desc", Token: first.NextToken}},
+		{"other filter", dbcontext.PageRequest{Filter: "Size > 1", Sort: "CreatedOn", Token: first.NextToken}},
+	} {
+		_, err := dbcontext.Page[File](context.Background(), db, c.req)
+		if !errors.Is(err, dbcontext.ErrInvalidPageToken) {
+			fmt.Printf("FAIL %s: unexpected error %v\n", c.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s: %v\n", c.name, err)
+	}
+
+	// a token is signed with the key of the process
+	dbcontext.SetPageTokenKey([]byte("another key"))
+	if _, err := dbcontext.Page[File](context.Background(), db, dbcontext.PageRequest{Sort: "CreatedOn", Token: first.NextToken}); !errors.Is(err, dbcontext.ErrInvalidPageToken) {
+		fmt.Printf("FAIL other key: unexpected error %v\n", err)
+		failed++
+	} else {
+		fmt.Printf("ok   other key\n")
+	}
+
+	for _, c := range []struct {
+		name string
+		req  dbcontext.PageRequest
+	}{
+		{"bad sort", dbcontext.PageRequest{Sort: "Missing"}},
+		{"bad filter", dbcontext.PageRequest{Filter: "Size >"}},
+		{"negative size", dbcontext.PageRequest{Size: -1}},
+	} {
+		if _, err := dbcontext.Page[File](context.Background(), db, c.req); err == nil {
+			fmt.Printf("FAIL %s: no error\n", c.name)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}